@@ -0,0 +1,337 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of analytics events
+const (
+	eventView     = "view"
+	eventDownload = "download"
+)
+
+// AnalyticsEvent is a line of the analytics file. Visitors are identified
+// only by a hash that changes every day, so no event can be tied to a
+// person or linked to their visits on other days.
+type AnalyticsEvent struct {
+	Time    time.Time `json:"time"`
+	Kind    string    `json:"kind"`
+	Post    string    `json:"post,omitempty"`
+	Book    string    `json:"book,omitempty"`
+	Chapter string    `json:"chapter,omitempty"`
+	Visitor string    `json:"visitor"`
+}
+
+// analyticsRecorder appends page views and downloads to a file
+type analyticsRecorder struct {
+	path  string
+	file  *os.File
+	token string // of the dashboard; empty to turn it off
+
+	mu       sync.Mutex
+	salt     []byte
+	saltDate string
+}
+
+// analytics records events when the server was started with an analytics
+// file. It is nil, and recording does nothing, otherwise.
+var analytics *analyticsRecorder
+
+// openAnalytics opens the analytics file for appending, creating it if needed
+func openAnalytics(path, token string) (*analyticsRecorder, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &analyticsRecorder{path: path, file: file, token: token}, nil
+}
+
+// visitorHash returns an anonymous identifier for the visitor making a
+// request. It hashes the address and user agent with a random salt that is
+// kept only in memory and replaced at midnight UTC.
+func (a *analyticsRecorder) visitorHash(r *http.Request, now time.Time) string {
+	host := clientAddr(r)
+
+	day := now.UTC().Format(time.DateOnly)
+	if a.saltDate != day {
+		a.salt = make([]byte, 32)
+		rand.Read(a.salt)
+		a.saltDate = day
+	}
+
+	h := sha256.New()
+	h.Write(a.salt)
+	h.Write([]byte(host))
+	h.Write([]byte{0})
+	h.Write([]byte(r.UserAgent()))
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+// clientAddr returns the address of the client making a request. Behind a
+// reverse proxy on the same machine that is the first forwarded address.
+func clientAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		// Unix sockets have no address of their own
+		host = ""
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsLoopback()) {
+		if forwarded := r.Header.Get("X-Forwarded-For"); forwarded != "" {
+			first, _, _ := strings.Cut(forwarded, ",")
+			return strings.TrimSpace(first)
+		}
+	}
+	return host
+}
+
+// record appends an event for a request, skipping crawlers
+func (a *analyticsRecorder) record(r *http.Request, event AnalyticsEvent) {
+	if a == nil || isCrawler(r.UserAgent()) {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	event.Time = time.Now().UTC().Truncate(time.Second)
+	event.Visitor = a.visitorHash(r, event.Time)
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("Error encoding analytics event: %v", err)
+		return
+	}
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		log.Printf("Error writing analytics event: %v", err)
+	}
+}
+
+// isCrawler reports whether a user agent belongs to a bot rather than a reader
+func isCrawler(userAgent string) bool {
+	ua := strings.ToLower(userAgent)
+	if ua == "" {
+		return true
+	}
+	for _, marker := range []string{"bot", "crawl", "spider", "slurp", "curl/", "wget/", "python-", "go-http-client"} {
+		if strings.Contains(ua, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// isFirstRange reports whether a download request fetches the file from
+// its start, so that resumed downloads aren't counted twice
+func isFirstRange(r *http.Request) bool {
+	rng := r.Header.Get("Range")
+	return rng == "" || strings.HasPrefix(rng, "bytes=0-")
+}
+
+// readEvents reads every event in the analytics file
+func (a *analyticsRecorder) readEvents() ([]AnalyticsEvent, error) {
+	file, err := os.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var events []AnalyticsEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event AnalyticsEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			// A line cut short by a crash is skipped
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, scanner.Err()
+}
+
+// AnalyticsCount is a row of the dashboard
+type AnalyticsCount struct {
+	Label    string
+	URL      string
+	Views    int
+	Visitors int
+	Percent  int // of the book's first chapter, for drop-off
+}
+
+// AnalyticsBook shows how far readers get through a book
+type AnalyticsBook struct {
+	Title    string
+	URL      string
+	Chapters []AnalyticsCount
+}
+
+// AnalyticsDay counts the downloads of a day
+type AnalyticsDay struct {
+	Date      string
+	Downloads int
+}
+
+// AnalyticsData is shown on the analytics dashboard
+type AnalyticsData struct {
+	Since     time.Time
+	Events    int
+	Posts     []AnalyticsCount
+	Chapters  []AnalyticsCount
+	Books     []AnalyticsBook
+	Downloads []AnalyticsCount
+	Days      []AnalyticsDay
+	MaxDay    int // most downloads on one of the days
+}
+
+// analyticsDays is how many days of downloads the dashboard charts
+const analyticsDays = 30
+
+// analyticsTop is how many posts and chapters the dashboard lists
+const analyticsTop = 20
+
+// summarizeAnalytics totals events for the dashboard. Visitors are counted
+// once per day, since their hash changes daily.
+func summarizeAnalytics(events []AnalyticsEvent, posts []Post, books []Book, now time.Time) AnalyticsData {
+	data := AnalyticsData{Events: len(events)}
+	if len(events) > 0 {
+		data.Since = events[0].Time
+	}
+
+	type tally struct {
+		views    int
+		visitors map[string]bool
+	}
+	count := func(m map[string]*tally, key, visitor string) {
+		t, ok := m[key]
+		if !ok {
+			t = &tally{visitors: map[string]bool{}}
+			m[key] = t
+		}
+		t.views++
+		t.visitors[visitor] = true
+	}
+
+	postViews := map[string]*tally{}
+	chapterViews := map[string]*tally{}
+	downloads := map[string]*tally{}
+	perDay := map[string]int{}
+	for _, event := range events {
+		switch {
+		case event.Kind == eventView && event.Post != "":
+			count(postViews, event.Post, event.Visitor)
+		case event.Kind == eventView && event.Chapter != "":
+			count(chapterViews, event.Book+"/"+event.Chapter, event.Visitor)
+		case event.Kind == eventDownload:
+			count(downloads, event.Book, event.Visitor)
+			perDay[event.Time.UTC().Format(time.DateOnly)]++
+		}
+	}
+
+	rows := func(m map[string]*tally, label func(key string) (string, string)) []AnalyticsCount {
+		var out []AnalyticsCount
+		for key, t := range m {
+			title, url := label(key)
+			out = append(out, AnalyticsCount{Label: title, URL: url, Views: t.views, Visitors: len(t.visitors)})
+		}
+		sort.Slice(out, func(i, j int) bool {
+			if out[i].Views != out[j].Views {
+				return out[i].Views > out[j].Views
+			}
+			return out[i].Label < out[j].Label
+		})
+		return out
+	}
+
+	postTitles := map[string]*Post{}
+	for i := range posts {
+		postTitles[posts[i].Slug] = &posts[i]
+	}
+	bookTitles := map[string]*Book{}
+	for i := range books {
+		bookTitles[books[i].Slug] = &books[i]
+	}
+
+	data.Posts = rows(postViews, func(slug string) (string, string) {
+		if post, ok := postTitles[slug]; ok {
+			return post.Metadata.Title, post.Path()
+		}
+		return slug, ""
+	})
+	data.Chapters = rows(chapterViews, func(key string) (string, string) {
+		bookSlug, chapterSlug, _ := strings.Cut(key, "/")
+		if book, ok := bookTitles[bookSlug]; ok {
+			for _, ch := range book.Chapters {
+				if ch.Slug == chapterSlug {
+					return ch.Title + " (" + book.Metadata.Title + ")", book.Path() + "/" + ch.Slug
+				}
+			}
+		}
+		return key, ""
+	})
+	data.Downloads = rows(downloads, func(slug string) (string, string) {
+		if book, ok := bookTitles[slug]; ok {
+			return book.Metadata.Title, book.Path() + "/"
+		}
+		return slug, ""
+	})
+	if len(data.Posts) > analyticsTop {
+		data.Posts = data.Posts[:analyticsTop]
+	}
+	if len(data.Chapters) > analyticsTop {
+		data.Chapters = data.Chapters[:analyticsTop]
+	}
+
+	// Drop-off follows each book's reading order
+	for _, book := range books {
+		b := AnalyticsBook{Title: book.Metadata.Title, URL: book.Path() + "/"}
+		first := 0
+		for _, ch := range book.Chapters {
+			row := AnalyticsCount{Label: ch.Title, URL: book.Path() + "/" + ch.Slug}
+			if t, ok := chapterViews[book.Slug+"/"+ch.Slug]; ok {
+				row.Views, row.Visitors = t.views, len(t.visitors)
+			}
+			if first == 0 {
+				first = row.Visitors
+			}
+			if first > 0 {
+				row.Percent = row.Visitors * 100 / first
+			}
+			b.Chapters = append(b.Chapters, row)
+		}
+		if first > 0 {
+			data.Books = append(data.Books, b)
+		}
+	}
+
+	for i := analyticsDays - 1; i >= 0; i-- {
+		day := now.UTC().AddDate(0, 0, -i).Format(time.DateOnly)
+		data.Days = append(data.Days, AnalyticsDay{Date: day, Downloads: perDay[day]})
+		data.MaxDay = max(data.MaxDay, perDay[day])
+	}
+
+	return data
+}
+
+// checkToken reports whether a request carries a token, as a bearer token
+// or as the password of basic authentication
+func checkToken(r *http.Request, token string) bool {
+	given := ""
+	if auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		given = auth
+	} else if _, password, ok := r.BasicAuth(); ok {
+		given = password
+	}
+	return given != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCheckToken(t *testing.T) {
+	tests := []struct {
+		name          string
+		authorization string
+		user          string
+		password      string
+		token         string
+		want          bool
+	}{
+		{name: "bearer", authorization: "Bearer secret", token: "secret", want: true},
+		{name: "wrong bearer", authorization: "Bearer nope", token: "secret", want: false},
+		{name: "bearer prefix of token", authorization: "Bearer sec", token: "secret", want: false},
+		{name: "empty bearer", authorization: "Bearer ", token: "secret", want: false},
+		{name: "lowercase scheme", authorization: "bearer secret", token: "secret", want: false},
+		{name: "basic", user: "me", password: "secret", token: "secret", want: true},
+		{name: "basic without user", password: "secret", token: "secret", want: true},
+		{name: "wrong basic", user: "me", password: "nope", token: "secret", want: false},
+		{name: "token as basic user", user: "secret", token: "secret", want: false},
+		{name: "none", token: "secret", want: false},
+		{name: "none with empty token", token: "", want: false},
+		{name: "other scheme", authorization: "Token secret", token: "secret", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/analytics", nil)
+			if tt.user != "" || tt.password != "" {
+				r.SetBasicAuth(tt.user, tt.password)
+			}
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if got := checkToken(r, tt.token); got != tt.want {
+				t.Errorf("checkToken = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
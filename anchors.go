@@ -0,0 +1,356 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// anchorWords is how many leading words of a paragraph its id is derived
+	// from, so edits further into the paragraph keep the id stable
+	anchorWords = 8
+
+	// maxAliasDistance is the largest simhash distance, out of 64 bits, at
+	// which a vanished id is considered to be the same passage as a current one
+	maxAliasDistance = 12
+)
+
+// ChapterAnchors represents the known paragraph ids of a chapter in
+// anchors.yaml
+type ChapterAnchors struct {
+	Fingerprints map[string]string `yaml:"fingerprints"`
+	Aliases      map[string]string `yaml:"aliases,omitempty"`
+}
+
+// AnchorManifest represents the anchors.yaml structure
+type AnchorManifest struct {
+	Chapters map[string]*ChapterAnchors `yaml:"chapters"`
+}
+
+// paragraphAnchor represents an id assigned to a paragraph or blockquote
+type paragraphAnchor struct {
+	ID          string
+	Fingerprint uint64
+}
+
+// loadAnchorManifest reads a book's anchors.yaml. A book without one
+// returns an empty manifest.
+func loadAnchorManifest(bookSlug string) (*AnchorManifest, error) {
+	manifest := &AnchorManifest{Chapters: map[string]*ChapterAnchors{}}
+
+	data, err := os.ReadFile(filepath.Join("books", bookSlug, "anchors.yaml"))
+	if errors.Is(err, os.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Chapters == nil {
+		manifest.Chapters = map[string]*ChapterAnchors{}
+	}
+	return manifest, nil
+}
+
+// anchorWordList returns the lowercased words of text, ignoring punctuation
+func anchorWordList(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// anchorID derives an id from the leading words of a passage
+func anchorID(prefix string, words []string) string {
+	if len(words) > anchorWords {
+		words = words[:anchorWords]
+	}
+	sum := sha1.Sum([]byte(strings.Join(words, " ")))
+	return prefix + hex.EncodeToString(sum[:4])
+}
+
+// simhash fingerprints a passage so that similar passages differ in few bits
+func simhash(words []string) uint64 {
+	var weights [64]int
+	for _, word := range words {
+		h := fnv.New64a()
+		h.Write([]byte(word))
+		sum := h.Sum64()
+		for i := 0; i < 64; i++ {
+			if sum&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	var fingerprint uint64
+	for i, w := range weights {
+		if w > 0 {
+			fingerprint |= 1 << i
+		}
+	}
+	return fingerprint
+}
+
+// anchoredBlock represents a paragraph or blockquote outside the footnotes
+type anchoredBlock struct {
+	Name     string
+	Text     strings.Builder
+	HasBlock bool
+}
+
+// collectAnchoredBlocks finds the paragraphs and blockquotes of a chapter,
+// keyed by the token index of their opening tag
+func collectAnchoredBlocks(tokens []htmlToken) map[int]*anchoredBlock {
+	blocks := map[int]*anchoredBlock{}
+	var open []int
+	asideDepth := 0
+
+	for i, tok := range tokens {
+		switch {
+		case tok.Kind == startTagToken && tok.Name == "aside":
+			asideDepth++
+		case tok.Kind == endTagToken && tok.Name == "aside" && asideDepth > 0:
+			asideDepth--
+		case tok.Kind == startTagToken && (tok.Name == "p" || tok.Name == "blockquote") && asideDepth == 0:
+			for _, j := range open {
+				if tok.Name == "p" {
+					blocks[j].HasBlock = true
+				}
+			}
+			blocks[i] = &anchoredBlock{Name: tok.Name}
+			open = append(open, i)
+		case tok.Kind == endTagToken && (tok.Name == "p" || tok.Name == "blockquote"):
+			// Close the innermost open element of the same name
+			for k := len(open) - 1; k >= 0; k-- {
+				if blocks[open[k]].Name == tok.Name {
+					open = append(open[:k], open[k+1:]...)
+					break
+				}
+			}
+		case tok.Kind == textToken:
+			for _, j := range open {
+				blocks[j].Text.WriteString(tok.Text())
+				blocks[j].Text.WriteString(" ")
+			}
+		}
+	}
+	return blocks
+}
+
+// chapterParagraphAnchors returns the ids a chapter's passages would be
+// given, in document order
+func chapterParagraphAnchors(content string) []paragraphAnchor {
+	tokens := tokenizeHTML(content)
+	ids := assignAnchorIDs(tokens, collectAnchoredBlocks(tokens))
+
+	var anchors []paragraphAnchor
+	for i := range tokens {
+		if a, ok := ids[i]; ok {
+			anchors = append(anchors, a)
+		}
+	}
+	return anchors
+}
+
+// assignAnchorIDs gives every collected block a unique id, keeping ids that
+// are already present in the markup
+func assignAnchorIDs(tokens []htmlToken, blocks map[int]*anchoredBlock) map[int]paragraphAnchor {
+	var order []int
+	for i := range blocks {
+		order = append(order, i)
+	}
+	sort.Ints(order)
+
+	ids := map[int]paragraphAnchor{}
+	seen := map[string]int{}
+	for _, i := range order {
+		words := anchorWordList(blocks[i].Text.String())
+		if len(words) == 0 {
+			continue
+		}
+
+		id := tokens[i].Attrs["id"]
+		if id == "" {
+			prefix := "p-"
+			if blocks[i].Name == "blockquote" {
+				prefix = "q-"
+			}
+			id = anchorID(prefix, words)
+			seen[id]++
+			if seen[id] > 1 {
+				id += "-" + strconv.Itoa(seen[id])
+			}
+		}
+		ids[i] = paragraphAnchor{ID: id, Fingerprint: simhash(words)}
+	}
+	return ids
+}
+
+// addParagraphAnchors gives every paragraph and blockquote of a chapter a
+// stable id with a hover permalink, and adds hidden anchors for any old ids
+// that the book's anchors.yaml aliases to them
+func addParagraphAnchors(content template.HTML, anchors *ChapterAnchors) template.HTML {
+	tokens := tokenizeHTML(string(content))
+	blocks := collectAnchoredBlocks(tokens)
+	ids := assignAnchorIDs(tokens, blocks)
+
+	aliases := map[string][]string{}
+	if anchors != nil {
+		for oldID, newID := range anchors.Aliases {
+			aliases[newID] = append(aliases[newID], oldID)
+		}
+		for _, old := range aliases {
+			sort.Strings(old)
+		}
+	}
+
+	// Permalinks go just before the closing tag of their element
+	var open []int
+	var out strings.Builder
+	for i, tok := range tokens {
+		if a, ok := ids[i]; ok {
+			raw := tok.Raw
+			if tok.Attrs["id"] == "" {
+				n := 1 + len(tok.Name)
+				raw = raw[:n] + ` id="` + a.ID + `"` + raw[n:]
+			}
+			out.WriteString(raw)
+			for _, oldID := range aliases[a.ID] {
+				out.WriteString(`<span id="` + oldID + `" class="anchor-alias"></span>`)
+			}
+			open = append(open, i)
+			continue
+		}
+
+		if tok.Kind == endTagToken && (tok.Name == "p" || tok.Name == "blockquote") {
+			for k := len(open) - 1; k >= 0; k-- {
+				j := open[k]
+				if tokens[j].Name != tok.Name {
+					continue
+				}
+				if !blocks[j].HasBlock {
+					out.WriteString(fmt.Sprintf(`<a href="#%s" class="permalink" aria-label="Link to this passage">&para;</a>`, ids[j].ID))
+				}
+				open = append(open[:k], open[k+1:]...)
+				break
+			}
+		}
+		out.WriteString(tok.Raw)
+	}
+
+	return template.HTML(out.String())
+}
+
+// chapterAnchors returns the anchors.yaml entry for a chapter, if any
+func (b *Book) chapterAnchors(chapterSlug string) *ChapterAnchors {
+	if b.Anchors == nil {
+		return nil
+	}
+	return b.Anchors.Chapters[chapterSlug]
+}
+
+// updateAnchors makes a build record the current paragraph ids in each
+// book's anchors.yaml. It is set by the -update-anchors flag; otherwise a
+// build only reads the content.
+var updateAnchors bool
+
+// updateAnchorManifest records the current paragraph ids of every chapter
+// in the book's anchors.yaml. Ids that have disappeared since the last run
+// are aliased to the most similar current passage so old links keep working.
+func updateAnchorManifest(book *Book) (*AnchorManifest, error) {
+	manifest, err := loadAnchorManifest(book.Slug)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, chapterInfo := range book.Chapters {
+		content, err := readChapter(book, chapterInfo.Slug)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := updateChapterAnchors(manifest.Chapters[chapterInfo.Slug], chapterParagraphAnchors(content))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", chapterInfo.Slug, err)
+		}
+		manifest.Chapters[chapterInfo.Slug] = updated
+	}
+
+	data, err := yaml.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	header := "# Paragraph ids and their fingerprints, recorded by build -update-anchors.\n" +
+		"# Aliases keep links to edited passages working; don't edit by hand.\n"
+	if err := os.WriteFile(filepath.Join("books", book.Slug, "anchors.yaml"), append([]byte(header), data...), 0644); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// updateChapterAnchors returns the anchors.yaml entry of a chapter whose
+// passages now have the current ids, given its previous entry, if any
+func updateChapterAnchors(previous *ChapterAnchors, current []paragraphAnchor) (*ChapterAnchors, error) {
+	if previous == nil {
+		previous = &ChapterAnchors{}
+	}
+	updated := &ChapterAnchors{
+		Fingerprints: map[string]string{},
+		Aliases:      map[string]string{},
+	}
+	for _, a := range current {
+		updated.Fingerprints[a.ID] = strconv.FormatUint(a.Fingerprint, 16)
+	}
+	for oldID, newID := range previous.Aliases {
+		// An id that is back in use no longer needs an alias
+		if _, ok := updated.Fingerprints[oldID]; !ok {
+			updated.Aliases[oldID] = newID
+		}
+	}
+
+	for oldID, value := range previous.Fingerprints {
+		if _, ok := updated.Fingerprints[oldID]; ok {
+			continue
+		}
+		fingerprint, err := strconv.ParseUint(value, 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad fingerprint for %s: %v", oldID, err)
+		}
+
+		best, bestDistance := "", maxAliasDistance+1
+		for _, a := range current {
+			if d := bits.OnesCount64(a.Fingerprint ^ fingerprint); d < bestDistance {
+				best, bestDistance = a.ID, d
+			}
+		}
+		if best == "" {
+			continue
+		}
+
+		// Older aliases that pointed at the vanished id follow it
+		updated.Aliases[oldID] = best
+		for aliasID, target := range updated.Aliases {
+			if target == oldID {
+				updated.Aliases[aliasID] = best
+			}
+		}
+	}
+	return updated, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"html/template"
+	"strings"
+	"testing"
+)
+
+// anchorIDs returns the ids a chapter's passages are given, in order
+func anchorIDs(content string) []string {
+	var ids []string
+	for _, a := range chapterParagraphAnchors(content) {
+		ids = append(ids, a.ID)
+	}
+	return ids
+}
+
+func TestParagraphAnchorsAreStable(t *testing.T) {
+	original := `<p>The regiment marched out of Agra at dawn and reached the river by noon, where the boats were waiting.</p>`
+	tests := []struct {
+		name   string
+		edited string
+	}{
+		{"unchanged", original},
+		{"edit past the leading words", `<p>The regiment marched out of Agra at dawn and reached the ford by evening, where no boats were waiting.</p>`},
+		{"punctuation", `<p>The regiment marched out of Agra—at dawn—and reached the river by noon; the boats were waiting.</p>`},
+		{"case", `<p>THE Regiment marched out of Agra at dawn and reached the river by noon, where the boats were waiting.</p>`},
+		{"markup", `<p>The <em>regiment</em> marched out of Agra at dawn and reached the river by noon, where the boats were waiting.</p>`},
+		{"line breaks", "<p>The regiment marched out of Agra\n    at dawn and reached the river by noon, where the boats were waiting.</p>"},
+	}
+	want := anchorIDs(original)
+	if len(want) != 1 || !strings.HasPrefix(want[0], "p-") {
+		t.Fatalf("ids of original = %v, want one p- id", want)
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := anchorIDs(tt.edited); len(got) != 1 || got[0] != want[0] {
+				t.Errorf("ids = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestParagraphAnchorsOfRepeatedPassages(t *testing.T) {
+	content := `<p>Again and again.</p><blockquote>Again and again.</blockquote><p>Again and again.</p><p id="kept">Again and again.</p>`
+	got := anchorIDs(content)
+	first := anchorID("p-", anchorWordList("Again and again."))
+	want := []string{first, anchorID("q-", anchorWordList("Again and again.")), first + "-2", "kept"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("ids = %v, want %v", got, want)
+	}
+}
+
+func TestChangedParagraphsResolveThroughAliases(t *testing.T) {
+	before := `<p>Sita Ram was born in the village of Tilowee in Oudh, in the year 1797.</p>` +
+		`<p>His uncle was a Jemadar in the service of the Company.</p>`
+	// The first paragraph is reworded at its start, so its id changes
+	after := `<p>Sita Ram was born in the small village of Tilowee in Oudh, in the year 1797.</p>` +
+		`<p>His uncle was a Jemadar in the service of the Company.</p>`
+
+	first, err := updateChapterAnchors(nil, chapterParagraphAnchors(before))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Aliases) != 0 {
+		t.Errorf("aliases of a new chapter = %v, want none", first.Aliases)
+	}
+
+	oldIDs, newIDs := anchorIDs(before), anchorIDs(after)
+	if oldIDs[0] == newIDs[0] {
+		t.Fatalf("edit kept id %s; the test needs it to change", oldIDs[0])
+	}
+	second, err := updateChapterAnchors(first, chapterParagraphAnchors(after))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := second.Aliases[oldIDs[0]]; got != newIDs[0] {
+		t.Errorf("alias of %s = %q, want %q", oldIDs[0], got, newIDs[0])
+	}
+	if _, ok := second.Aliases[oldIDs[1]]; ok {
+		t.Errorf("unchanged paragraph %s was aliased", oldIDs[1])
+	}
+
+	// Links to the old id still land on the paragraph
+	page := string(addParagraphAnchors(template.HTML(after), second))
+	if !strings.Contains(page, `<span id="`+oldIDs[0]+`" class="anchor-alias"></span>`) {
+		t.Errorf("page has no anchor for old id %s:\n%s", oldIDs[0], page)
+	}
+
+	// A further edit moves the alias along with the paragraph
+	again := `<p>Sita Ram was born in a small village called Tilowee in Oudh, in the year 1797.</p>` +
+		`<p>His uncle was a Jemadar in the service of the Company.</p>`
+	third, err := updateChapterAnchors(second, chapterParagraphAnchors(again))
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := anchorIDs(again)[0]
+	for _, id := range []string{oldIDs[0], newIDs[0]} {
+		if got := third.Aliases[id]; got != latest {
+			t.Errorf("alias of %s = %q, want %q", id, got, latest)
+		}
+	}
+}
+
+func TestUnrelatedParagraphsAreNotAliased(t *testing.T) {
+	before := `<p>The battle of Maharajpore was fought in December 1843 against the Gwalior army.</p>`
+	after := `<p>Rain fell for three weeks and the baggage camels sickened on the road to Ferozepore.</p>`
+	first, _ := updateChapterAnchors(nil, chapterParagraphAnchors(before))
+	second, err := updateChapterAnchors(first, chapterParagraphAnchors(after))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second.Aliases) != 0 {
+		t.Errorf("aliases = %v, want none", second.Aliases)
+	}
+}
+
+func TestUpdateChapterAnchorsBadFingerprint(t *testing.T) {
+	previous := &ChapterAnchors{Fingerprints: map[string]string{"p-gone": "not hex"}}
+	if _, err := updateChapterAnchors(previous, nil); err == nil {
+		t.Error("bad fingerprint gave no error")
+	}
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+const (
+	// apiPrefix is the path of the current version of the JSON API. Every
+	// endpoint is also served with a .json suffix, which is the form the
+	// static build writes and the form links in responses use.
+	apiPrefix = "/api/" + apiVersion
+
+	// apiVersion is the current version of the JSON API
+	apiVersion = "v1"
+
+	// apiSchemaFile is the JSON Schema documenting the API's responses
+	apiSchemaFile = "api/v1.schema.json"
+)
+
+// APIPostSummary represents a post in the posts list
+type APIPostSummary struct {
+	Slug        string    `json:"slug"`
+	Title       string    `json:"title"`
+	Date        time.Time `json:"date"`
+	Description string    `json:"description,omitempty"`
+	Excerpt     string    `json:"excerpt"`
+	Tags        []string  `json:"tags"`
+	Language    string    `json:"language"`
+	URL         string    `json:"url"`
+	API         string    `json:"api"`
+}
+
+// APIPost represents a single post with its content
+type APIPost struct {
+	APIPostSummary
+	Translations []APITranslation `json:"translations"`
+	HTML         string           `json:"html"`
+	Text         string           `json:"text"`
+}
+
+// APITranslation represents a version of a post or book in another language
+type APITranslation struct {
+	Language string `json:"language"`
+	Title    string `json:"title"`
+	URL      string `json:"url"`
+}
+
+// APIBookSummary represents a book in the books list
+type APIBookSummary struct {
+	Slug        string   `json:"slug"`
+	Title       string   `json:"title"`
+	Subtitle    string   `json:"subtitle,omitempty"`
+	Author      string   `json:"author,omitempty"`
+	Translator  string   `json:"translator,omitempty"`
+	Editor      string   `json:"editor,omitempty"`
+	Illustrator string   `json:"illustrator,omitempty"`
+	Year        int      `json:"year,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Language    string   `json:"language"`
+	Subjects    []string `json:"subjects"`
+	Cover       string   `json:"cover"`
+	URL         string   `json:"url"`
+	API         string   `json:"api"`
+}
+
+// APIBook represents a single book with its table of contents
+type APIBook struct {
+	APIBookSummary
+	Publisher    string              `json:"publisher,omitempty"`
+	Edition      string              `json:"edition,omitempty"`
+	ISBN         string              `json:"isbn,omitempty"`
+	Copyright    string              `json:"copyright,omitempty"`
+	License      string              `json:"license,omitempty"`
+	Translations []APITranslation    `json:"translations"`
+	Downloads    map[string]string   `json:"downloads"`
+	Chapters     []APIChapterSummary `json:"chapters"`
+}
+
+// APIChapterSummary represents a chapter in a book's reading order
+type APIChapterSummary struct {
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+	Type  string `json:"type,omitempty"`
+	Pages string `json:"pages,omitempty"`
+	URL   string `json:"url"`
+	API   string `json:"api"`
+}
+
+// APIChapter represents a single chapter with its content
+type APIChapter struct {
+	APIChapterSummary
+	Book     APIBookRef         `json:"book"`
+	Previous *APIChapterSummary `json:"previous"`
+	Next     *APIChapterSummary `json:"next"`
+	HTML     string             `json:"html"`
+	Text     string             `json:"text"`
+}
+
+// APIBookRef identifies the book a chapter belongs to
+type APIBookRef struct {
+	Slug  string `json:"slug"`
+	Title string `json:"title"`
+	API   string `json:"api"`
+}
+
+func apiPostPath(slug string) string {
+	return apiPrefix + "/posts/" + slug + ".json"
+}
+
+func apiBookPath(slug string) string {
+	return apiPrefix + "/books/" + slug + ".json"
+}
+
+func apiChapterPath(bookSlug, chapterSlug string) string {
+	return apiPrefix + "/books/" + bookSlug + "/chapters/" + chapterSlug + ".json"
+}
+
+// apiText renders markup as plain text with a blank line between blocks
+// and footnotes gathered at the end, as in the plain text edition
+func apiText(content template.HTML) string {
+	blocks, notes := flattenChapter(string(content), false, func(n int) string { return fmt.Sprintf("[%d]", n) })
+
+	var parts []string
+	for _, block := range blocks {
+		switch {
+		case block.Kind == "rule":
+			parts = append(parts, "* * *")
+		case block.Text != "":
+			parts = append(parts, block.Text)
+		}
+	}
+	for i, note := range notes {
+		parts = append(parts, fmt.Sprintf("[%d] %s", i+1, note.Text))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+func apiTranslations(translations []Translation) []APITranslation {
+	out := []APITranslation{}
+	for _, t := range translations {
+		out = append(out, APITranslation{Language: t.Language, Title: t.Title, URL: absoluteURL(t.Path)})
+	}
+	return out
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func apiPostSummary(post *Post) APIPostSummary {
+	return APIPostSummary{
+		Slug:        post.Slug,
+		Title:       post.Metadata.Title,
+		Date:        post.Metadata.Date,
+		Description: post.Metadata.Description,
+		Excerpt:     post.Excerpt,
+		Tags:        nonNil(post.Metadata.Tags),
+		Language:    post.Lang(),
+		URL:         absoluteURL(post.Path()),
+		API:         absoluteURL(apiPostPath(post.Slug)),
+	}
+}
+
+// apiPostList builds the response of /api/v1/posts
+func apiPostList(posts []Post) map[string]interface{} {
+	list := []APIPostSummary{}
+	for i := range posts {
+		list = append(list, apiPostSummary(&posts[i]))
+	}
+	return map[string]interface{}{"version": apiVersion, "posts": list}
+}
+
+// apiPost builds the response of /api/v1/posts/{slug}
+func apiPost(post *Post) APIPost {
+	return APIPost{
+		APIPostSummary: apiPostSummary(post),
+		Translations:   apiTranslations(post.Translations),
+		HTML:           string(post.Content),
+		Text:           apiText(post.Content),
+	}
+}
+
+func apiBookSummary(book *Book) APIBookSummary {
+	m := book.Metadata
+	return APIBookSummary{
+		Slug:        book.Slug,
+		Title:       m.Title,
+		Subtitle:    m.Subtitle,
+		Author:      m.Author,
+		Translator:  m.Translator,
+		Editor:      m.Editor,
+		Illustrator: m.Illustrator,
+		Year:        m.Year,
+		Description: m.Description,
+		Language:    book.Lang(),
+		Subjects:    nonNil(m.Subjects),
+		Cover:       absoluteURL(book.CoverURL()),
+		URL:         absoluteURL(book.Path() + "/"),
+		API:         absoluteURL(apiBookPath(book.Slug)),
+	}
+}
+
+func apiChapterSummary(book *Book, ch ChapterInfo) APIChapterSummary {
+	return APIChapterSummary{
+		Slug:  ch.Slug,
+		Title: ch.Title,
+		Type:  ch.Type,
+		Pages: ch.Pages,
+		URL:   absoluteURL(book.Path() + "/" + ch.Slug),
+		API:   absoluteURL(apiChapterPath(book.Slug, ch.Slug)),
+	}
+}
+
+// apiBookList builds the response of /api/v1/books
+func apiBookList(books []Book) map[string]interface{} {
+	list := []APIBookSummary{}
+	for i := range books {
+		list = append(list, apiBookSummary(&books[i]))
+	}
+	return map[string]interface{}{"version": apiVersion, "books": list}
+}
+
+// apiBook builds the response of /api/v1/books/{slug}
+func apiBook(book *Book) APIBook {
+	m := book.Metadata
+	out := APIBook{
+		APIBookSummary: apiBookSummary(book),
+		Publisher:      m.Publisher,
+		Edition:        m.Edition,
+		ISBN:           m.ISBN(),
+		Copyright:      m.Copyright,
+		License:        m.License,
+		Translations:   apiTranslations(book.Translations),
+		Downloads:      map[string]string{},
+		Chapters:       []APIChapterSummary{},
+	}
+	if m.EpubFile != "" {
+		out.Downloads["epub"] = absoluteURL(book.Path() + "/" + m.EpubFile)
+	}
+	out.Downloads["text"] = absoluteURL(book.Path() + "/" + book.TextFile())
+	out.Downloads["markdown"] = absoluteURL(book.Path() + "/" + book.MarkdownFile())
+	out.Downloads["html_zip"] = absoluteURL(book.Path() + "/" + book.HTMLZipFile())
+
+	for _, ch := range book.Chapters {
+		out.Chapters = append(out.Chapters, apiChapterSummary(book, ch))
+	}
+	return out
+}
+
+// apiChapter builds the response of /api/v1/books/{slug}/chapters/{chapter}
+func apiChapter(book *Book, chapterSlug string) (*APIChapter, error) {
+	chapter, err := loadChapter(book, chapterSlug)
+	if err != nil {
+		return nil, err
+	}
+	content := linkGlossaryTerms(book, chapter.Content)
+
+	out := &APIChapter{
+		Book: APIBookRef{
+			Slug:  book.Slug,
+			Title: book.Metadata.Title,
+			API:   absoluteURL(apiBookPath(book.Slug)),
+		},
+		HTML: string(content),
+		Text: apiText(content),
+	}
+	for i, ch := range book.Chapters {
+		if ch.Slug != chapterSlug {
+			continue
+		}
+		out.APIChapterSummary = apiChapterSummary(book, ch)
+		if i > 0 {
+			prev := apiChapterSummary(book, book.Chapters[i-1])
+			out.Previous = &prev
+		}
+		if i < len(book.Chapters)-1 {
+			next := apiChapterSummary(book, book.Chapters[i+1])
+			out.Next = &next
+		}
+	}
+	return out, nil
+}
+
+// encodeAPI encodes an API response as indented JSON
+func encodeAPI(v interface{}) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// apiETag returns a strong ETag for an encoded API response
+func apiETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeJSONConditional(t *testing.T) {
+	data, err := encodeAPI(map[string]string{"title": "Sepoy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := apiETag(data)
+	if other, _ := encodeAPI(map[string]string{"title": "Subedar"}); apiETag(other) == etag {
+		t.Fatal("different responses have the same ETag")
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		wantStatus  int
+	}{
+		{"unconditional", "", http.StatusOK},
+		{"matching", etag, http.StatusNotModified},
+		{"weak match", "W/" + etag, http.StatusNotModified},
+		{"one of several", `"stale", ` + etag, http.StatusNotModified},
+		{"any", "*", http.StatusNotModified},
+		{"stale", `"stale"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", apiBookPath("b"), nil)
+			if tt.ifNoneMatch != "" {
+				r.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+			w := httptest.NewRecorder()
+			serveJSON(w, r, data)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("ETag = %s, want %s", got, etag)
+			}
+			if got := w.Header().Get("Cache-Control"); got != "no-cache" {
+				t.Errorf("Cache-Control = %q, want no-cache", got)
+			}
+			wantBody := string(data)
+			if tt.wantStatus == http.StatusNotModified {
+				wantBody = ""
+			}
+			if w.Body.String() != wantBody {
+				t.Errorf("body = %q, want %q", w.Body.String(), wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"html/template"
+	"regexp"
+)
+
+// BookAllData represents data for rendering every chapter of a book on one page
+type BookAllData struct {
+	BookSlug  string
+	BookTitle string
+	TOC       []TOCEntry
+	Chapters  []ChapterData
+}
+
+var (
+	idAttrPattern       = regexp.MustCompile(`(\sid=")([^"]+)"`)
+	fragmentHrefPattern = regexp.MustCompile(`(\shref="#)([^"]+)"`)
+)
+
+// loadAllChapters loads every chapter of a book in chapters.yaml order,
+// namespacing element ids so footnotes from different chapters don't collide
+func loadAllChapters(book *Book) (*BookAllData, error) {
+	all := &BookAllData{
+		BookSlug:  book.Slug,
+		BookTitle: book.Metadata.Title,
+		TOC: tocEntries(book.Contents, func(slug string) string {
+			return "#" + slug
+		}),
+	}
+
+	for _, chapterInfo := range book.Chapters {
+		chapter, err := loadChapter(book, chapterInfo.Slug)
+		if err != nil {
+			return nil, err
+		}
+
+		chapter.Content = linkGlossaryTerms(book, chapter.Content)
+		chapter.Content = template.HTML(namespaceIDs(string(chapter.Content), chapterInfo.Slug))
+		all.Chapters = append(all.Chapters, *chapter)
+	}
+
+	return all, nil
+}
+
+// namespaceIDs prefixes every id attribute and in-page fragment link with
+// prefix and a dot. Chapter slugs can't contain dots, so ids namespaced by
+// different chapters never clash.
+func namespaceIDs(content, prefix string) string {
+	content = idAttrPattern.ReplaceAllString(content, `${1}`+prefix+`.${2}"`)
+	content = fragmentHrefPattern.ReplaceAllString(content, `${1}`+prefix+`.${2}"`)
+	return content
+}
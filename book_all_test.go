@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestNamespaceIDs(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		prefix  string
+		want    string
+	}{
+		{"no ids", `<p>Text</p>`, "one", `<p>Text</p>`},
+		{"id", `<p id="p-1">Text</p>`, "one", `<p id="one.p-1">Text</p>`},
+		{
+			"fragment link",
+			`<sup id="fnref:1"><a href="#fn:1">1</a></sup>`,
+			"one",
+			`<sup id="one.fnref:1"><a href="#one.fn:1">1</a></sup>`,
+		},
+		{"link to another page", `<a href="/book/b/two#p-1">x</a>`, "one", `<a href="/book/b/two#p-1">x</a>`},
+		{"hyphenated slug", `<p id="c">Text</p>`, "a-b", `<p id="a-b.c">Text</p>`},
+		{"hyphenated id", `<p id="b-c">Text</p>`, "a", `<p id="a.b-c">Text</p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := namespaceIDs(tt.content, tt.prefix); got != tt.want {
+				t.Errorf("namespaceIDs(%q, %q) = %q, want %q", tt.content, tt.prefix, got, tt.want)
+			}
+		})
+	}
+
+	// Chapter a-b's id c and chapter a's id b-c mustn't become the same id
+	if namespaceIDs(`<p id="c">`, "a-b") == namespaceIDs(`<p id="b-c">`, "a") {
+		t.Error("ids of different chapters clash")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// BookIdentifier represents an identifier such as an ISBN or OCLC number
+type BookIdentifier struct {
+	Scheme string `yaml:"scheme"`
+	Value  string `yaml:"value"`
+}
+
+// copyrightStatuses are the accepted values of BookMetadata.Copyright
+var copyrightStatuses = map[string]string{
+	"public-domain": "Public domain",
+	"in-copyright":  "In copyright",
+	"unknown":       "Copyright status unknown",
+}
+
+// languageNames are display names for the language codes used on the site
+var languageNames = map[string]string{
+	"en": "English",
+	"hi": "Hindi",
+	"mr": "Marathi",
+	"sa": "Sanskrit",
+	"ur": "Urdu",
+}
+
+var languagePattern = regexp.MustCompile(`^[a-zA-Z]{2,3}(-[a-zA-Z0-9]{2,8})*$`)
+
+// validateBookMetadata checks the fields of a book's metadata.yaml
+func validateBookMetadata(slug string, m *BookMetadata) error {
+	bookDir := filepath.Join("books", slug)
+
+	if m.Title == "" {
+		return fmt.Errorf("metadata.yaml: title is required")
+	}
+	if m.Language != "" && !languagePattern.MatchString(m.Language) {
+		return fmt.Errorf("metadata.yaml: language %q is not a BCP 47 language tag", m.Language)
+	}
+	for _, id := range m.Identifiers {
+		if id.Scheme == "" || id.Value == "" {
+			return fmt.Errorf("metadata.yaml: identifiers need both a scheme and a value")
+		}
+		if strings.EqualFold(id.Scheme, "isbn") && !validISBN(id.Value) {
+			return fmt.Errorf("metadata.yaml: %q is not a valid ISBN", id.Value)
+		}
+	}
+	if m.Copyright != "" {
+		if _, ok := copyrightStatuses[m.Copyright]; !ok {
+			return fmt.Errorf("metadata.yaml: copyright must be one of public-domain, in-copyright or unknown, not %q", m.Copyright)
+		}
+	}
+	if m.Cover != "" {
+		if filepath.Base(m.Cover) != m.Cover || m.Cover == "." || m.Cover == ".." {
+			return fmt.Errorf("metadata.yaml: cover %q must be a file in the book directory", m.Cover)
+		}
+		info, err := os.Stat(filepath.Join(bookDir, m.Cover))
+		if err != nil {
+			return fmt.Errorf("metadata.yaml: cover: %w", err)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("metadata.yaml: cover %q must be a file in the book directory", m.Cover)
+		}
+	} else {
+		// A hand-made cover overrides the generated one even when it isn't named
+		m.Cover = findCoverFile(bookDir)
+	}
+
+	return nil
+}
+
+// validISBN reports whether s is an ISBN-10 or ISBN-13 with a correct
+// check digit, ignoring hyphens and spaces
+func validISBN(s string) bool {
+	isbn := strings.NewReplacer("-", "", " ", "").Replace(s)
+
+	switch len(isbn) {
+	case 10:
+		sum := 0
+		for i, r := range isbn {
+			var d int
+			switch {
+			case r >= '0' && r <= '9':
+				d = int(r - '0')
+			case (r == 'X' || r == 'x') && i == 9:
+				d = 10
+			default:
+				return false
+			}
+			sum += d * (10 - i)
+		}
+		return sum%11 == 0
+	case 13:
+		sum := 0
+		for i, r := range isbn {
+			if r < '0' || r > '9' {
+				return false
+			}
+			d := int(r - '0')
+			if i%2 == 1 {
+				d *= 3
+			}
+			sum += d
+		}
+		return sum%10 == 0
+	}
+	return false
+}
+
+// ISBN returns the book's first ISBN, if it has one
+func (m BookMetadata) ISBN() string {
+	for _, id := range m.Identifiers {
+		if strings.EqualFold(id.Scheme, "isbn") {
+			return id.Value
+		}
+	}
+	return ""
+}
+
+// LanguageName returns the display name of the book's language
+func (m BookMetadata) LanguageName() string {
+	return languageName(m.Language)
+}
+
+// CopyrightStatus returns the display text of the book's copyright status
+func (m BookMetadata) CopyrightStatus() string {
+	return copyrightStatuses[m.Copyright]
+}
+
+// identifierURN returns an identifier as a URN, as used by OPDS and EPUB
+func identifierURN(id BookIdentifier) string {
+	scheme := strings.ToLower(id.Scheme)
+	value := id.Value
+	if scheme == "isbn" {
+		value = strings.NewReplacer("-", "", " ", "").Replace(value)
+	}
+	return "urn:" + scheme + ":" + value
+}
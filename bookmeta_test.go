@@ -0,0 +1,80 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidISBN(t *testing.T) {
+	tests := []struct {
+		isbn string
+		want bool
+	}{
+		{"0-306-40615-2", true},
+		{"0306406152", true},
+		{"0-8044-2957-X", true},
+		{"080442957x", true},
+		{"978-0-306-40615-7", true},
+		{"978 0 14 303943 3", true},
+		{"9780143039433", true},
+
+		{"0-306-40615-3", false},     // wrong check digit
+		{"978-0-306-40615-8", false}, // wrong check digit
+		{"X-306-40615-2", false},     // X only as the ISBN-10 check digit
+		{"978-0-306-4061X-7", false},
+		{"978030640615X", false},
+		{"030640615", false}, // too short
+		{"97803064061577", false},
+		{"0-306-4O615-2", false}, // letter O
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := validISBN(tt.isbn); got != tt.want {
+			t.Errorf("validISBN(%q) = %v, want %v", tt.isbn, got, tt.want)
+		}
+	}
+}
+
+func TestBookCover(t *testing.T) {
+	inContentRoot(t, map[string]string{
+		"books/b/front.jpg":            "jpeg",
+		"books/b/art/inside.png":       "png",
+		"books/found/cover.png":        "png",
+		"books/dir/cover.svg/keep.txt": "not a cover",
+	})
+	if err := os.Mkdir(filepath.Join("books", "b", "folder.png"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		slug    string
+		cover   string
+		want    string
+		wantErr bool
+	}{
+		{"named", "b", "front.jpg", "front.jpg", false},
+		{"missing", "b", "back.jpg", "", true},
+		{"in a subdirectory", "b", "art/inside.png", "", true},
+		{"outside the book", "b", "../found/cover.png", "", true},
+		{"the book directory", "b", ".", "", true},
+		{"the books directory", "b", "..", "", true},
+		{"a directory", "b", "folder.png", "", true},
+		{"found by name", "found", "", "cover.png", false},
+		{"none found", "b", "", "", false},
+		{"directory named like a cover", "dir", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := BookMetadata{Title: "B", Cover: tt.cover}
+			err := validateBookMetadata(tt.slug, &m)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && m.Cover != tt.want {
+				t.Errorf("cover = %q, want %q", m.Cover, tt.want)
+			}
+		})
+	}
+}
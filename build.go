@@ -1,165 +1,157 @@
-//go:build build
-
 package main
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"html/template"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
-	"sort"
-	// "strings"
+	"strings"
 	"time"
-
-	"github.com/yuin/goldmark"
-	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/parser"
-	"github.com/yuin/goldmark/renderer/html"
-	"gopkg.in/yaml.v3"
 )
 
-// PostMetadata represents the metadata for a blog post
-type PostMetadata struct {
-	Title       string    `yaml:"title"`
-	Date        time.Time `yaml:"date"`
-	Description string    `yaml:"description"`
-	Tags        []string  `yaml:"tags"`
-}
-
-// BookMetadata represents the metadata for a book
-type BookMetadata struct {
-	Title       string `yaml:"title"`
-	Subtitle    string `yaml:"subtitle"`
-	Author      string `yaml:"author"`
-	Translator  string `yaml:"translator"`
-	Editor      string `yaml:"editor"`
-	Illustrator string `yaml:"illustrator"`
-	Year        int    `yaml:"year"`
-	Description string `yaml:"description"`
-	EpubFile    string `yaml:"epub_file"`
-}
-
-// ChapterInfo represents a chapter entry in chapters.yaml
-type ChapterInfo struct {
-	Slug  string `yaml:"slug"`
-	Title string `yaml:"title"`
-}
-
-// ChaptersConfig represents the chapters.yaml structure
-type ChaptersConfig struct {
-	Chapters []ChapterInfo `yaml:"chapters"`
-}
-
-// Book represents a complete book
-type Book struct {
-	Metadata BookMetadata
-	Slug     string
-	Chapters []ChapterInfo
-	Snippet  template.HTML
-	Intro    template.HTML
-}
-
-// ChapterData represents data for rendering a chapter
-type ChapterData struct {
-	Title       string
-	Content     template.HTML
-	BookSlug    string
-	BookTitle   string
-	ChapterSlug string
-	PrevChapter *ChapterInfo
-	NextChapter *ChapterInfo
-}
+// outputDir is the directory the site is built into. It is set by the
+// -out flag.
+var outputDir = "public"
 
-// Post represents a complete blog post
-type Post struct {
-	Metadata PostMetadata
-	Content  template.HTML
-	Slug     string
+// runBuild builds the static site into the output directory and reports
+// what it generated and skipped
+func runBuild(cfg *config, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	if err := buildSite(); err != nil {
+		return err
+	}
+	return finishReport(cfg)
 }
 
-// PageData represents data passed to templates
-type PageData struct {
-	Title   string
-	Content template.HTML
-	Posts   []Post
-	Post    *Post
-	Books   []Book
-	Book    *Book
-	Chapter *ChapterData
+// finishReport prints the report of the last build, writes it as JSON if
+// asked to, and fails a strict build that skipped content or had warnings
+func finishReport(cfg *config) error {
+	report.writeText(os.Stdout)
+	if cfg.ReportFile != "" {
+		if err := report.writeJSON(cfg.ReportFile); err != nil {
+			return fmt.Errorf("writing build report: %w", err)
+		}
+	}
+	if cfg.Strict && report.problems() > 0 {
+		return fmt.Errorf("%d skipped and %d warning(s) in strict mode", len(report.Skipped), len(report.Warnings))
+	}
+	return nil
 }
 
-var (
-	templates *template.Template
-	md        goldmark.Markdown
-)
+// buildSite renders every page, feed and download of the site into
+// outputDir, replacing whatever was there before. What it generated and
+// skipped is recorded in report.
+func buildSite() error {
+	// Refuse to clean a directory that holds the content
+	root, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if rel, err := filepath.Rel(outputDir, root); err == nil && !strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("output directory %s contains the content root", outputDir)
+	}
 
-func init() {
-	// Initialize goldmark with extensions
-	md = goldmark.New(
-		goldmark.WithExtensions(
-			extension.GFM,
-			extension.Table,
-			extension.Strikethrough,
-			extension.Linkify,
-			extension.TaskList,
-		),
-		goldmark.WithParserOptions(
-			parser.WithAutoHeadingID(),
-		),
-		goldmark.WithRendererOptions(
-			html.WithHardWraps(),
-			html.WithXHTML(),
-		),
-	)
-}
+	report = newBuildReport(outputDir)
+	start := time.Now()
 
-func main() {
 	// Clean output directory
-	os.RemoveAll("public")
-	os.MkdirAll("public", 0755)
-	os.MkdirAll("public/post", 0755)
-	os.MkdirAll("public/book", 0755)
-	os.MkdirAll("public/books", 0755)
+	os.RemoveAll(outputDir)
+	os.MkdirAll(outputDir, 0755)
+	os.MkdirAll(filepath.Join(outputDir, "post"), 0755)
+	os.MkdirAll(filepath.Join(outputDir, "book"), 0755)
+	os.MkdirAll(filepath.Join(outputDir, "books"), 0755)
 
 	// Copy static files
-	copyDir("static", "public/static")
+	if err := copyDir("static", filepath.Join(outputDir, "static")); err != nil {
+		report.warn("Error copying static files: %v", err)
+	}
+	report.step("static", start)
 
 	// Parse templates
-	var err error
+	start = time.Now()
 	templates, err = template.ParseGlob(filepath.Join("templates", "*.html"))
 	if err != nil {
-		log.Fatal("Error parsing templates:", err)
+		return fmt.Errorf("parsing templates: %w", err)
+	}
+	if err := loadShortcodeTemplates(); err != nil {
+		return fmt.Errorf("parsing shortcode templates: %w", err)
 	}
+	report.step("templates", start)
 
 	// Generate pages
-	generateHomePage()
+	start = time.Now()
 	posts := generatePostPages()
-	generatePostsListPage(posts)
-	books := generateBookPages()
-	generateBooksListPage(books)
+	report.step("posts", start)
+
+	start = time.Now()
+	books := generateBookPages(referencedBy(posts))
+	report.step("books", start)
 
-	// Copy blog images
-	copyBlogImages()
+	// Content quoting a passage or referring to a page that has gone fails
+	// the build, so the quote or link isn't silently lost
+	for _, s := range report.Skipped {
+		if errors.Is(s.err, errMissingPassage) || errors.Is(s.err, errDeadReference) {
+			return fmt.Errorf("%s %s: %w", s.Kind, s.Slug, s.err)
+		}
+	}
 
-	fmt.Println("Site built successfully in ./public")
+	// Generate the home page, listings and feeds of each language
+	start = time.Now()
+	languages := siteLanguages(posts, books)
+	for _, lang := range languages {
+		generateHomePage(languages, lang)
+		generatePostsListPage(posts, languages, lang)
+		generateBooksListPage(books, languages, lang)
+		generateOPDSFeeds(books, lang)
+	}
+	report.step("listings", start)
+
+	// Generate the JSON API
+	start = time.Now()
+	generateAPI(posts, books)
+	report.step("api", start)
+
+	// Copy the files bundled with posts
+	start = time.Now()
+	copyPostBundles(posts)
+	report.step("bundles", start)
+
+	report.finish()
+	if n := report.problems(); n > 0 {
+		fmt.Printf("Site built in %s with %d problem(s)\n", outputDir, n)
+	} else {
+		fmt.Printf("Site built successfully in %s\n", outputDir)
+	}
+	return nil
 }
 
-func generateHomePage() {
-	content, err := readMarkdownFile("title-page/index.md")
+func generateHomePage(languages []string, lang string) {
+	// Fall back to the default language when the page hasn't been translated
+	content, err := readMarkdownFile(titlePageFile(lang))
+	if os.IsNotExist(err) {
+		content, err = readMarkdownFile(titlePageFile(defaultLanguage))
+	}
 	if err != nil {
-		log.Printf("Error reading title page: %v", err)
-		content = template.HTML("<p>Welcome to my blog!</p>")
+		report.warn("Error reading title page: %v", err)
+		content = template.HTML("<p>" + template.HTMLEscapeString(uiString(lang, "welcome")) + "</p>")
 	}
 
 	data := PageData{
-		Title:   "My Personal Website",
-		Content: content,
+		Title:        uiString(lang, "site_title"),
+		Content:      content,
+		Lang:         lang,
+		Translations: listingTranslations(languages, lang, "/"),
 	}
 
-	renderToFile("public/index.html", "home.html", data)
+	outputPath := filepath.Join(outputDir, languagePrefix(lang), "index.html")
+	os.MkdirAll(filepath.Dir(outputPath), 0755)
+	renderToFile(outputPath, "home.html", data)
 }
 
 func generatePostPages() []Post {
@@ -167,35 +159,56 @@ func generatePostPages() []Post {
 	if err != nil {
 		log.Fatal("Error loading posts:", err)
 	}
+	linkPostTranslations(posts)
+	backlinks := referencedBy(posts)
 
 	// Generate individual post pages
 	for _, post := range posts {
 		data := PageData{
-			Title: post.Metadata.Title,
-			Post:  &post,
+			Title:        post.Metadata.Title,
+			Post:         &post,
+			Translations: post.Translations,
+			ReferencedBy: backlinks["post:"+post.Slug],
 		}
 
-		outputPath := fmt.Sprintf("public/post/%s/index.html", post.Slug)
+		outputPath := filepath.Join(outputDir, post.Path(), "index.html")
 		os.MkdirAll(filepath.Dir(outputPath), 0755)
 		renderToFile(outputPath, "post.html", data)
+		writeCitationFiles(filepath.Dir(outputPath), post.Citation())
+		writeGeneratedImages(filepath.Dir(outputPath), postCardImages(&post))
 	}
 
 	return posts
 }
 
-func generatePostsListPage(posts []Post) {
+func generatePostsListPage(posts []Post, languages []string, lang string) {
 	data := PageData{
-		Title: "Blog Posts",
-		Posts: posts,
+		Title:        uiString(lang, "blog_posts"),
+		Posts:        postsInLanguage(posts, lang),
+		Lang:         lang,
+		Translations: listingTranslations(languages, lang, "/posts/"),
 	}
 
-	os.MkdirAll("public/posts", 0755)
-	renderToFile("public/posts/index.html", "posts.html", data)
+	outputPath := filepath.Join(outputDir, languagePrefix(lang), "posts", "index.html")
+	os.MkdirAll(filepath.Dir(outputPath), 0755)
+	renderToFile(outputPath, "posts.html", data)
 }
 
 func renderToFile(outputPath, tmpl string, data interface{}) {
+	// The site path of <out>/x/index.html is /x/
+	rel, err := filepath.Rel(outputDir, outputPath)
+	if err != nil {
+		log.Fatalf("Error rendering %s: %v", outputPath, err)
+	}
+	path := strings.TrimSuffix("/"+filepath.ToSlash(rel), "index.html")
+
+	data, err = withLanguage(data)
+	if err != nil {
+		log.Fatalf("Error loading interface strings for %s: %v", outputPath, err)
+	}
+
 	var buf bytes.Buffer
-	err := templates.ExecuteTemplate(&buf, tmpl, data)
+	err = templates.ExecuteTemplate(&buf, tmpl, withSEO(data, path))
 	if err != nil {
 		log.Fatalf("Error rendering template %s: %v", tmpl, err)
 	}
@@ -206,6 +219,7 @@ func renderToFile(outputPath, tmpl string, data interface{}) {
 	}
 
 	fmt.Printf("Generated: %s\n", outputPath)
+	report.generated(outputPath)
 }
 
 func copyDir(src, dst string) error {
@@ -246,282 +260,255 @@ func copyFile(src, dst string) error {
 	return err
 }
 
-func copyBlogImages() {
-	blogsDir := "blogs"
-	entries, err := os.ReadDir(blogsDir)
-	if err != nil {
-		log.Printf("Error reading blogs directory: %v", err)
-		return
-	}
-
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-
-		srcImages := filepath.Join(blogsDir, entry.Name(), "images")
-		if _, err := os.Stat(srcImages); err == nil {
-			dstImages := filepath.Join("public/post", entry.Name(), "images")
-			os.MkdirAll(dstImages, 0755)
-			copyDir(srcImages, dstImages)
-		}
-	}
-}
-
-// Reuse the same helper functions from main.go
-func loadAllPosts() ([]Post, error) {
-	var posts []Post
-
-	blogsDir := "blogs"
-	entries, err := os.ReadDir(blogsDir)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-
-		post, err := loadPost(entry.Name())
+// copyPostBundles publishes the assets of every post next to it. Generated
+// files, such as the citations, take precedence over assets of the same name.
+func copyPostBundles(posts []Post) {
+	for _, post := range posts {
+		assets, err := postBundleAssets(post.Slug)
 		if err != nil {
-			log.Printf("Error loading post %s: %v", entry.Name(), err)
+			report.warn("Error listing the files of %s: %v", post.Slug, err)
 			continue
 		}
-
-		posts = append(posts, *post)
-	}
-
-	// Sort posts by date (newest first)
-	sort.Slice(posts, func(i, j int) bool {
-		return posts[i].Metadata.Date.After(posts[j].Metadata.Date)
-	})
-
-	return posts, nil
-}
-
-func loadPost(slug string) (*Post, error) {
-	postDir := filepath.Join("blogs", slug)
-
-	// Read metadata
-	metadataPath := filepath.Join(postDir, "metadata.yaml")
-	metadata, err := readMetadata(metadataPath)
-	if err != nil {
-		return nil, err
-	}
-
-	// Read content
-	contentPath := filepath.Join(postDir, "index.md")
-	content, err := readMarkdownFile(contentPath)
-	if err != nil {
-		return nil, err
-	}
-
-	return &Post{
-		Metadata: *metadata,
-		Content:  content,
-		Slug:     slug,
-	}, nil
-}
-
-func readMetadata(path string) (*PostMetadata, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
-	var metadata PostMetadata
-	err = yaml.Unmarshal(data, &metadata)
-	if err != nil {
-		return nil, err
-	}
-
-	return &metadata, nil
-}
-
-func readMarkdownFile(path string) (template.HTML, error) {
-	content, err := os.ReadFile(path)
-	if err != nil {
-		return "", err
-	}
-
-	var buf bytes.Buffer
-	if err := md.Convert(content, &buf); err != nil {
-		return "", err
+		for _, rel := range assets {
+			src := filepath.Join("blogs", post.Slug, filepath.FromSlash(rel))
+			dst := filepath.Join(outputDir, post.Path(), filepath.FromSlash(rel))
+			if _, err := os.Stat(dst); err == nil {
+				report.warn("Not copying %s: it would replace a generated file", src)
+				continue
+			}
+			os.MkdirAll(filepath.Dir(dst), 0755)
+			if err := copyFile(src, dst); err != nil {
+				report.warn("Error copying %s: %v", src, err)
+			}
+		}
 	}
-
-	return template.HTML(buf.String()), nil
 }
 
-func generateBookPages() []Book {
+// generateBookPages renders every book, listing under each chapter the
+// posts in backlinks that refer to it
+func generateBookPages(backlinks map[string][]Post) []Book {
 	books, err := loadAllBooks()
 	if err != nil {
-		log.Printf("Error loading books: %v", err)
+		report.warn("Error loading books: %v", err)
 		return nil
 	}
+	linkBookTranslations(books)
 
 	for _, book := range books {
+		// Record paragraph ids, aliasing any that changed since the last
+		// update, only when asked to: a build doesn't write to the content
+		if updateAnchors {
+			anchors, err := updateAnchorManifest(&book)
+			if err != nil {
+				report.warn("Error updating paragraph anchors for %s: %v", book.Slug, err)
+			} else {
+				book.Anchors = anchors
+				fmt.Printf("Updated: %s\n", filepath.Join("books", book.Slug, "anchors.yaml"))
+			}
+		}
+
 		// Generate book table of contents page
 		data := PageData{
-			Title: book.Metadata.Title,
-			Book:  &book,
+			Title:        book.Metadata.Title,
+			Book:         &book,
+			Translations: book.Translations,
 		}
 
-		bookDir := fmt.Sprintf("public/book/%s", book.Slug)
+		bookDir := filepath.Join(outputDir, book.Path())
 		os.MkdirAll(bookDir, 0755)
 		renderToFile(filepath.Join(bookDir, "index.html"), "book.html", data)
+		writeCitationFiles(bookDir, book.Citation())
 
 		// Copy EPUB file if it exists
 		if book.Metadata.EpubFile != "" {
 			srcEpub := filepath.Join("books", book.Slug, book.Metadata.EpubFile)
 			dstEpub := filepath.Join(bookDir, book.Metadata.EpubFile)
 			if err := copyFile(srcEpub, dstEpub); err != nil {
-				log.Printf("Error copying EPUB for %s: %v", book.Slug, err)
+				report.warn("Error copying EPUB for %s: %v", book.Slug, err)
 			} else {
 				fmt.Printf("Copied: %s\n", dstEpub)
 			}
 		}
 
-		// Generate individual chapter pages
-		for i, chapterInfo := range book.Chapters {
-			chapter, err := loadChapter(&book, chapterInfo.Slug)
+		// Generate the glossary page
+		if len(book.Glossary) > 0 {
+			glossaryData := PageData{
+				Title: uiString(book.Lang(), "glossary") + " - " + book.Metadata.Title,
+				Book:  &book,
+			}
+
+			glossaryPath := filepath.Join(bookDir, "glossary", "index.html")
+			os.MkdirAll(filepath.Dir(glossaryPath), 0755)
+			renderToFile(glossaryPath, "glossary.html", glossaryData)
+		}
+
+		// Copy cover image if there is one
+		if book.Metadata.Cover != "" {
+			srcCover := filepath.Join("books", book.Slug, book.Metadata.Cover)
+			dstCover := filepath.Join(bookDir, book.Metadata.Cover)
+			if err := copyFile(srcCover, dstCover); err != nil {
+				report.warn("Error copying cover for %s: %v", book.Slug, err)
+			} else {
+				fmt.Printf("Copied: %s\n", dstCover)
+			}
+		}
+
+		// Generate the covers a hand-made one doesn't replace
+		writeGeneratedImages(bookDir, bookCoverImages(&book))
+
+		// Generate plain text, Markdown and HTML editions
+		for _, export := range bookExports(&book) {
+			data, err := export.Build(&book)
 			if err != nil {
-				log.Printf("Error loading chapter %s: %v", chapterInfo.Slug, err)
+				report.warn("Error generating %s for %s: %v", export.FileName, book.Slug, err)
 				continue
 			}
+			writeGeneratedFile(filepath.Join(bookDir, export.FileName), data)
+		}
 
-			// Set prev/next chapters
-			if i > 0 {
-				chapter.PrevChapter = &book.Chapters[i-1]
+		// Generate the whole book on a single page
+		all, err := loadAllChapters(&book)
+		if err != nil {
+			report.warn("Error loading chapters for %s: %v", book.Slug, err)
+		} else {
+			allData := PageData{
+				Title:   book.Metadata.Title,
+				Book:    &book,
+				BookAll: all,
 			}
-			if i < len(book.Chapters)-1 {
-				chapter.NextChapter = &book.Chapters[i+1]
+
+			allPath := filepath.Join(bookDir, "all", "index.html")
+			os.MkdirAll(filepath.Dir(allPath), 0755)
+			renderToFile(allPath, "book_all.html", allData)
+		}
+
+		// Generate individual chapter pages
+		for _, chapterInfo := range book.Chapters {
+			chapter, err := loadChapter(&book, chapterInfo.Slug)
+			if err != nil {
+				log.Printf("Error loading chapter %s/%s: %v", book.Slug, chapterInfo.Slug, err)
+				report.skip("chapter", book.Slug+"/"+chapterInfo.Slug, err)
+				continue
 			}
 
+			chapter.Content = linkGlossaryTerms(&book, chapter.Content)
+
 			chapterData := PageData{
-				Title:   chapter.Title + " - " + book.Metadata.Title,
-				Book:    &book,
-				Chapter: chapter,
+				Title:        chapter.Title + " - " + book.Metadata.Title,
+				Book:         &book,
+				Chapter:      chapter,
+				ReferencedBy: backlinks["book:"+book.Slug+"/"+chapterInfo.Slug],
 			}
 
 			chapterPath := filepath.Join(bookDir, chapterInfo.Slug, "index.html")
 			os.MkdirAll(filepath.Dir(chapterPath), 0755)
 			renderToFile(chapterPath, "chapter.html", chapterData)
+			writeCitationFiles(filepath.Dir(chapterPath), book.ChapterCitation(chapterInfo.Slug))
 		}
 	}
 
 	return books
 }
 
-func generateBooksListPage(books []Book) {
+func generateBooksListPage(books []Book, languages []string, lang string) {
 	data := PageData{
-		Title: "Books",
-		Books: books,
+		Title:        uiString(lang, "books"),
+		Books:        booksInLanguage(books, lang),
+		Lang:         lang,
+		Translations: listingTranslations(languages, lang, "/books/"),
 	}
 
-	renderToFile("public/books/index.html", "books.html", data)
+	outputPath := filepath.Join(outputDir, languagePrefix(lang), "books", "index.html")
+	os.MkdirAll(filepath.Dir(outputPath), 0755)
+	renderToFile(outputPath, "books.html", data)
 }
 
-func loadAllBooks() ([]Book, error) {
-	var books []Book
+func generateOPDSFeeds(books []Book, lang string) {
+	books = booksInLanguage(books, lang)
+	dir := filepath.Join(outputDir, languagePrefix(lang))
 
-	booksDir := "books"
-	entries, err := os.ReadDir(booksDir)
+	feed, err := buildOPDSFeed(books, lang)
 	if err != nil {
-		return nil, err
+		log.Fatalf("Error building OPDS feed: %v", err)
 	}
+	writeGeneratedFile(filepath.Join(dir, "opds.xml"), feed)
 
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
-		}
-
-		book, err := loadBook(entry.Name())
-		if err != nil {
-			log.Printf("Error loading book %s: %v", entry.Name(), err)
-			continue
-		}
-
-		books = append(books, *book)
+	feed2, err := buildOPDS2Feed(books, lang)
+	if err != nil {
+		log.Fatalf("Error building OPDS feed: %v", err)
 	}
-
-	return books, nil
+	writeGeneratedFile(filepath.Join(dir, "opds.json"), feed2)
 }
 
-func loadBook(slug string) (*Book, error) {
-	bookDir := filepath.Join("books", slug)
+// generateAPI writes the read-only JSON API as static files, one per endpoint
+func generateAPI(posts []Post, books []Book) {
+	dir := filepath.Join(outputDir, filepath.FromSlash(apiPrefix))
+	os.MkdirAll(filepath.Join(dir, "posts"), 0755)
+	os.MkdirAll(filepath.Join(dir, "books"), 0755)
 
-	// Read metadata
-	metadataPath := filepath.Join(bookDir, "metadata.yaml")
-	metadataData, err := os.ReadFile(metadataPath)
-	if err != nil {
-		return nil, err
+	if err := copyFile(apiSchemaFile, filepath.Join(dir, "schema.json")); err != nil {
+		log.Fatalf("Error copying API schema: %v", err)
 	}
 
-	var metadata BookMetadata
-	err = yaml.Unmarshal(metadataData, &metadata)
-	if err != nil {
-		return nil, err
+	writeAPIFile(filepath.Join(dir, "posts.json"), apiPostList(posts))
+	for i := range posts {
+		writeAPIFile(filepath.Join(dir, "posts", posts[i].Slug+".json"), apiPost(&posts[i]))
 	}
 
-	// Read chapters config
-	chaptersPath := filepath.Join(bookDir, "chapters.yaml")
-	chaptersData, err := os.ReadFile(chaptersPath)
-	if err != nil {
-		return nil, err
-	}
+	writeAPIFile(filepath.Join(dir, "books.json"), apiBookList(books))
+	for i := range books {
+		book := &books[i]
+		writeAPIFile(filepath.Join(dir, "books", book.Slug+".json"), apiBook(book))
 
-	var chaptersConfig ChaptersConfig
-	err = yaml.Unmarshal(chaptersData, &chaptersConfig)
-	if err != nil {
-		return nil, err
+		chaptersDir := filepath.Join(dir, "books", book.Slug, "chapters")
+		os.MkdirAll(chaptersDir, 0755)
+		for _, ch := range book.Chapters {
+			chapter, err := apiChapter(book, ch.Slug)
+			if err != nil {
+				report.warn("Error loading chapter %s/%s for the API: %v", book.Slug, ch.Slug, err)
+				continue
+			}
+			writeAPIFile(filepath.Join(chaptersDir, ch.Slug+".json"), chapter)
+		}
 	}
+}
 
-	// Read snippet (optional) - short intro for books list
-	var snippet template.HTML
-	snippetPath := filepath.Join(bookDir, "snippet.html")
-	if snippetData, err := os.ReadFile(snippetPath); err == nil {
-		snippet = template.HTML(snippetData)
+func writeAPIFile(outputPath string, v interface{}) {
+	data, err := encodeAPI(v)
+	if err != nil {
+		log.Fatalf("Error encoding %s: %v", outputPath, err)
 	}
+	writeGeneratedFile(outputPath, data)
+}
 
-	// Read intro (optional) - longer intro for book page
-	var intro template.HTML
-	introPath := filepath.Join(bookDir, "intro.html")
-	if introData, err := os.ReadFile(introPath); err == nil {
-		intro = template.HTML(introData)
+func writeGeneratedFile(outputPath string, data []byte) {
+	err := os.WriteFile(outputPath, data, 0644)
+	if err != nil {
+		log.Fatalf("Error writing file %s: %v", outputPath, err)
 	}
 
-	return &Book{
-		Metadata: metadata,
-		Slug:     slug,
-		Chapters: chaptersConfig.Chapters,
-		Snippet:  snippet,
-		Intro:    intro,
-	}, nil
+	fmt.Printf("Generated: %s\n", outputPath)
+	report.generated(outputPath)
 }
 
-func loadChapter(book *Book, chapterSlug string) (*ChapterData, error) {
-	// Find chapter info
-	var chapterInfo ChapterInfo
-	for _, ch := range book.Chapters {
-		if ch.Slug == chapterSlug {
-			chapterInfo = ch
-			break
+func writeGeneratedImages(dir string, images []generatedImage) {
+	for _, img := range images {
+		data, err := img.Render()
+		if err != nil {
+			report.warn("Error generating %s in %s: %v", img.FileName, dir, err)
+			continue
 		}
+		writeGeneratedFile(filepath.Join(dir, img.FileName), data)
 	}
+}
 
-	// Read chapter content
-	chapterPath := filepath.Join("books", book.Slug, "chapters", chapterSlug+".xhtml")
-	content, err := os.ReadFile(chapterPath)
-	if err != nil {
-		return nil, err
+func writeCitationFiles(dir string, citation Citation) {
+	for _, format := range citationFormats {
+		data, err := format.Render(citation)
+		if err != nil {
+			report.warn("Error rendering citation %s: %v", citation.Key, err)
+			continue
+		}
+		writeGeneratedFile(filepath.Join(dir, format.FileName), data)
 	}
-
-	return &ChapterData{
-		Title:       chapterInfo.Title,
-		Content:     template.HTML(content),
-		BookSlug:    book.Slug,
-		BookTitle:   book.Metadata.Title,
-		ChapterSlug: chapterSlug,
-	}, nil
 }
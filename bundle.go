@@ -0,0 +1,131 @@
+package main
+
+import (
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// A post is a bundle: every file in its directory other than its content
+// is published next to the post, so index.md can link to images, PDFs and
+// attachments by relative paths.
+
+// isBundleAsset reports whether a file in a post directory, given by its
+// slash-separated path relative to it, is published with the post
+func isBundleAsset(rel string) bool {
+	if rel == "index.md" || rel == "metadata.yaml" {
+		return false
+	}
+	for _, part := range strings.Split(rel, "/") {
+		if part == "" || part == ".." || strings.HasPrefix(part, ".") {
+			return false
+		}
+	}
+	return true
+}
+
+// postBundleAssets returns the slash-separated paths of the assets of a post
+func postBundleAssets(slug string) ([]string, error) {
+	dir := filepath.Join("blogs", slug)
+	var assets []string
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == "." {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isBundleAsset(rel) {
+			assets = append(assets, rel)
+		}
+		return nil
+	})
+	return assets, err
+}
+
+// postBundleFile returns the file of a post asset requested by its path
+// under the post, and false if there is no such asset
+func postBundleFile(slug, file string) (string, bool) {
+	rel := strings.TrimPrefix(path.Clean("/"+file), "/")
+	if !isBundleAsset(rel) {
+		return "", false
+	}
+	p := filepath.Join("blogs", slug, filepath.FromSlash(rel))
+	info, err := os.Stat(p)
+	if err != nil || info.IsDir() {
+		return "", false
+	}
+	return p, true
+}
+
+// bundleURL resolves a link or image path relative to a post against the
+// post's site path, so it works whether or not the page URL ends in a slash
+func bundleURL(base, dest string) string {
+	if base == "" || dest == "" || strings.HasPrefix(dest, "/") || strings.HasPrefix(dest, "#") || strings.HasPrefix(dest, "?") {
+		return dest
+	}
+	u, err := url.Parse(dest)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return dest
+	}
+	u.Path = path.Join(base, u.Path)
+	return u.String()
+}
+
+// bundleBaseKey holds the site path of the post being converted, if any
+var bundleBaseKey = parser.NewContextKey()
+
+// bundleBase returns the site path relative links are resolved against
+func bundleBase(pc parser.Context) string {
+	base, _ := pc.Get(bundleBaseKey).(string)
+	return base
+}
+
+// bundlePathTransformer makes the link and image destinations of a post
+// that are relative to it absolute
+type bundlePathTransformer struct{}
+
+func (t bundlePathTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	base := bundleBase(pc)
+	if base == "" {
+		return
+	}
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := node.(type) {
+		case *ast.Link:
+			n.Destination = []byte(bundleURL(base, string(n.Destination)))
+		case *ast.Image:
+			n.Destination = []byte(bundleURL(base, string(n.Destination)))
+		}
+		return ast.WalkContinue, nil
+	})
+}
+
+// bundleExtension resolves the relative paths of posts
+type bundleExtension struct{}
+
+func (e bundleExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(bundlePathTransformer{}, 500),
+	))
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestBundleURL(t *testing.T) {
+	tests := []struct {
+		base, dest, want string
+	}{
+		{"/post/hello", "images/a.png", "/post/hello/images/a.png"},
+		{"/post/hello", "./data/x.csv", "/post/hello/data/x.csv"},
+		{"/post/hello", "notes.pdf#page=2", "/post/hello/notes.pdf#page=2"},
+		{"/post/hello", "a.png?v=2", "/post/hello/a.png?v=2"},
+		{"/post/hello", "../other/", "/post/other"},
+		{"/hi/post/namaste", "images/a.png", "/hi/post/namaste/images/a.png"},
+		{"/post/hello", "/static/a.png", "/static/a.png"},
+		{"/post/hello", "#section", "#section"},
+		{"/post/hello", "?q=1", "?q=1"},
+		{"/post/hello", "https://e.org/a.png", "https://e.org/a.png"},
+		{"/post/hello", "//cdn.e.org/a.png", "//cdn.e.org/a.png"},
+		{"/post/hello", "mailto:a@e.org", "mailto:a@e.org"},
+		{"/post/hello", "", ""},
+		{"", "images/a.png", "images/a.png"},
+	}
+	for _, tt := range tests {
+		if got := bundleURL(tt.base, tt.dest); got != tt.want {
+			t.Errorf("bundleURL(%q, %q) = %q, want %q", tt.base, tt.dest, got, tt.want)
+		}
+	}
+}
+
+func TestIsBundleAsset(t *testing.T) {
+	tests := []struct {
+		rel  string
+		want bool
+	}{
+		{"a.png", true},
+		{"images/a.png", true},
+		{"index.md", false},
+		{"metadata.yaml", false},
+		{"images/index.md", true},
+		{".DS_Store", false},
+		{"images/.hidden", false},
+		{".git/config", false},
+		{"../secret", false},
+		{"a//b", false},
+	}
+	for _, tt := range tests {
+		if got := isBundleAsset(tt.rel); got != tt.want {
+			t.Errorf("isBundleAsset(%q) = %v, want %v", tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestPostBundle(t *testing.T) {
+	inContentRoot(t, map[string]string{
+		"blogs/hello/index.md":         "# Hi\n\n![map](images/map.png) [notes](notes.pdf) [home](/)\n",
+		"blogs/hello/metadata.yaml":    "title: Hello\ndate: 2025-01-01T00:00:00Z\n",
+		"blogs/hello/notes.pdf":        "%PDF",
+		"blogs/hello/images/map.png":   "png",
+		"blogs/hello/.DS_Store":        "",
+		"blogs/hello/.drafts/draft.md": "",
+	})
+
+	assets, err := postBundleAssets("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"images/map.png", "notes.pdf"}; !slices.Equal(assets, want) {
+		t.Errorf("assets = %q, want %q", assets, want)
+	}
+
+	for file, want := range map[string]bool{
+		"notes.pdf":            true,
+		"/images/map.png":      true,
+		"images/../notes.pdf":  true,
+		"../hello/notes.pdf":   false,
+		"index.md":             false,
+		"metadata.yaml":        false,
+		".DS_Store":            false,
+		"images":               false,
+		"missing.png":          false,
+		".drafts/draft.md":     false,
+		"../../blogs/hello/in": false,
+	} {
+		if _, ok := postBundleFile("hello", file); ok != want {
+			t.Errorf("postBundleFile(%q) found = %v, want %v", file, ok, want)
+		}
+	}
+
+	post, err := loadPost("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`src="/post/hello/images/map.png"`, `href="/post/hello/notes.pdf"`, `href="/"`} {
+		if !strings.Contains(string(post.Content), want) {
+			t.Errorf("content lacks %s:\n%s", want, post.Content)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	east "github.com/yuin/goldmark/extension/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// chapterMD renders chapters written in Markdown. It is md with footnotes,
+// set up in init.
+var chapterMD goldmark.Markdown
+
+// readChapter returns the XHTML of a chapter, read from
+// chapters/<slug>.xhtml or rendered from chapters/<slug>.md, so that every
+// later step treats both the same. Its punctuation is normalized if the
+// book's typography.yaml asks for it on load.
+func readChapter(book *Book, chapterSlug string) (string, error) {
+	content, err := readChapterMarkup(book, chapterSlug)
+	if err != nil || book.Typographer == nil {
+		return content, err
+	}
+	content, _ = book.Typographer.normalize(content)
+	return content, nil
+}
+
+// readChapterMarkup returns the XHTML of a chapter as written
+func readChapterMarkup(book *Book, chapterSlug string) (string, error) {
+	dir := filepath.Join("books", book.Slug, "chapters")
+	data, err := os.ReadFile(filepath.Join(dir, chapterSlug+".xhtml"))
+	if !errors.Is(err, os.ErrNotExist) {
+		return string(data), err
+	}
+
+	source, err := os.ReadFile(filepath.Join(dir, chapterSlug+".md"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("neither %s.xhtml nor %s.md exists: %w", chapterSlug, chapterSlug, os.ErrNotExist)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	ctx := parser.NewContext()
+	ctx.Set(markdownLanguageKey, book.Lang())
+	var buf bytes.Buffer
+	if err := chapterMD.Convert(source, &buf, parser.WithContext(ctx)); err != nil {
+		return "", fmt.Errorf("%s.md: %w", chapterSlug, err)
+	}
+	return buf.String(), nil
+}
+
+// chapterFootnoteRenderer writes goldmark footnotes the way the XHTML
+// chapters mark them up: references are noteref links and each note is an
+// EPUB footnote aside, numbered in its text
+type chapterFootnoteRenderer struct{}
+
+func (r *chapterFootnoteRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(east.KindFootnoteLink, r.renderLink)
+	reg.Register(east.KindFootnoteBacklink, r.renderNothing)
+	reg.Register(east.KindFootnoteList, r.renderNothing)
+	reg.Register(east.KindFootnote, r.renderNote)
+}
+
+func (r *chapterFootnoteRenderer) renderLink(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		n := node.(*east.FootnoteLink)
+		fmt.Fprintf(w, `<a href="#footnote%d" epub:type="noteref"><sup>%d</sup></a>`, n.Index, n.Index)
+	}
+	return ast.WalkContinue, nil
+}
+
+func (r *chapterFootnoteRenderer) renderNothing(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	return ast.WalkContinue, nil
+}
+
+func (r *chapterFootnoteRenderer) renderNote(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	n := node.(*east.Footnote)
+	if entering {
+		fmt.Fprintf(w, "<aside id=\"footnote%d\" epub:type=\"footnote\">\n", n.Index)
+	} else {
+		w.WriteString("</aside>\n")
+	}
+	return ast.WalkContinue, nil
+}
+
+// chapterFootnoteNumbers starts the text of every footnote with its number,
+// e.g. "1. ", as the XHTML chapters do. It runs after goldmark has numbered
+// the footnotes.
+type chapterFootnoteNumbers struct{}
+
+func (t chapterFootnoteNumbers) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		n, ok := node.(*east.Footnote)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		number := ast.NewString([]byte(strconv.Itoa(n.Index) + ". "))
+		if para := n.FirstChild(); para != nil && para.Kind() == ast.KindParagraph {
+			para.InsertBefore(para, para.FirstChild(), number)
+		} else {
+			n.InsertBefore(n, n.FirstChild(), number)
+		}
+		return ast.WalkSkipChildren, nil
+	})
+}
+
+// chapterFootnoteExtension renders footnotes as in the XHTML chapters. It
+// goes with extension.Footnote.
+type chapterFootnoteExtension struct{}
+
+func (e chapterFootnoteExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithASTTransformers(
+		util.Prioritized(chapterFootnoteNumbers{}, 1000),
+	))
+	// Ahead of the footnote extension's own renderer
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&chapterFootnoteRenderer{}, 100),
+	))
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestChapterFootnotes(t *testing.T) {
+	inContentRoot(t, map[string]string{
+		"books/b/chapters/one.md": "# One\n\nFirst.[^a] Second.[^b]\n\n[^a]: A note.\n[^b]: Another.\n",
+	})
+	content, err := readChapterMarkup(&Book{Slug: "b"}, "one")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// As the XHTML chapters mark them up
+	for _, want := range []string{
+		`First.<a href="#footnote1" epub:type="noteref"><sup>1</sup></a>`,
+		`Second.<a href="#footnote2" epub:type="noteref"><sup>2</sup></a>`,
+		`<aside id="footnote1" epub:type="footnote">`,
+		"1. A note.",
+		`<aside id="footnote2" epub:type="footnote">`,
+		"2. Another.",
+	} {
+		if !strings.Contains(content, want) {
+			t.Errorf("chapter lacks %s:\n%s", want, content)
+		}
+	}
+	if strings.Contains(content, "footnote-backref") || strings.Contains(content, `class="footnotes"`) {
+		t.Errorf("chapter has goldmark's own footnote markup:\n%s", content)
+	}
+
+	// Reference numbers stay out of the running text
+	if text := plainText(content); strings.Contains(text, "First.1") || strings.Contains(text, "A note") {
+		t.Errorf("plain text = %q", text)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// linkAttrPattern matches the link and source attributes of built pages
+var linkAttrPattern = regexp.MustCompile(`\b(?:href|src)="([^"]*)"`)
+
+// runCheck builds the site, reporting on the build, and checks that every
+// internal link and image in the built pages resolves to a built file
+func runCheck(cfg *config, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	if err := buildSite(); err != nil {
+		return err
+	}
+	if err := finishReport(cfg); err != nil {
+		return err
+	}
+
+	broken, err := checkLinks(outputDir)
+	if err != nil {
+		return err
+	}
+	for _, link := range broken {
+		fmt.Println(link)
+	}
+	if len(broken) > 0 {
+		return fmt.Errorf("%d broken link(s) found", len(broken))
+	}
+	fmt.Println("No broken links found")
+	return nil
+}
+
+// checkLinks returns a description of every internal link in the HTML
+// pages under dir that doesn't resolve to a file there
+func checkLinks(dir string) ([]string, error) {
+	var broken []string
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, ".html") {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		for _, match := range linkAttrPattern.FindAllStringSubmatch(string(data), -1) {
+			target, ok := linkTarget(dir, path, html.UnescapeString(match[1]))
+			if ok && !builtFileExists(target) {
+				rel, _ := filepath.Rel(dir, path)
+				broken = append(broken, fmt.Sprintf("%s: broken link %s", rel, match[1]))
+			}
+		}
+		return nil
+	})
+	sort.Strings(broken)
+	return broken, err
+}
+
+// linkTarget returns the file under dir a link on page refers to, and
+// false for links that leave the site
+func linkTarget(dir, page, link string) (string, bool) {
+	if baseURL != "" && strings.HasPrefix(link, baseURL+"/") {
+		link = strings.TrimPrefix(link, baseURL)
+	}
+
+	u, err := url.Parse(link)
+	if err != nil || u.Scheme != "" || u.Host != "" || u.Path == "" {
+		return "", false
+	}
+
+	if strings.HasPrefix(u.Path, "/") {
+		return filepath.Join(dir, filepath.FromSlash(u.Path)), true
+	}
+	return filepath.Join(filepath.Dir(page), filepath.FromSlash(u.Path)), true
+}
+
+// builtFileExists reports whether a link target was built, either as a
+// file or as a directory with an index page
+func builtFileExists(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if info.IsDir() {
+		_, err = os.Stat(filepath.Join(path, "index.html"))
+		return err == nil
+	}
+	return true
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// siteAuthor is credited as the author of blog posts
+const siteAuthor = "Sashank Tirumala"
+
+// baseURL is prepended to site paths wherever an absolute URL is needed.
+// It is set by the -base-url flag.
+var baseURL string
+
+// absoluteURL returns the absolute URL of a site path
+func absoluteURL(path string) string {
+	return baseURL + path
+}
+
+// Citation represents the bibliographic data of a book, chapter or post
+type Citation struct {
+	Key         string
+	Kind        string // "book", "chapter" or "post"
+	Title       string
+	Container   string
+	Authors     []string
+	Translators []string
+	Editors     []string
+	Year        int
+	Date        time.Time
+	Publisher   string
+	Edition     string
+	ISBN        string
+	Language    string
+	Pages       string
+	Path        string
+	URL         string
+}
+
+// FileURL returns the site path of one of the citation's download files
+func (c Citation) FileURL(name string) string {
+	return c.Path + "/" + name
+}
+
+// citationFormat describes a downloadable citation file
+type citationFormat struct {
+	FileName    string
+	ContentType string
+	Render      func(c Citation) ([]byte, error)
+}
+
+var citationFormats = []citationFormat{
+	{FileName: "cite.bib", ContentType: "application/x-bibtex; charset=utf-8", Render: func(c Citation) ([]byte, error) { return []byte(c.BibTeX()), nil }},
+	{FileName: "cite.ris", ContentType: "application/x-research-info-systems; charset=utf-8", Render: func(c Citation) ([]byte, error) { return []byte(c.RIS()), nil }},
+	{FileName: "cite.json", ContentType: "application/vnd.citationstyles.csl+json", Render: Citation.CSLJSON},
+}
+
+// findCitationFormat returns the citation format served under name
+func findCitationFormat(name string) (citationFormat, bool) {
+	for _, format := range citationFormats {
+		if format.FileName == name {
+			return format, true
+		}
+	}
+	return citationFormat{}, false
+}
+
+func nonEmpty(names ...string) []string {
+	var out []string
+	for _, name := range names {
+		if name != "" {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
+// Citation returns the citation data of a book
+func (b Book) Citation() Citation {
+	return Citation{
+		Key:         b.Slug,
+		Kind:        "book",
+		Title:       b.Metadata.Title,
+		Authors:     nonEmpty(b.Metadata.Author),
+		Translators: nonEmpty(b.Metadata.Translator),
+		Editors:     nonEmpty(b.Metadata.Editor),
+		Year:        b.Metadata.Year,
+		Publisher:   b.Metadata.Publisher,
+		Edition:     b.Metadata.Edition,
+		ISBN:        b.Metadata.ISBN(),
+		Language:    b.Metadata.Language,
+		Path:        b.Path(),
+		URL:         absoluteURL(b.Path() + "/"),
+	}
+}
+
+// ChapterCitation returns the citation data of one of a book's chapters
+func (b Book) ChapterCitation(chapterSlug string) Citation {
+	c := b.Citation()
+	c.Key = b.Slug + ":" + chapterSlug
+	c.Kind = "chapter"
+	c.Container = b.Metadata.Title
+	c.Path = b.Path() + "/" + chapterSlug
+	c.URL = absoluteURL(c.Path)
+	for _, ch := range b.Chapters {
+		if ch.Slug == chapterSlug {
+			c.Title = ch.Title
+			c.Pages = ch.Pages
+		}
+	}
+	return c
+}
+
+// Citation returns the citation data of a blog post
+func (p Post) Citation() Citation {
+	return Citation{
+		Key:      p.Slug,
+		Kind:     "post",
+		Title:    p.Metadata.Title,
+		Authors:  []string{siteAuthor},
+		Year:     p.Metadata.Date.Year(),
+		Date:     p.Metadata.Date,
+		Language: p.Metadata.Language,
+		Path:     p.Path(),
+		URL:      absoluteURL(p.Path()),
+	}
+}
+
+var bibtexEscaper = strings.NewReplacer(`\`, `\textbackslash{}`, "{", `\{`, "}", `\}`, "&", `\&`, "%", `\%`, "$", `\$`, "#", `\#`, "_", `\_`)
+
+// BibTeX renders the citation as a biblatex entry
+func (c Citation) BibTeX() string {
+	entryType := map[string]string{"book": "book", "chapter": "inbook", "post": "online"}[c.Kind]
+
+	var fields [][2]string
+	add := func(name, value string) {
+		if value != "" {
+			fields = append(fields, [2]string{name, value})
+		}
+	}
+	add("title", c.Title)
+	add("booktitle", c.Container)
+	add("author", strings.Join(c.Authors, " and "))
+	add("translator", strings.Join(c.Translators, " and "))
+	add("editor", strings.Join(c.Editors, " and "))
+	if !c.Date.IsZero() {
+		add("date", c.Date.Format("2006-01-02"))
+	} else if c.Year != 0 {
+		add("year", strconv.Itoa(c.Year))
+	}
+	add("publisher", c.Publisher)
+	add("edition", c.Edition)
+	add("isbn", c.ISBN)
+	add("langid", c.Language)
+	add("pages", strings.Replace(c.Pages, "-", "--", 1))
+	add("url", c.URL)
+
+	var out strings.Builder
+	out.WriteString("@" + entryType + "{" + c.Key + ",\n")
+	for i, field := range fields {
+		value := field[1]
+		if field[0] != "url" {
+			value = bibtexEscaper.Replace(value)
+		}
+		out.WriteString("  " + field[0] + " = {" + value + "}")
+		if i < len(fields)-1 {
+			out.WriteString(",")
+		}
+		out.WriteString("\n")
+	}
+	out.WriteString("}\n")
+	return out.String()
+}
+
+// RIS renders the citation in the RIS tagged format
+func (c Citation) RIS() string {
+	entryType := map[string]string{"book": "BOOK", "chapter": "CHAP", "post": "BLOG"}[c.Kind]
+
+	var out strings.Builder
+	add := func(tag, value string) {
+		// A line break would end the field, so values go on one line
+		value = strings.Join(strings.Fields(value), " ")
+		if value != "" {
+			out.WriteString(tag + "  - " + value + "\r\n")
+		}
+	}
+	add("TY", entryType)
+	add("TI", c.Title)
+	add("T2", c.Container)
+	for _, name := range c.Authors {
+		add("AU", name)
+	}
+	for _, name := range c.Editors {
+		add("A2", name)
+	}
+	for _, name := range c.Translators {
+		add("A4", name)
+	}
+	if c.Year != 0 {
+		add("PY", strconv.Itoa(c.Year))
+	}
+	if !c.Date.IsZero() {
+		add("DA", c.Date.Format("2006/01/02"))
+	}
+	add("PB", c.Publisher)
+	add("ET", c.Edition)
+	add("SN", c.ISBN)
+	add("LA", c.Language)
+	if start, end, ok := strings.Cut(c.Pages, "-"); ok {
+		add("SP", start)
+		add("EP", end)
+	} else {
+		add("SP", c.Pages)
+	}
+	add("UR", c.URL)
+	out.WriteString("ER  - \r\n")
+	return out.String()
+}
+
+// cslName represents a CSL-JSON name variable
+type cslName struct {
+	Literal string `json:"literal"`
+}
+
+// cslDate represents a CSL-JSON date variable
+type cslDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+// cslItem represents a CSL-JSON item
+type cslItem struct {
+	ID             string    `json:"id"`
+	Type           string    `json:"type"`
+	Title          string    `json:"title"`
+	ContainerTitle string    `json:"container-title,omitempty"`
+	Author         []cslName `json:"author,omitempty"`
+	Translator     []cslName `json:"translator,omitempty"`
+	Editor         []cslName `json:"editor,omitempty"`
+	Issued         *cslDate  `json:"issued,omitempty"`
+	Publisher      string    `json:"publisher,omitempty"`
+	Edition        string    `json:"edition,omitempty"`
+	ISBN           string    `json:"ISBN,omitempty"`
+	Language       string    `json:"language,omitempty"`
+	Page           string    `json:"page,omitempty"`
+	URL            string    `json:"URL,omitempty"`
+}
+
+func cslNames(names []string) []cslName {
+	var out []cslName
+	for _, name := range names {
+		out = append(out, cslName{Literal: name})
+	}
+	return out
+}
+
+// CSLJSON renders the citation as a CSL-JSON array with a single item
+func (c Citation) CSLJSON() ([]byte, error) {
+	item := cslItem{
+		ID:             c.Key,
+		Type:           map[string]string{"book": "book", "chapter": "chapter", "post": "post-weblog"}[c.Kind],
+		Title:          c.Title,
+		ContainerTitle: c.Container,
+		Author:         cslNames(c.Authors),
+		Translator:     cslNames(c.Translators),
+		Editor:         cslNames(c.Editors),
+		Publisher:      c.Publisher,
+		Edition:        c.Edition,
+		ISBN:           c.ISBN,
+		Language:       c.Language,
+		Page:           c.Pages,
+		URL:            c.URL,
+	}
+	if !c.Date.IsZero() {
+		item.Issued = &cslDate{DateParts: [][]int{{c.Date.Year(), int(c.Date.Month()), c.Date.Day()}}}
+	} else if c.Year != 0 {
+		item.Issued = &cslDate{DateParts: [][]int{{c.Year}}}
+	}
+
+	return json.MarshalIndent([]cslItem{item}, "", "  ")
+}
+
+// Text renders the citation as a human-readable reference for the
+// "Cite this" block
+func (c Citation) Text() template.HTML {
+	esc := html.EscapeString
+
+	var parts []string
+	if len(c.Authors) > 0 {
+		parts = append(parts, esc(strings.Join(c.Authors, ", ")))
+	}
+	if c.Container != "" {
+		title := fmt.Sprintf("&ldquo;%s.&rdquo; In <em>%s</em>", esc(c.Title), esc(c.Container))
+		if c.Pages != "" {
+			title += ", pp. " + esc(c.Pages)
+		}
+		parts = append(parts, title)
+	} else if c.Kind == "post" {
+		parts = append(parts, "&ldquo;"+esc(c.Title)+".&rdquo;")
+	} else {
+		parts = append(parts, "<em>"+esc(c.Title)+"</em>")
+	}
+	if len(c.Translators) > 0 {
+		parts = append(parts, esc(uiString(c.Language, "translated_by")+" "+strings.Join(c.Translators, ", ")))
+	}
+	if len(c.Editors) > 0 {
+		parts = append(parts, esc(uiString(c.Language, "edited_by")+" "+strings.Join(c.Editors, ", ")))
+	}
+	if c.Edition != "" {
+		parts = append(parts, esc(c.Edition))
+	}
+	if c.Publisher != "" {
+		parts = append(parts, esc(c.Publisher))
+	}
+	if !c.Date.IsZero() {
+		parts = append(parts, c.Date.Format("January 2, 2006"))
+	} else if c.Year != 0 {
+		parts = append(parts, strconv.Itoa(c.Year))
+	}
+	parts = append(parts, esc(c.URL))
+
+	text := ""
+	for _, part := range parts {
+		if text != "" {
+			text += " "
+		}
+		text += part
+		if !strings.HasSuffix(part, ".") && !strings.HasSuffix(part, ".&rdquo;") {
+			text += "."
+		}
+	}
+	return template.HTML(strings.TrimSuffix(text, "."))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestCitationTextInLanguage(t *testing.T) {
+	inContentRoot(t, map[string]string{
+		"i18n/en.yaml": "translated_by: Translated by\nedited_by: Edited by\n",
+		"i18n/hi.yaml": "translated_by: \"अनुवादक:\"\n",
+	})
+	tests := []struct {
+		lang string
+		want []string
+	}{
+		{"", []string{"Translated by T", "Edited by E"}},
+		{"en", []string{"Translated by T", "Edited by E"}},
+		{"hi", []string{"अनुवादक: T", "Edited by E"}},
+	}
+	for _, tt := range tests {
+		book := Book{Slug: "b", Metadata: BookMetadata{Title: "B", Translator: "T", Editor: "E", Language: tt.lang}}
+		text := string(book.Citation().Text())
+		for _, want := range tt.want {
+			if !strings.Contains(text, want) {
+				t.Errorf("%q: citation lacks %q: %s", tt.lang, want, text)
+			}
+		}
+	}
+}
+
+func TestCitationEscaping(t *testing.T) {
+	c := Citation{
+		Key:     "b",
+		Kind:    "book",
+		Title:   `Costs & Gains: 50% of {Pay} in $, #1_a \ b`,
+		Authors: []string{`O'Brien "Jack"`},
+		Pages:   "3-5",
+		URL:     "https://example.com/book/a_b#c%20d",
+	}
+
+	bibtex := c.BibTeX()
+	for _, want := range []string{
+		`title = {Costs \& Gains: 50\% of \{Pay\} in \$, \#1\_a \textbackslash{} b}`,
+		`author = {O'Brien "Jack"}`,
+		`pages = {3--5}`,
+		`url = {https://example.com/book/a_b#c%20d}`,
+	} {
+		if !strings.Contains(bibtex, want) {
+			t.Errorf("BibTeX lacks %q:\n%s", want, bibtex)
+		}
+	}
+
+	multiline := c
+	multiline.Title = "Costs\nand  Gains"
+	ris := multiline.RIS()
+	for _, want := range []string{
+		"TI  - Costs and Gains\r\n",
+		"AU  - O'Brien \"Jack\"\r\n",
+		"SP  - 3\r\nEP  - 5\r\n",
+		"UR  - https://example.com/book/a_b#c%20d\r\n",
+	} {
+		if !strings.Contains(ris, want) {
+			t.Errorf("RIS lacks %q:\n%s", want, ris)
+		}
+	}
+
+	data, err := c.CSLJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var items []cslItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		t.Fatalf("CSL-JSON doesn't parse: %v\n%s", err, data)
+	}
+	if len(items) != 1 || items[0].Title != c.Title || items[0].Author[0].Literal != c.Authors[0] || items[0].URL != c.URL {
+		t.Errorf("CSL-JSON doesn't round-trip:\n%s", data)
+	}
+}
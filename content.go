@@ -0,0 +1,469 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer/html"
+	"gopkg.in/yaml.v3"
+)
+
+// PostMetadata represents the metadata for a blog post
+type PostMetadata struct {
+	Title         string    `yaml:"title"`
+	Date          time.Time `yaml:"date"`
+	Description   string    `yaml:"description"`
+	Tags          []string  `yaml:"tags"`
+	Language      string    `yaml:"language"`
+	TranslationOf string    `yaml:"translation_of"`
+}
+
+// BookMetadata represents the metadata for a book
+type BookMetadata struct {
+	Title         string           `yaml:"title"`
+	Subtitle      string           `yaml:"subtitle"`
+	Author        string           `yaml:"author"`
+	Translator    string           `yaml:"translator"`
+	Editor        string           `yaml:"editor"`
+	Illustrator   string           `yaml:"illustrator"`
+	Year          int              `yaml:"year"`
+	Description   string           `yaml:"description"`
+	EpubFile      string           `yaml:"epub_file"`
+	Language      string           `yaml:"language"`
+	Identifiers   []BookIdentifier `yaml:"identifiers"`
+	Publisher     string           `yaml:"publisher"`
+	Edition       string           `yaml:"edition"`
+	Source        string           `yaml:"source"`
+	Copyright     string           `yaml:"copyright"`
+	License       string           `yaml:"license"`
+	Subjects      []string         `yaml:"subjects"`
+	Cover         string           `yaml:"cover"`
+	TranslationOf string           `yaml:"translation_of"`
+}
+
+// ChapterInfo represents a chapter entry in chapters.yaml. Entries can
+// nest chapters to form parts and sections.
+type ChapterInfo struct {
+	Slug     string        `yaml:"slug"`
+	Title    string        `yaml:"title"`
+	Pages    string        `yaml:"pages"`
+	Type     string        `yaml:"type"` // epub:type role
+	Chapters []ChapterInfo `yaml:"chapters"`
+}
+
+// ChaptersConfig represents the chapters.yaml structure
+type ChaptersConfig struct {
+	Chapters []ChapterInfo `yaml:"chapters"`
+}
+
+// Book represents a complete book
+type Book struct {
+	Metadata     BookMetadata
+	Slug         string
+	Chapters     []ChapterInfo // pages in reading order
+	Contents     []ChapterInfo // the nested chapters.yaml tree
+	Snippet      template.HTML
+	Intro        template.HTML
+	Glossary     []GlossaryTerm
+	Anchors      *AnchorManifest
+	Translations []Translation
+	Typographer  *typographer // normalizes chapters as they are read, if typography.yaml asks to
+}
+
+// ChapterData represents data for rendering a chapter
+type ChapterData struct {
+	Title       string
+	Content     template.HTML
+	BookSlug    string
+	BookTitle   string
+	ChapterSlug string
+	Type        string
+	PrevChapter *ChapterInfo
+	NextChapter *ChapterInfo
+}
+
+// Post represents a complete blog post
+type Post struct {
+	Metadata     PostMetadata
+	Content      template.HTML
+	Slug         string
+	Excerpt      string // plain text summary for listings and previews
+	Translations []Translation
+	References   []string // posts and chapters it links to, e.g. book:slug/chapter
+}
+
+// PageData represents data passed to templates
+type PageData struct {
+	Title        string
+	Content      template.HTML
+	Posts        []Post
+	Post         *Post
+	Books        []Book
+	Book         *Book
+	Chapter      *ChapterData
+	BookAll      *BookAllData
+	Analytics    *AnalyticsData
+	SEO          SEOMetadata
+	Lang         string
+	LangPrefix   string
+	UI           map[string]string
+	Translations []Translation
+	ReferencedBy []Post
+}
+
+var (
+	templates *template.Template
+	md        goldmark.Markdown
+)
+
+func init() {
+	// Initialize goldmark with extensions
+	extensions := []goldmark.Extender{
+		extension.GFM,
+		extension.Table,
+		extension.Strikethrough,
+		extension.Linkify,
+		extension.TaskList,
+		shortcodeExtension{},
+		crossRefExtension{},
+		bundleExtension{},
+	}
+	options := []goldmark.Option{
+		goldmark.WithParserOptions(
+			parser.WithAutoHeadingID(),
+		),
+		goldmark.WithRendererOptions(
+			html.WithHardWraps(),
+			html.WithXHTML(),
+		),
+	}
+	md = goldmark.New(append(options, goldmark.WithExtensions(extensions...))...)
+
+	// Chapters written in Markdown also have footnotes
+	chapterExtensions := append(extensions, extension.Footnote, chapterFootnoteExtension{})
+	chapterMD = goldmark.New(append(options, goldmark.WithExtensions(chapterExtensions...))...)
+}
+
+func loadAllPosts() ([]Post, error) {
+	var posts []Post
+
+	blogsDir := "blogs"
+	entries, err := os.ReadDir(blogsDir)
+	metrics.contentListed("post", err)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		post, err := loadPost(entry.Name())
+		if err != nil {
+			log.Printf("Error loading post %s: %v", entry.Name(), err)
+			metrics.contentFailed("post")
+			report.skip("post", entry.Name(), err)
+			continue
+		}
+
+		posts = append(posts, *post)
+	}
+
+	// Sort posts by date (newest first)
+	sort.Slice(posts, func(i, j int) bool {
+		return posts[i].Metadata.Date.After(posts[j].Metadata.Date)
+	})
+
+	return posts, nil
+}
+
+func loadPost(slug string) (*Post, error) {
+	postDir := filepath.Join("blogs", slug)
+
+	// Read metadata
+	metadataPath := filepath.Join(postDir, "metadata.yaml")
+	metadata, err := readMetadata(metadataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read content
+	source, err := os.ReadFile(filepath.Join(postDir, "index.md"))
+	if err != nil {
+		return nil, err
+	}
+	post := Post{Metadata: *metadata, Slug: slug}
+	content, references, err := convertMarkdown(moreMarkerPattern.ReplaceAll(source, nil), post.Path())
+	if err != nil {
+		return nil, err
+	}
+
+	post.Content = content
+	post.References = references
+
+	post.Excerpt, err = postExcerpt(&post, source)
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+// moreMarkerPattern matches the marker that ends the excerpt of a post
+var moreMarkerPattern = regexp.MustCompile(`<!--\s*more\s*-->`)
+
+// excerptWords is how many words of a post make its excerpt when it has
+// neither a description nor a <!--more--> marker
+const excerptWords = 50
+
+// postExcerpt returns the summary of a post: its description, or else the
+// text before its <!--more--> marker, or else its first words
+func postExcerpt(post *Post, source []byte) (string, error) {
+	if post.Metadata.Description != "" {
+		return post.Metadata.Description, nil
+	}
+
+	content := post.Content
+	loc := moreMarkerPattern.FindIndex(source)
+	if loc != nil {
+		summary, _, err := convertMarkdown(source[:loc[0]], post.Path())
+		if err != nil {
+			return "", err
+		}
+		content = summary
+	}
+
+	// Posts usually open with their title as a heading
+	body := strings.TrimSpace(string(content))
+	if strings.HasPrefix(body, "<h1") {
+		if _, rest, ok := strings.Cut(body, "</h1>"); ok {
+			body = rest
+		}
+	}
+	text := plainText(body)
+	if loc == nil {
+		text = firstWords(text, excerptWords)
+	}
+	return text, nil
+}
+
+func readMetadata(path string) (*PostMetadata, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata PostMetadata
+	err = yaml.Unmarshal(data, &metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return &metadata, nil
+}
+
+func readMarkdownFile(path string) (template.HTML, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	converted, _, err := convertMarkdown(content, "")
+	return converted, err
+}
+
+// convertMarkdown renders Markdown and returns the keys of the cross
+// references in it. Relative links and images are resolved against base,
+// the site path of the post, if given, and shortcodes are labelled in the
+// language of its prefix.
+func convertMarkdown(source []byte, base string) (template.HTML, []string, error) {
+	lang, _ := splitLanguagePrefix(base)
+	if lang == "" {
+		lang = defaultLanguage
+	}
+	ctx := parser.NewContext()
+	ctx.Set(bundleBaseKey, base)
+	ctx.Set(markdownLanguageKey, lang)
+	var buf bytes.Buffer
+	if err := md.Convert(source, &buf, parser.WithContext(ctx)); err != nil {
+		return "", nil, err
+	}
+
+	references, _ := ctx.Get(crossRefsKey).([]string)
+	return template.HTML(buf.String()), references, nil
+}
+
+func loadAllBooks() ([]Book, error) {
+	var books []Book
+
+	booksDir := "books"
+	entries, err := os.ReadDir(booksDir)
+	metrics.contentListed("book", err)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		book, err := loadBook(entry.Name())
+		if err != nil {
+			log.Printf("Error loading book %s: %v", entry.Name(), err)
+			metrics.contentFailed("book")
+			report.skip("book", entry.Name(), err)
+			continue
+		}
+
+		books = append(books, *book)
+	}
+
+	return books, nil
+}
+
+func loadBook(slug string) (*Book, error) {
+	bookDir := filepath.Join("books", slug)
+
+	// Read metadata
+	metadataPath := filepath.Join(bookDir, "metadata.yaml")
+	metadataData, err := os.ReadFile(metadataPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var metadata BookMetadata
+	err = yaml.Unmarshal(metadataData, &metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validateBookMetadata(slug, &metadata); err != nil {
+		return nil, err
+	}
+
+	// Read chapters config
+	chaptersPath := filepath.Join(bookDir, "chapters.yaml")
+	chaptersData, err := os.ReadFile(chaptersPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var chaptersConfig ChaptersConfig
+	err = yaml.Unmarshal(chaptersData, &chaptersConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	chapters, err := flattenChapters(chaptersConfig.Chapters)
+	if err != nil {
+		return nil, fmt.Errorf("chapters.yaml: %w", err)
+	}
+
+	// Read snippet (optional) - short intro for books list
+	var snippet template.HTML
+	snippetPath := filepath.Join(bookDir, "snippet.html")
+	if snippetData, err := os.ReadFile(snippetPath); err == nil {
+		snippet = template.HTML(snippetData)
+	}
+
+	// Read intro (optional) - longer intro for book page
+	var intro template.HTML
+	introPath := filepath.Join(bookDir, "intro.html")
+	if introData, err := os.ReadFile(introPath); err == nil {
+		intro, err = linkCrossRefs(template.HTML(introData))
+		if err != nil {
+			return nil, fmt.Errorf("intro.html: %w", err)
+		}
+	}
+
+	// Read glossary (optional) - terms linked from chapters
+	glossary, err := loadGlossary(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read paragraph anchors (optional) - aliases for edited passages
+	anchors, err := loadAnchorManifest(slug)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read typography (optional) - how punctuation is set as chapters are read
+	typography, err := loadTypographyConfig(slug)
+	if err != nil {
+		return nil, fmt.Errorf("typography.yaml: %w", err)
+	}
+	var typographer *typographer
+	if typography.OnLoad {
+		if typographer, err = newTypographer(slug, typography); err != nil {
+			return nil, fmt.Errorf("typography.yaml: %w", err)
+		}
+	}
+
+	return &Book{
+		Metadata:    metadata,
+		Slug:        slug,
+		Chapters:    chapters,
+		Contents:    chaptersConfig.Chapters,
+		Snippet:     snippet,
+		Intro:       intro,
+		Glossary:    glossary,
+		Anchors:     anchors,
+		Typographer: typographer,
+	}, nil
+}
+
+func loadChapter(book *Book, chapterSlug string) (*ChapterData, error) {
+	// Find chapter index
+	chapterIndex := -1
+	var chapterInfo ChapterInfo
+	for i, ch := range book.Chapters {
+		if ch.Slug == chapterSlug {
+			chapterIndex = i
+			chapterInfo = ch
+			break
+		}
+	}
+
+	if chapterIndex == -1 {
+		return nil, os.ErrNotExist
+	}
+
+	// Read chapter content
+	content, err := readChapter(book, chapterSlug)
+	if err != nil {
+		return nil, err
+	}
+
+	// Determine prev/next chapters
+	var prevChapter, nextChapter *ChapterInfo
+	if chapterIndex > 0 {
+		prevChapter = &book.Chapters[chapterIndex-1]
+	}
+	if chapterIndex < len(book.Chapters)-1 {
+		nextChapter = &book.Chapters[chapterIndex+1]
+	}
+
+	return &ChapterData{
+		Title:       chapterInfo.Title,
+		Content:     addParagraphAnchors(template.HTML(content), book.chapterAnchors(chapterSlug)),
+		BookSlug:    book.Slug,
+		BookTitle:   book.Metadata.Title,
+		ChapterSlug: chapterSlug,
+		Type:        chapterInfo.Type,
+		PrevChapter: prevChapter,
+		NextChapter: nextChapter,
+	}, nil
+}
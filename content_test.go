@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPostExcerpt(t *testing.T) {
+	long := strings.Repeat("word ", excerptWords+10)
+	tests := []struct {
+		name        string
+		description string
+		markdown    string
+		want        string
+	}{
+		{"description", "A summary.", "# Title\n\nBody text.\n", "A summary."},
+		{"description wins over marker", "A summary.", "Intro.\n\n<!--more-->\n\nRest.\n", "A summary."},
+		{"marker", "", "# Title\n\nIntro with *emphasis*.\n\n<!--more-->\n\nRest of the post.\n", "Intro with emphasis."},
+		{"marker with spaces", "", "Intro.\n<!-- more -->\nRest.\n", "Intro."},
+		{"marker keeps long intro", "", long + "\n\n<!--more-->\n", strings.TrimSpace(long)},
+		{"first words", "", "# Title\n\n" + long + "\n", strings.TrimSpace(strings.Repeat("word ", excerptWords)) + "…"},
+		{"short post", "", "# Title\n\nJust this.\n", "Just this."},
+		{"no heading", "", "Starts right away.\n", "Starts right away."},
+		{"only a heading", "", "# Title\n", ""},
+		{"later headings stay", "", "Intro.\n\n# Part\n\nMore.\n", "Intro. Part More."},
+		{"markup is dropped", "", "A [link](https://e.org) and `code`.\n", "A link and code."},
+		{"cut at punctuation", "", strings.Repeat("word ", excerptWords-1) + "end, and more\n", strings.TrimSpace(strings.Repeat("word ", excerptWords-1)) + " end…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source := []byte(tt.markdown)
+			post := Post{Metadata: PostMetadata{Title: "Title", Description: tt.description}, Slug: "p"}
+			content, _, err := convertMarkdown(moreMarkerPattern.ReplaceAll(source, nil), post.Path())
+			if err != nil {
+				t.Fatal(err)
+			}
+			post.Content = content
+
+			got, err := postExcerpt(&post, source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("excerpt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadPostRemovesMoreMarker(t *testing.T) {
+	inContentRoot(t, map[string]string{
+		"blogs/p/metadata.yaml": "title: P\ndate: 2025-01-01T00:00:00Z\n",
+		"blogs/p/index.md":      "Intro.\n\n<!--more-->\n\nRest.\n",
+	})
+	post, err := loadPost("p")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if post.Excerpt != "Intro." {
+		t.Errorf("excerpt = %q", post.Excerpt)
+	}
+	if strings.Contains(string(post.Content), "more") || strings.Contains(string(post.Content), "omitted") {
+		t.Errorf("content shows the marker: %s", post.Content)
+	}
+}
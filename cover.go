@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"html"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	coverWidth  = 600
+	coverHeight = 900
+	cardWidth   = 1200
+	cardHeight  = 630
+)
+
+// coverFileNames are the hand-made cover files looked for in a book
+// directory when metadata.yaml doesn't name one
+var coverFileNames = []string{"cover.svg", "cover.png", "cover.jpg", "cover.jpeg", "cover.webp"}
+
+// coverPalette are the background colors of generated images, picked by slug
+// so that a book or post keeps its color from build to build
+var coverPalette = []color.RGBA{
+	{0x1f, 0x3a, 0x4d, 0xff},
+	{0x4a, 0x23, 0x2b, 0xff},
+	{0x23, 0x40, 0x2f, 0xff},
+	{0x3b, 0x2f, 0x4f, 0xff},
+	{0x4d, 0x38, 0x1f, 0xff},
+	{0x2b, 0x2b, 0x2b, 0xff},
+}
+
+var (
+	coverInk    = color.RGBA{0xf4, 0xef, 0xe6, 0xff}
+	coverAccent = color.RGBA{0xd9, 0xa4, 0x41, 0xff}
+)
+
+// generatedImage is a cover or social card rendered from metadata
+type generatedImage struct {
+	FileName    string
+	ContentType string
+	Render      func() ([]byte, error)
+}
+
+// imageLine is a line of text on a generated image. Scale is the pixel size
+// of the bitmap font in the PNG; the SVG uses a font of a matching size.
+type imageLine struct {
+	Text   string
+	X, Y   int // top left corner, or top center when Center is set
+	Scale  int
+	Color  color.RGBA
+	Bold   bool
+	Italic bool
+	Center bool
+}
+
+// imageLayout is the design of a generated image, shared by its SVG and PNG
+type imageLayout struct {
+	Width, Height int
+	Background    color.RGBA
+	Rects         []image.Rectangle
+	Frame         image.Rectangle
+	Lines         []imageLine
+}
+
+// findCoverFile returns the name of a hand-made cover in a book directory
+func findCoverFile(bookDir string) string {
+	for _, name := range coverFileNames {
+		if info, err := os.Stat(filepath.Join(bookDir, name)); err == nil && info.Mode().IsRegular() {
+			return name
+		}
+	}
+	return ""
+}
+
+// CoverURL returns the site path of the book's cover: the hand-made one if
+// there is one, otherwise the generated SVG
+func (b Book) CoverURL() string {
+	if b.Metadata.Cover != "" {
+		return b.Path() + "/" + b.Metadata.Cover
+	}
+	return b.Path() + "/cover.svg"
+}
+
+// CoverImageURL returns the site path of a raster version of the book's
+// cover, for link previews and catalogs that don't show SVG, or "" if there
+// is none because the bitmap font can't draw the cover's text
+func (b Book) CoverImageURL() string {
+	if b.Metadata.Cover != "" && !strings.EqualFold(filepath.Ext(b.Metadata.Cover), ".svg") {
+		return b.Path() + "/" + b.Metadata.Cover
+	}
+	if !bookCoverLayout(&b).bitmapDrawable() {
+		return ""
+	}
+	return b.Path() + "/cover.png"
+}
+
+// SocialImageURL returns the site path of the post's social preview card,
+// or "" if the bitmap font can't draw its text
+func (p Post) SocialImageURL() string {
+	if !postCardLayout(&p).bitmapDrawable() {
+		return ""
+	}
+	return p.Path() + "/social.png"
+}
+
+// bookCoverImages returns the generated covers of a book. A hand-made cover
+// replaces the generated file of the same format; a hand-made SVG still gets
+// a generated PNG for link previews, if the bitmap font can draw it.
+func bookCoverImages(book *Book) []generatedImage {
+	layout := bookCoverLayout(book)
+	var images []generatedImage
+	if book.Metadata.Cover == "" {
+		images = append(images, generatedImage{FileName: "cover.svg", ContentType: "image/svg+xml", Render: layout.SVG})
+	}
+	if url := book.CoverImageURL(); url != "" && url != book.CoverURL() {
+		images = append(images, generatedImage{FileName: "cover.png", ContentType: "image/png", Render: layout.PNG})
+	}
+	return images
+}
+
+// postCardImages returns the generated social preview cards of a post
+func postCardImages(post *Post) []generatedImage {
+	layout := postCardLayout(post)
+	images := []generatedImage{
+		{FileName: "social.svg", ContentType: "image/svg+xml", Render: layout.SVG},
+	}
+	if post.SocialImageURL() != "" {
+		images = append(images, generatedImage{FileName: "social.png", ContentType: "image/png", Render: layout.PNG})
+	}
+	return images
+}
+
+// findGeneratedImage returns the generated image served under name
+func findGeneratedImage(images []generatedImage, name string) (generatedImage, bool) {
+	for _, img := range images {
+		if img.FileName == name {
+			return img, true
+		}
+	}
+	return generatedImage{}, false
+}
+
+func paletteColor(key string) color.RGBA {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return coverPalette[h.Sum32()%uint32(len(coverPalette))]
+}
+
+// glyphAdvance is the width of a character of the bitmap font, including
+// the column of spacing after it
+func glyphAdvance(scale int) int {
+	return 6 * scale
+}
+
+// wrapLines breaks text into lines of at most width characters. Lines past
+// maxLines are dropped and the last kept line is marked with an ellipsis.
+func wrapLines(text string, width, maxLines int) []string {
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		if line != "" && len([]rune(line))+1+len([]rune(word)) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	if len(lines) > maxLines {
+		lines = lines[:maxLines]
+		last := []rune(lines[maxLines-1])
+		if len(last) > width-3 {
+			last = last[:width-3]
+		}
+		lines[maxLines-1] = strings.TrimRight(string(last), " ,;:.") + "..."
+	}
+	return lines
+}
+
+// fitLines wraps text at the largest of scales that fits in maxLines lines
+// across width pixels, falling back to the smallest scale
+func fitLines(text string, width, maxLines int, scales ...int) ([]string, int) {
+	scale := scales[len(scales)-1]
+	for _, s := range scales {
+		if linesFit(wrapLines(text, width/glyphAdvance(s), len(text)+1), width/glyphAdvance(s), maxLines) {
+			scale = s
+			break
+		}
+	}
+	return wrapLines(text, width/glyphAdvance(scale), maxLines), scale
+}
+
+// linesFit reports whether there are at most maxLines lines, none of them
+// longer than width characters
+func linesFit(lines []string, width, maxLines int) bool {
+	if len(lines) > maxLines {
+		return false
+	}
+	for _, line := range lines {
+		if len([]rune(line)) > width {
+			return false
+		}
+	}
+	return true
+}
+
+// bookCoverLayout designs a typographic cover from a book's metadata
+func bookCoverLayout(book *Book) imageLayout {
+	m := book.Metadata
+	layout := imageLayout{
+		Width:      coverWidth,
+		Height:     coverHeight,
+		Background: paletteColor(book.Slug),
+		Frame:      image.Rect(24, 24, coverWidth-24, coverHeight-24),
+	}
+	center := coverWidth / 2
+	textWidth := coverWidth - 100
+
+	y := 160
+	lines, scale := fitLines(m.Title, textWidth, 5, 6, 5, 4)
+	for _, line := range lines {
+		layout.Lines = append(layout.Lines, imageLine{Text: line, X: center, Y: y, Scale: scale, Color: coverInk, Bold: true, Center: true})
+		y += 10 * scale
+	}
+
+	y += 10
+	layout.Rects = append(layout.Rects, image.Rect(center-60, y, center+60, y+4))
+	y += 40
+
+	if m.Subtitle != "" {
+		for _, line := range wrapLines(m.Subtitle, textWidth/glyphAdvance(3), 4) {
+			layout.Lines = append(layout.Lines, imageLine{Text: line, X: center, Y: y, Scale: 3, Color: coverInk, Italic: true, Center: true})
+			y += 30
+		}
+	}
+
+	// Credits are set from the bottom of the cover upwards
+	y = coverHeight - 90
+	if m.Year != 0 {
+		layout.Lines = append(layout.Lines, imageLine{Text: fmt.Sprint(m.Year), X: center, Y: y, Scale: 3, Color: coverAccent, Center: true})
+		y -= 60
+	}
+	var credits []string
+	if m.Translator != "" {
+		credits = append(credits, uiString(book.Lang(), "translated_by")+" "+m.Translator)
+	}
+	if m.Editor != "" {
+		credits = append(credits, uiString(book.Lang(), "edited_by")+" "+m.Editor)
+	}
+	for i := len(credits) - 1; i >= 0; i-- {
+		layout.Lines = append(layout.Lines, imageLine{Text: wrapLines(credits[i], textWidth/glyphAdvance(2), 1)[0], X: center, Y: y, Scale: 2, Color: coverInk, Italic: true, Center: true})
+		y -= 30
+	}
+	if strings.TrimSpace(m.Author) != "" {
+		y -= 10
+		author, scale := fitLines(m.Author, textWidth, 1, 4, 3)
+		layout.Lines = append(layout.Lines, imageLine{Text: author[0], X: center, Y: y, Scale: scale, Color: coverInk, Center: true})
+	}
+
+	return layout
+}
+
+// postCardLayout designs a social preview card from a post's metadata
+func postCardLayout(post *Post) imageLayout {
+	m := post.Metadata
+	layout := imageLayout{
+		Width:      cardWidth,
+		Height:     cardHeight,
+		Background: paletteColor(post.Slug),
+		Rects:      []image.Rectangle{image.Rect(0, 0, 16, cardHeight)},
+	}
+	left := 80
+	textWidth := cardWidth - 2*left
+
+	layout.Lines = append(layout.Lines, imageLine{Text: siteName, X: left, Y: 70, Scale: 3, Color: coverAccent})
+
+	y := 150
+	lines, scale := fitLines(m.Title, textWidth, 3, 8, 6)
+	for _, line := range lines {
+		layout.Lines = append(layout.Lines, imageLine{Text: line, X: left, Y: y, Scale: scale, Color: coverInk, Bold: true})
+		y += 10 * scale
+	}
+
+	y = cardHeight - 130
+	if !m.Date.IsZero() {
+		layout.Lines = append(layout.Lines, imageLine{Text: m.Date.Format("January 2, 2006"), X: left, Y: y, Scale: 3, Color: coverInk})
+	}
+	if len(m.Tags) > 0 {
+		tags := "#" + strings.Join(m.Tags, "  #")
+		for _, line := range wrapLines(tags, textWidth/glyphAdvance(3), 1) {
+			layout.Lines = append(layout.Lines, imageLine{Text: line, X: left, Y: y + 45, Scale: 3, Color: coverAccent})
+		}
+	}
+
+	return layout
+}
+
+func svgColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// SVG renders the layout as an SVG image set in the reader's serif font
+func (l imageLayout) SVG() ([]byte, error) {
+	var out bytes.Buffer
+	fmt.Fprintf(&out, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n", l.Width, l.Height, l.Width, l.Height)
+	fmt.Fprintf(&out, `  <rect width="%d" height="%d" fill="%s"/>`+"\n", l.Width, l.Height, svgColor(l.Background))
+	if !l.Frame.Empty() {
+		fmt.Fprintf(&out, `  <rect x="%d" y="%d" width="%d" height="%d" fill="none" stroke="%s" stroke-width="3"/>`+"\n",
+			l.Frame.Min.X, l.Frame.Min.Y, l.Frame.Dx(), l.Frame.Dy(), svgColor(coverAccent))
+	}
+	for _, r := range l.Rects {
+		fmt.Fprintf(&out, `  <rect x="%d" y="%d" width="%d" height="%d" fill="%s"/>`+"\n", r.Min.X, r.Min.Y, r.Dx(), r.Dy(), svgColor(coverAccent))
+	}
+	for _, line := range l.Lines {
+		attrs := fmt.Sprintf(`x="%d" y="%d" font-size="%d" fill="%s"`, line.X, line.Y+7*line.Scale, 10*line.Scale, svgColor(line.Color))
+		if line.Center {
+			attrs += ` text-anchor="middle"`
+		}
+		if line.Bold {
+			attrs += ` font-weight="bold"`
+		}
+		if line.Italic {
+			attrs += ` font-style="italic"`
+		}
+		fmt.Fprintf(&out, `  <text %s font-family="Georgia, 'Times New Roman', serif">%s</text>`+"\n", attrs, html.EscapeString(line.Text))
+	}
+	out.WriteString("</svg>\n")
+	return out.Bytes(), nil
+}
+
+// PNG renders the layout as a PNG image set in the built-in bitmap font
+func (l imageLayout) PNG() ([]byte, error) {
+	img := image.NewRGBA(image.Rect(0, 0, l.Width, l.Height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(l.Background), image.Point{}, draw.Src)
+
+	accent := image.NewUniform(coverAccent)
+	if !l.Frame.Empty() {
+		f := l.Frame
+		for _, edge := range []image.Rectangle{
+			image.Rect(f.Min.X, f.Min.Y, f.Max.X, f.Min.Y+3),
+			image.Rect(f.Min.X, f.Max.Y-3, f.Max.X, f.Max.Y),
+			image.Rect(f.Min.X, f.Min.Y, f.Min.X+3, f.Max.Y),
+			image.Rect(f.Max.X-3, f.Min.Y, f.Max.X, f.Max.Y),
+		} {
+			draw.Draw(img, edge, accent, image.Point{}, draw.Src)
+		}
+	}
+	for _, r := range l.Rects {
+		draw.Draw(img, r, accent, image.Point{}, draw.Src)
+	}
+	for _, line := range l.Lines {
+		drawBitmapText(img, line)
+	}
+
+	var out bytes.Buffer
+	if err := png.Encode(&out, img); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// bitmapReplacer maps typographic punctuation onto the ASCII of the bitmap font
+var bitmapReplacer = strings.NewReplacer(
+	"‘", "'", "’", "'", "“", `"`, "”", `"`,
+	"–", "-", "—", "-", "…", "...", "·", "-",
+)
+
+// bitmapDrawable reports whether the bitmap font has every character of the
+// layout's text, so that its PNG doesn't come out as question marks
+func (l imageLayout) bitmapDrawable() bool {
+	for _, line := range l.Lines {
+		for _, r := range bitmapReplacer.Replace(line.Text) {
+			if _, ok := font5x7[r]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// drawBitmapText draws a line of text with the bitmap font. Characters the
+// font doesn't have are drawn as question marks.
+func drawBitmapText(img *image.RGBA, line imageLine) {
+	text := []rune(bitmapReplacer.Replace(line.Text))
+	s := line.Scale
+	x := line.X
+	if line.Center {
+		x -= (len(text)*glyphAdvance(s) - s) / 2
+	}
+	ink := image.NewUniform(line.Color)
+
+	for _, r := range text {
+		glyph, ok := font5x7[r]
+		if !ok {
+			glyph = font5x7['?']
+		}
+		for row, bits := range glyph {
+			for col, bit := range bits {
+				if bit != '#' {
+					continue
+				}
+				px := image.Rect(x+col*s, line.Y+row*s, x+(col+1)*s, line.Y+(row+1)*s)
+				draw.Draw(img, px, ink, image.Point{}, draw.Src)
+				// Bold text is set by doubling each pixel column
+				if line.Bold {
+					draw.Draw(img, px.Add(image.Pt(s/3+1, 0)), ink, image.Point{}, draw.Src)
+				}
+			}
+		}
+		x += glyphAdvance(s)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBookCoverURLs(t *testing.T) {
+	tests := []struct {
+		name      string
+		metadata  BookMetadata
+		wantCover string
+		wantImage string
+	}{
+		{"default language", BookMetadata{}, "/book/b/cover.svg", "/book/b/cover.png"},
+		{"english", BookMetadata{Language: "en"}, "/book/b/cover.svg", "/book/b/cover.png"},
+		{"other language", BookMetadata{Language: "hi"}, "/hi/book/b/cover.svg", "/hi/book/b/cover.png"},
+		{"hand-made svg", BookMetadata{Language: "hi", Cover: "front.svg"}, "/hi/book/b/front.svg", "/hi/book/b/cover.png"},
+		{"hand-made jpeg", BookMetadata{Language: "hi", Cover: "front.jpg"}, "/hi/book/b/front.jpg", "/hi/book/b/front.jpg"},
+		{"title the bitmap font can't draw", BookMetadata{Language: "hi", Title: "सिपाही से सूबेदार"}, "/hi/book/b/cover.svg", ""},
+		{"credit label the bitmap font can't draw", BookMetadata{Language: "hi", Title: "B", Translator: "T"}, "/hi/book/b/cover.svg", ""},
+		{"hand-made jpeg of such a title", BookMetadata{Language: "hi", Title: "सिपाही", Cover: "front.jpg"}, "/hi/book/b/front.jpg", "/hi/book/b/front.jpg"},
+		{"typographic punctuation", BookMetadata{Title: "“Sepoy” — a memoir…", Translator: "T"}, "/book/b/cover.svg", "/book/b/cover.png"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			book := Book{Metadata: tt.metadata, Slug: "b"}
+			if got := book.CoverURL(); got != tt.wantCover {
+				t.Errorf("CoverURL() = %q, want %q", got, tt.wantCover)
+			}
+			if got := book.CoverImageURL(); got != tt.wantImage {
+				t.Errorf("CoverImageURL() = %q, want %q", got, tt.wantImage)
+			}
+		})
+	}
+}
+
+func TestGeneratedImageFiles(t *testing.T) {
+	fileNames := func(images []generatedImage) []string {
+		var names []string
+		for _, img := range images {
+			names = append(names, img.FileName)
+		}
+		return names
+	}
+
+	books := []struct {
+		name     string
+		metadata BookMetadata
+		want     string
+	}{
+		{"generated", BookMetadata{Title: "Sepoy"}, "[cover.svg cover.png]"},
+		{"not drawable", BookMetadata{Title: "सिपाही", Language: "hi"}, "[cover.svg]"},
+		{"hand-made svg", BookMetadata{Title: "Sepoy", Cover: "front.svg"}, "[cover.png]"},
+		{"hand-made svg, not drawable", BookMetadata{Title: "सिपाही", Cover: "front.svg"}, "[]"},
+		{"hand-made png", BookMetadata{Title: "Sepoy", Cover: "front.png"}, "[]"},
+	}
+	for _, tt := range books {
+		book := &Book{Slug: "b", Metadata: tt.metadata}
+		if got := fmt.Sprint(fileNames(bookCoverImages(book))); got != tt.want {
+			t.Errorf("book %s: files = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	date := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	posts := []struct {
+		name      string
+		metadata  PostMetadata
+		want      string
+		wantImage string
+	}{
+		{"drawable", PostMetadata{Title: "Hello", Date: date}, "[social.svg social.png]", "/post/p/social.png"},
+		{"not drawable", PostMetadata{Title: "नमस्ते", Date: date}, "[social.svg]", ""},
+		{"tag not drawable", PostMetadata{Title: "Hello", Date: date, Tags: []string{"इतिहास"}}, "[social.svg]", ""},
+	}
+	for _, tt := range posts {
+		post := &Post{Slug: "p", Metadata: tt.metadata}
+		if got := fmt.Sprint(fileNames(postCardImages(post))); got != tt.want {
+			t.Errorf("post %s: files = %s, want %s", tt.name, got, tt.want)
+		}
+		if got := post.SocialImageURL(); got != tt.wantImage {
+			t.Errorf("post %s: SocialImageURL() = %q, want %q", tt.name, got, tt.wantImage)
+		}
+	}
+}
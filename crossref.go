@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+	"gopkg.in/yaml.v3"
+)
+
+// errDeadReference is returned for a cross reference to a post, book or
+// chapter that doesn't exist. A build fails on it rather than leaving the
+// referring content out.
+var errDeadReference = errors.New("dead reference")
+
+// crossRefPattern matches cross references such as
+// [[book:from-sepoy-to-subedar/the_pindari_war]], [[post:slug#heading]] or
+// [[post:slug|link text]]
+var crossRefPattern = regexp.MustCompile(`\[\[(post|book):([^\]|#\s]+)(#[^\]|\s]*)?(?:\|([^\]]+))?\]\]`)
+
+// crossRef is a resolved cross reference
+type crossRef struct {
+	Key   string // the post or chapter referred to, e.g. book:slug/chapter
+	URL   string
+	Title string
+}
+
+// resolveCrossRef finds the post, book or chapter a reference names. Only
+// metadata is read, so content can refer to content that refers back.
+func resolveCrossRef(kind, target, fragment string) (*crossRef, error) {
+	switch kind {
+	case "post":
+		metadata, err := readMetadata(filepath.Join("blogs", target, "metadata.yaml"))
+		if err != nil {
+			return nil, fmt.Errorf("%w: no post %s", errDeadReference, target)
+		}
+		post := Post{Metadata: *metadata, Slug: target}
+		return &crossRef{Key: "post:" + target, URL: post.Path() + fragment, Title: metadata.Title}, nil
+
+	case "book":
+		bookSlug, chapterSlug, _ := strings.Cut(target, "/")
+		book, err := readBookIndex(bookSlug)
+		if err != nil {
+			return nil, fmt.Errorf("%w: no book %s", errDeadReference, bookSlug)
+		}
+		if chapterSlug == "" {
+			return &crossRef{Key: "book:" + bookSlug, URL: book.Path() + "/" + fragment, Title: book.Metadata.Title}, nil
+		}
+		for _, ch := range book.Chapters {
+			if ch.Slug == chapterSlug {
+				return &crossRef{Key: "book:" + target, URL: book.Path() + "/" + chapterSlug + fragment, Title: ch.Title}, nil
+			}
+		}
+		return nil, fmt.Errorf("%w: book %s has no chapter %s", errDeadReference, bookSlug, chapterSlug)
+	}
+	return nil, fmt.Errorf("%w: unknown kind %s", errDeadReference, kind)
+}
+
+// readBookIndex reads only a book's metadata and chapter list
+func readBookIndex(slug string) (*Book, error) {
+	data, err := os.ReadFile(filepath.Join("books", slug, "metadata.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var metadata BookMetadata
+	if err := yaml.Unmarshal(data, &metadata); err != nil {
+		return nil, err
+	}
+
+	data, err = os.ReadFile(filepath.Join("books", slug, "chapters.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var chaptersConfig ChaptersConfig
+	if err := yaml.Unmarshal(data, &chaptersConfig); err != nil {
+		return nil, err
+	}
+	chapters, err := flattenChapters(chaptersConfig.Chapters)
+	if err != nil {
+		return nil, err
+	}
+	return &Book{Metadata: metadata, Slug: slug, Chapters: chapters}, nil
+}
+
+// linkCrossRefs replaces the cross references in HTML, such as a book's
+// intro, with links
+func linkCrossRefs(content template.HTML) (template.HTML, error) {
+	var firstErr error
+	out := crossRefPattern.ReplaceAllStringFunc(string(content), func(s string) string {
+		m := crossRefPattern.FindStringSubmatch(s)
+		ref, err := resolveCrossRef(m[1], m[2], m[3])
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			return s
+		}
+		label := template.HTMLEscapeString(ref.Title)
+		if m[4] != "" {
+			label = m[4]
+		}
+		return `<a href="` + template.HTMLEscapeString(ref.URL) + `" class="crossref">` + label + `</a>`
+	})
+	return template.HTML(out), firstErr
+}
+
+// referencedBy indexes posts by the posts and chapters they refer to,
+// keyed like crossRef.Key
+func referencedBy(posts []Post) map[string][]Post {
+	index := map[string][]Post{}
+	for _, post := range posts {
+		seen := map[string]bool{"post:" + post.Slug: true}
+		for _, key := range post.References {
+			if !seen[key] {
+				seen[key] = true
+				index[key] = append(index[key], post)
+			}
+		}
+	}
+	for key := range index {
+		sort.SliceStable(index[key], func(i, j int) bool {
+			return index[key][i].Metadata.Date.After(index[key][j].Metadata.Date)
+		})
+	}
+	return index
+}
+
+// crossRefsKey stores the keys of the references found while converting a
+// Markdown document
+var crossRefsKey = parser.NewContextKey()
+
+// kindCrossRef is the kind of cross reference nodes in the Markdown AST
+var kindCrossRef = ast.NewNodeKind("CrossRef")
+
+// crossRefNode is a cross reference, resolved while parsing
+type crossRefNode struct {
+	ast.BaseInline
+	ref   *crossRef
+	label string // given after |, if any
+	err   error  // reported when rendering
+}
+
+func (n *crossRefNode) Kind() ast.NodeKind {
+	return kindCrossRef
+}
+
+func (n *crossRefNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, nil, nil)
+}
+
+// crossRefParser parses [[kind:target]] references
+type crossRefParser struct{}
+
+func (p *crossRefParser) Trigger() []byte {
+	return []byte{'['}
+}
+
+func (p *crossRefParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	loc := crossRefPattern.FindSubmatchIndex(line)
+	if loc == nil || loc[0] != 0 {
+		return nil
+	}
+	block.Advance(loc[1])
+
+	group := func(i int) string {
+		if loc[2*i] < 0 {
+			return ""
+		}
+		return string(line[loc[2*i]:loc[2*i+1]])
+	}
+	node := &crossRefNode{label: group(4)}
+	node.ref, node.err = resolveCrossRef(group(1), group(2), group(3))
+	if node.err == nil {
+		refs, _ := pc.Get(crossRefsKey).([]string)
+		pc.Set(crossRefsKey, append(refs, node.ref.Key))
+	}
+	return node
+}
+
+// crossRefRenderer renders cross references as links
+type crossRefRenderer struct{}
+
+func (r *crossRefRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindCrossRef, r.render)
+}
+
+func (r *crossRefRenderer) render(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*crossRefNode)
+	if n.err != nil {
+		return ast.WalkStop, n.err
+	}
+	label := n.label
+	if label == "" {
+		label = n.ref.Title
+	}
+	fmt.Fprintf(w, `<a href="%s" class="crossref">%s</a>`, template.HTMLEscapeString(n.ref.URL), template.HTMLEscapeString(label))
+	return ast.WalkContinue, nil
+}
+
+// crossRefExtension adds cross references to a goldmark Markdown
+type crossRefExtension struct{}
+
+func (e crossRefExtension) Extend(m goldmark.Markdown) {
+	// Ahead of the link parser, which also starts at [
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(&crossRefParser{}, 150),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&crossRefRenderer{}, 500),
+	))
+}
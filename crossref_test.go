@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCrossRefPattern(t *testing.T) {
+	tests := []struct {
+		in                           string
+		kind, target, fragment, text string
+	}{
+		{"[[post:hello]]", "post", "hello", "", ""},
+		{"[[post:hello#intro]]", "post", "hello", "#intro", ""},
+		{"[[post:hello|my post]]", "post", "hello", "", "my post"},
+		{"[[book:sepoy/the_pindari_war#p-1a2b|the war]]", "book", "sepoy/the_pindari_war", "#p-1a2b", "the war"},
+		{"[[book:sepoy]]", "book", "sepoy", "", ""},
+	}
+	for _, tt := range tests {
+		m := crossRefPattern.FindStringSubmatch(tt.in)
+		if m == nil {
+			t.Errorf("%q doesn't match", tt.in)
+			continue
+		}
+		if m[1] != tt.kind || m[2] != tt.target || m[3] != tt.fragment || m[4] != tt.text {
+			t.Errorf("%q = %q, want %q %q %q %q", tt.in, m[1:], tt.kind, tt.target, tt.fragment, tt.text)
+		}
+	}
+
+	for _, in := range []string{"[[page:x]]", "[[post:]]", "[[post:a b]]", "[post:x]", "[[post:x"} {
+		if crossRefPattern.MatchString(in) {
+			t.Errorf("%q matches", in)
+		}
+	}
+}
+
+// crossRefSite is a content root with a post in each language and a book
+// with a nested chapter
+var crossRefSite = map[string]string{
+	"blogs/hello/metadata.yaml":   "title: Hello\ndate: 2025-01-01T00:00:00Z\n",
+	"blogs/namaste/metadata.yaml": "title: Namaste\ndate: 2025-01-02T00:00:00Z\nlanguage: hi\n",
+	"books/sepoy/metadata.yaml":   "title: From Sepoy to Subedar\n",
+	"books/sepoy/chapters.yaml":   "chapters:\n  - title: Part One\n    chapters:\n      - slug: the_war\n        title: The War\n",
+	"books/broken/metadata.yaml":  "title: Broken\n",
+	"books/hindi/metadata.yaml":   "title: Hindi Book\nlanguage: hi\n",
+	"books/hindi/chapters.yaml":   "chapters:\n  - slug: one\n    title: One\n",
+}
+
+func TestResolveCrossRef(t *testing.T) {
+	inContentRoot(t, crossRefSite)
+
+	tests := []struct {
+		kind, target, fragment string
+		want                   crossRef
+	}{
+		{"post", "hello", "", crossRef{"post:hello", "/post/hello", "Hello"}},
+		{"post", "hello", "#intro", crossRef{"post:hello", "/post/hello#intro", "Hello"}},
+		{"post", "namaste", "", crossRef{"post:namaste", "/hi/post/namaste", "Namaste"}},
+		{"book", "sepoy", "", crossRef{"book:sepoy", "/book/sepoy/", "From Sepoy to Subedar"}},
+		{"book", "sepoy/the_war", "#p-1", crossRef{"book:sepoy/the_war", "/book/sepoy/the_war#p-1", "The War"}},
+		{"book", "hindi/one", "", crossRef{"book:hindi/one", "/hi/book/hindi/one", "One"}},
+	}
+	for _, tt := range tests {
+		got, err := resolveCrossRef(tt.kind, tt.target, tt.fragment)
+		if err != nil {
+			t.Errorf("%s:%s: %v", tt.kind, tt.target, err)
+			continue
+		}
+		if *got != tt.want {
+			t.Errorf("%s:%s = %+v, want %+v", tt.kind, tt.target, *got, tt.want)
+		}
+	}
+
+	dead := []struct{ kind, target string }{
+		{"post", "nope"},
+		{"book", "nope"},
+		{"book", "sepoy/nope"},
+		{"book", "broken"}, // no chapters.yaml
+		{"page", "hello"},
+	}
+	for _, tt := range dead {
+		if _, err := resolveCrossRef(tt.kind, tt.target, ""); !errors.Is(err, errDeadReference) {
+			t.Errorf("%s:%s: error = %v, want a dead reference", tt.kind, tt.target, err)
+		}
+	}
+}
+
+func TestCrossRefsInMarkdown(t *testing.T) {
+	inContentRoot(t, crossRefSite)
+
+	out, refs, err := convertMarkdown([]byte("See [[post:hello]], [[book:sepoy/the_war|the war]] and [[post:hello#x]].\n\n`[[post:nope]]`\n"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<a href="/post/hello" class="crossref">Hello</a>`,
+		`<a href="/book/sepoy/the_war" class="crossref">the war</a>`,
+		`<a href="/post/hello#x" class="crossref">Hello</a>`,
+		`<code>[[post:nope]]</code>`,
+	} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("output lacks %s:\n%s", want, out)
+		}
+	}
+	if want := []string{"post:hello", "book:sepoy/the_war", "post:hello"}; !slices.Equal(refs, want) {
+		t.Errorf("references = %q, want %q", refs, want)
+	}
+
+	if _, _, err := convertMarkdown([]byte("See [[post:nope]].\n"), ""); !errors.Is(err, errDeadReference) {
+		t.Errorf("dead reference: error = %v", err)
+	}
+}
+
+func TestLinkCrossRefs(t *testing.T) {
+	inContentRoot(t, crossRefSite)
+
+	got, err := linkCrossRefs(`<p>Read [[post:hello|<em>this</em>]] first.</p>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `<p>Read <a href="/post/hello" class="crossref"><em>this</em></a> first.</p>`; string(got) != want {
+		t.Errorf("got %s, want %s", got, want)
+	}
+	if _, err := linkCrossRefs(`[[book:sepoy/nope]]`); !errors.Is(err, errDeadReference) {
+		t.Errorf("dead reference: error = %v", err)
+	}
+}
+
+func TestReferencedBy(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2025, 1, d, 0, 0, 0, 0, time.UTC) }
+	posts := []Post{
+		{Slug: "old", Metadata: PostMetadata{Date: day(1)}, References: []string{"book:b/ch", "post:new"}},
+		{Slug: "new", Metadata: PostMetadata{Date: day(3)}, References: []string{"book:b/ch", "book:b/ch", "post:new"}},
+		{Slug: "mid", Metadata: PostMetadata{Date: day(2)}, References: []string{"book:b/ch"}},
+	}
+	index := referencedBy(posts)
+
+	slugs := func(posts []Post) []string {
+		var s []string
+		for _, p := range posts {
+			s = append(s, p.Slug)
+		}
+		return s
+	}
+	if got, want := slugs(index["book:b/ch"]), []string{"new", "mid", "old"}; !slices.Equal(got, want) {
+		t.Errorf("referring to the chapter = %q, want %q", got, want)
+	}
+	// A post doesn't list itself
+	if got, want := slugs(index["post:new"]), []string{"old"}; !slices.Equal(got, want) {
+		t.Errorf("referring to post new = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// bookExport describes a downloadable edition generated from chapter XHTML
+type bookExport struct {
+	FileName    string
+	ContentType string
+	Build       func(book *Book) ([]byte, error)
+}
+
+// bookExports returns the generated editions offered for a book
+func bookExports(book *Book) []bookExport {
+	return []bookExport{
+		{FileName: book.TextFile(), ContentType: "text/plain; charset=utf-8", Build: buildTextEdition},
+		{FileName: book.MarkdownFile(), ContentType: "text/markdown; charset=utf-8", Build: buildMarkdownEdition},
+		{FileName: book.HTMLZipFile(), ContentType: "application/zip", Build: buildHTMLZipEdition},
+	}
+}
+
+// TextFile returns the file name of the plain text edition
+func (b Book) TextFile() string {
+	return b.Slug + ".txt"
+}
+
+// MarkdownFile returns the file name of the Markdown edition
+func (b Book) MarkdownFile() string {
+	return b.Slug + ".md"
+}
+
+// HTMLZipFile returns the file name of the zipped HTML edition
+func (b Book) HTMLZipFile() string {
+	return b.Slug + "-html.zip"
+}
+
+// exportBlock represents a block of chapter text
+type exportBlock struct {
+	Kind  string // "heading", "paragraph", "quote", "item" or "rule"
+	Level int
+	Text  string
+}
+
+// exportNote represents a footnote collected from a chapter
+type exportNote struct {
+	ID   string
+	Text string
+}
+
+// exportChapter represents a chapter flattened into blocks and endnotes
+type exportChapter struct {
+	Slug   string
+	Title  string
+	Blocks []exportBlock
+	Notes  []exportNote
+}
+
+var footnoteNumberPattern = regexp.MustCompile(`^\d+\.\s*`)
+
+// flattenChapter converts chapter XHTML into blocks of text. Footnotes are
+// pulled out into Notes in order of first reference and each reference is
+// replaced with its endnote number, formatted by noteRef.
+func flattenChapter(content string, markdown bool, noteRef func(n int) string) ([]exportBlock, []exportNote) {
+	var (
+		blocks     []exportBlock
+		notes      []exportNote
+		noteText   = map[string]string{}
+		noteOrder  = map[string]int{}
+		refOrder   []string
+		docOrder   []string
+		buf        strings.Builder
+		noteBuf    strings.Builder
+		noteID     string
+		noteDepth  int
+		quoteDepth int
+		inRef      bool
+		link       string
+		kind       = "paragraph"
+		level      int
+		marks      []string
+		markPos    []int
+	)
+
+	// Emphasis markers are only kept around non-blank text, with any
+	// surrounding whitespace moved outside so Markdown recognises them
+	openMark := func(marker string) {
+		marks = append(marks, marker)
+		markPos = append(markPos, buf.Len())
+		buf.WriteString(marker)
+	}
+	closeMark := func(marker string) {
+		if len(marks) == 0 || marks[len(marks)-1] != marker {
+			return
+		}
+		pos := markPos[len(markPos)-1]
+		marks, markPos = marks[:len(marks)-1], markPos[:len(markPos)-1]
+
+		s := buf.String()
+		inner := s[pos+len(marker):]
+		trimmed := strings.TrimSpace(inner)
+		buf.Reset()
+		buf.WriteString(s[:pos])
+		if trimmed == "" {
+			buf.WriteString(inner)
+			return
+		}
+		lead := inner[:len(inner)-len(strings.TrimLeft(inner, " \t\r\n"))]
+		trail := inner[len(strings.TrimRight(inner, " \t\r\n")):]
+		buf.WriteString(lead + marker + trimmed + marker + trail)
+	}
+
+	flush := func() {
+		for len(marks) > 0 {
+			closeMark(marks[len(marks)-1])
+		}
+		text := strings.TrimSpace(collapseWhitespace(buf.String()))
+		buf.Reset()
+		if text == "" {
+			return
+		}
+		if kind == "paragraph" && quoteDepth > 0 {
+			kind = "quote"
+		}
+		blocks = append(blocks, exportBlock{Kind: kind, Level: level, Text: text})
+		kind, level = "paragraph", 0
+	}
+
+	for _, tok := range tokenizeHTML(content) {
+		// Collect footnote bodies separately from the running text
+		if noteID != "" {
+			switch {
+			case tok.Kind == startTagToken && tok.Name == "aside":
+				noteDepth++
+			case tok.Kind == endTagToken && tok.Name == "aside":
+				noteDepth--
+				if noteDepth == 0 {
+					text := strings.TrimSpace(collapseWhitespace(noteBuf.String()))
+					noteText[noteID] = footnoteNumberPattern.ReplaceAllString(text, "")
+					docOrder = append(docOrder, noteID)
+					noteID = ""
+					noteBuf.Reset()
+				}
+			case tok.Kind == textToken:
+				noteBuf.WriteString(tok.Text())
+			case tok.Kind == startTagToken && (tok.Name == "p" || tok.Name == "br"), tok.Kind == selfClosingTagToken && tok.Name == "br":
+				noteBuf.WriteString(" ")
+			}
+			continue
+		}
+
+		if inRef {
+			if tok.Kind == endTagToken && tok.Name == "a" {
+				inRef = false
+			}
+			continue
+		}
+
+		switch tok.Kind {
+		case textToken:
+			text := tok.Text()
+			if markdown {
+				text = escapeMarkdown(text)
+			}
+			buf.WriteString(text)
+
+		case startTagToken, selfClosingTagToken:
+			switch {
+			case tok.isFootnote():
+				flush()
+				noteID = tok.Attrs["id"]
+				noteDepth = 1
+			case tok.Name == "a" && tok.Attrs["class"] == "permalink":
+				inRef = tok.Kind == startTagToken
+			case tok.isNoteRef():
+				id := strings.TrimPrefix(tok.Attrs["href"], "#")
+				if _, ok := noteOrder[id]; !ok {
+					refOrder = append(refOrder, id)
+					noteOrder[id] = len(refOrder)
+				}
+				buf.WriteString(noteRef(noteOrder[id]))
+				inRef = tok.Kind == startTagToken
+			case tok.isHeading():
+				flush()
+				kind = "heading"
+				level, _ = strconv.Atoi(tok.Name[1:])
+			case tok.Name == "blockquote":
+				flush()
+				quoteDepth++
+			case tok.Name == "li":
+				flush()
+				kind = "item"
+			case tok.Name == "p" || tok.Name == "div" || tok.Name == "section" || tok.Name == "aside":
+				flush()
+			case tok.Name == "hr":
+				flush()
+				blocks = append(blocks, exportBlock{Kind: "rule"})
+			case tok.Name == "br":
+				buf.WriteString(" ")
+			case tok.Name == "em" || tok.Name == "i":
+				openMark(emphasisMarker(markdown))
+			case markdown && (tok.Name == "strong" || tok.Name == "b"):
+				openMark("**")
+			case markdown && tok.Name == "a" && tok.Attrs["href"] != "":
+				link = tok.Attrs["href"]
+				buf.WriteString("[")
+			}
+
+		case endTagToken:
+			switch {
+			case tok.isHeading() || tok.Name == "p" || tok.Name == "li" || tok.Name == "div" || tok.Name == "section" || tok.Name == "aside":
+				flush()
+			case tok.Name == "blockquote":
+				flush()
+				if quoteDepth > 0 {
+					quoteDepth--
+				}
+			case tok.Name == "em" || tok.Name == "i":
+				closeMark(emphasisMarker(markdown))
+			case markdown && (tok.Name == "strong" || tok.Name == "b"):
+				closeMark("**")
+			case markdown && tok.Name == "a" && link != "":
+				buf.WriteString("](" + link + ")")
+				link = ""
+			}
+		}
+	}
+	flush()
+
+	for _, id := range refOrder {
+		notes = append(notes, exportNote{ID: id, Text: noteText[id]})
+	}
+	// Footnotes that are never referenced still belong in the edition
+	for _, id := range docOrder {
+		if _, ok := noteOrder[id]; !ok {
+			notes = append(notes, exportNote{ID: id, Text: noteText[id]})
+		}
+	}
+
+	return blocks, notes
+}
+
+// emphasisMarker returns the italics marker used by an edition: Markdown
+// asterisks, or the underscores conventional in plain text books
+func emphasisMarker(markdown bool) string {
+	if markdown {
+		return "*"
+	}
+	return "_"
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`, "`", "\\`", "<", "&lt;")
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// loadExportChapters loads every chapter of a book flattened for export
+func loadExportChapters(book *Book, markdown bool) ([]exportChapter, error) {
+	var chapters []exportChapter
+	for _, chapterInfo := range book.Chapters {
+		chapter, err := loadChapter(book, chapterInfo.Slug)
+		if err != nil {
+			return nil, err
+		}
+
+		slug := chapterInfo.Slug
+		noteRef := func(n int) string { return fmt.Sprintf("[%d]", n) }
+		if markdown {
+			noteRef = func(n int) string { return fmt.Sprintf("[^%s-%d]", slug, n) }
+		}
+
+		blocks, notes := flattenChapter(string(chapter.Content), markdown, noteRef)
+		// The editions head every chapter with its title, which the
+		// chapter's own opening heading would repeat
+		if len(blocks) > 0 && blocks[0].Kind == "heading" {
+			blocks = blocks[1:]
+		}
+		chapters = append(chapters, exportChapter{
+			Slug:   slug,
+			Title:  chapter.Title,
+			Blocks: blocks,
+			Notes:  notes,
+		})
+	}
+	return chapters, nil
+}
+
+// bookCredits returns the people credited on a book's title page, in the
+// book's language
+func bookCredits(book *Book) ([]string, error) {
+	ui, err := loadUIStrings(book.Lang())
+	if err != nil {
+		return nil, err
+	}
+
+	m := book.Metadata
+	var credits []string
+	for _, credit := range []struct{ key, name string }{
+		{"by", m.Author},
+		{"translated_by", m.Translator},
+		{"edited_by", m.Editor},
+		{"illustrated_by", m.Illustrator},
+	} {
+		if credit.name != "" {
+			credits = append(credits, ui[credit.key]+" "+credit.name)
+		}
+	}
+	if m.Year != 0 {
+		credits = append(credits, strconv.Itoa(m.Year))
+	}
+	return credits, nil
+}
+
+const textWidth = 72
+
+// wrapText wraps text to width columns, prefixing every line with indent
+func wrapText(text, indent string, width int) string {
+	var lines []string
+	line := indent
+	for _, word := range strings.Fields(text) {
+		if len(line) > len(indent) && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = indent
+		}
+		if len(line) > len(indent) {
+			line += " "
+		}
+		line += word
+	}
+	lines = append(lines, line)
+	return strings.Join(lines, "\n")
+}
+
+// buildTextEdition renders a book as UTF-8 plain text with per-chapter endnotes
+func buildTextEdition(book *Book) ([]byte, error) {
+	chapters, err := loadExportChapters(book, false)
+	if err != nil {
+		return nil, err
+	}
+	credits, err := bookCredits(book)
+	if err != nil {
+		return nil, err
+	}
+	ui, err := loadUIStrings(book.Lang())
+	if err != nil {
+		return nil, err
+	}
+
+	var out strings.Builder
+	out.WriteString(strings.ToUpper(book.Metadata.Title) + "\n")
+	if book.Metadata.Subtitle != "" {
+		out.WriteString("\n" + wrapText(book.Metadata.Subtitle, "", textWidth) + "\n")
+	}
+	out.WriteString("\n")
+	for _, credit := range credits {
+		out.WriteString(credit + "\n")
+	}
+
+	out.WriteString("\n\n" + strings.ToUpper(ui["contents"]) + "\n\n")
+	for i, chapter := range chapters {
+		out.WriteString(fmt.Sprintf("%3d. %s\n", i+1, chapter.Title))
+	}
+
+	for _, chapter := range chapters {
+		out.WriteString("\n\n" + strings.Repeat("-", textWidth) + "\n\n")
+		out.WriteString(strings.ToUpper(chapter.Title) + "\n\n")
+
+		for _, block := range chapter.Blocks {
+			switch block.Kind {
+			case "heading":
+				out.WriteString(block.Text + "\n\n")
+			case "quote":
+				out.WriteString(wrapText(block.Text, "    ", textWidth) + "\n\n")
+			case "item":
+				out.WriteString(wrapText("* "+block.Text, "  ", textWidth) + "\n\n")
+			case "rule":
+				out.WriteString("        *       *       *\n\n")
+			default:
+				out.WriteString(wrapText(block.Text, "", textWidth) + "\n\n")
+			}
+		}
+
+		if len(chapter.Notes) > 0 {
+			out.WriteString(strings.ToUpper(ui["notes"]) + "\n\n")
+			for i, note := range chapter.Notes {
+				out.WriteString(wrapText(fmt.Sprintf("[%d] %s", i+1, note.Text), "", textWidth) + "\n\n")
+			}
+		}
+	}
+
+	return []byte(strings.TrimRight(out.String(), "\n") + "\n"), nil
+}
+
+// buildMarkdownEdition renders a book as a single Markdown document
+func buildMarkdownEdition(book *Book) ([]byte, error) {
+	chapters, err := loadExportChapters(book, true)
+	if err != nil {
+		return nil, err
+	}
+	credits, err := bookCredits(book)
+	if err != nil {
+		return nil, err
+	}
+	ui, err := loadUIStrings(book.Lang())
+	if err != nil {
+		return nil, err
+	}
+
+	var out strings.Builder
+	out.WriteString("# " + escapeMarkdown(book.Metadata.Title) + "\n\n")
+	if book.Metadata.Subtitle != "" {
+		out.WriteString("*" + escapeMarkdown(book.Metadata.Subtitle) + "*\n\n")
+	}
+	for _, credit := range credits {
+		out.WriteString(escapeMarkdown(credit) + "  \n")
+	}
+
+	out.WriteString("\n## " + escapeMarkdown(ui["contents"]) + "\n\n")
+	for i, chapter := range chapters {
+		out.WriteString(fmt.Sprintf("%d. %s\n", i+1, escapeMarkdown(chapter.Title)))
+	}
+
+	for _, chapter := range chapters {
+		out.WriteString("\n## " + escapeMarkdown(chapter.Title) + "\n\n")
+
+		for _, block := range chapter.Blocks {
+			switch block.Kind {
+			case "heading":
+				level := block.Level + 2
+				if level > 6 {
+					level = 6
+				}
+				out.WriteString(strings.Repeat("#", level) + " " + block.Text + "\n\n")
+			case "quote":
+				out.WriteString("> " + block.Text + "\n\n")
+			case "item":
+				out.WriteString("- " + block.Text + "\n\n")
+			case "rule":
+				out.WriteString("---\n\n")
+			default:
+				out.WriteString(block.Text + "\n\n")
+			}
+		}
+
+		for i, note := range chapter.Notes {
+			out.WriteString(fmt.Sprintf("[^%s-%d]: %s\n", chapter.Slug, i+1, escapeMarkdown(note.Text)))
+		}
+	}
+
+	return []byte(strings.TrimRight(out.String(), "\n") + "\n"), nil
+}
+
+// exportPageData represents data for rendering a standalone HTML chapter
+type exportPageData struct {
+	Book    *Book
+	Chapter *ChapterData
+	TOC     []TOCEntry
+	UI      map[string]string
+}
+
+// buildHTMLZipEdition renders every chapter as a standalone HTML file and
+// bundles them with a contents page into a zip archive
+func buildHTMLZipEdition(book *Book) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	modified := bookUpdated(book)
+	ui, err := loadUIStrings(book.Lang())
+	if err != nil {
+		return nil, err
+	}
+
+	writePage := func(name, tmpl string, data exportPageData) error {
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     book.Slug + "/" + name,
+			Method:   zip.Deflate,
+			Modified: modified,
+		})
+		if err != nil {
+			return err
+		}
+		return templates.ExecuteTemplate(f, tmpl, data)
+	}
+
+	toc := tocEntries(book.Contents, func(slug string) string {
+		return slug + ".html"
+	})
+	if err := writePage("index.html", "export_index.html", exportPageData{Book: book, TOC: toc, UI: ui}); err != nil {
+		return nil, err
+	}
+
+	for i, chapterInfo := range book.Chapters {
+		chapter, err := loadChapter(book, chapterInfo.Slug)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			chapter.PrevChapter = &book.Chapters[i-1]
+		}
+		if i < len(book.Chapters)-1 {
+			chapter.NextChapter = &book.Chapters[i+1]
+		}
+
+		if err := writePage(chapterInfo.Slug+".html", "export_chapter.html", exportPageData{Book: book, Chapter: chapter, UI: ui}); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
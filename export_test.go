@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBookCredits(t *testing.T) {
+	inContentRoot(t, map[string]string{
+		"i18n/en.yaml": "by: By\ntranslated_by: Translated by\nedited_by: Edited by\nillustrated_by: Illustrated by\n",
+		"i18n/hi.yaml": "by: \"लेखक:\"\ntranslated_by: \"अनुवादक:\"\n",
+	})
+	tests := []struct {
+		name     string
+		metadata BookMetadata
+		want     []string
+	}{
+		{"everyone", BookMetadata{Author: "A", Translator: "T", Editor: "E", Illustrator: "I", Year: 1900},
+			[]string{"By A", "Translated by T", "Edited by E", "Illustrated by I", "1900"}},
+		{"author only", BookMetadata{Author: "A"}, []string{"By A"}},
+		{"nobody", BookMetadata{}, nil},
+		{"book's language", BookMetadata{Language: "hi", Author: "A", Translator: "T", Editor: "E"},
+			[]string{"लेखक: A", "अनुवादक: T", "Edited by E"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := bookCredits(&Book{Slug: "b", Metadata: tt.metadata})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextAndMarkdownEditions(t *testing.T) {
+	inContentRoot(t, map[string]string{
+		"i18n/en.yaml":          "by: By\ncontents: Contents\nnotes: Notes\n",
+		"i18n/hi.yaml":          "contents: अनुक्रम\nnotes: टिप्पणियाँ\n",
+		"books/b/metadata.yaml": "title: B\nlanguage: hi\n",
+		"books/b/chapters.yaml": "chapters:\n  - slug: one\n    title: One\n",
+		"books/b/chapters/one.xhtml": `<h1>One</h1>
+<p>First.<a href="#footnote1" epub:type="noteref"><sup>1</sup></a></p>
+<h2>Part</h2>
+<p>Second.</p>
+<aside id="footnote1" epub:type="footnote"><p>1. A note.</p></aside>
+`,
+	})
+	book, err := loadBook("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		build func(*Book) ([]byte, error)
+		want  []string // in order
+		once  string   // the chapter heading, which must not repeat
+	}{
+		{"text", buildTextEdition, []string{"अनुक्रम", "  1. One", "ONE", "First.[1]", "Part", "Second.", "टिप्पणियाँ", "[1] A note."}, "ONE"},
+		{"markdown", buildMarkdownEdition, []string{"## अनुक्रम", "1. One", "## One", "First.[^one-1]", "#### Part", "Second.", "[^one-1]: A note."}, "# One"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.build(book)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := string(data)
+			rest := out
+			for _, want := range tt.want {
+				i := strings.Index(rest, want)
+				if i < 0 {
+					t.Fatalf("edition lacks %q after earlier parts:\n%s", want, out)
+				}
+				rest = rest[i+len(want):]
+			}
+			if n := strings.Count(out, tt.once+"\n"); n != 1 {
+				t.Errorf("%q appears %d times:\n%s", tt.once, n, out)
+			}
+			for _, english := range []string{"CONTENTS", "NOTES", "## Contents"} {
+				if strings.Contains(out, english) {
+					t.Errorf("edition has %q:\n%s", english, out)
+				}
+			}
+		})
+	}
+}
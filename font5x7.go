@@ -0,0 +1,102 @@
+package main
+
+// font5x7 is a 5x7 pixel bitmap font covering printable ASCII, used to
+// rasterize generated covers and social cards without a font library.
+// Each glyph is seven rows of five columns; '#' marks a set pixel.
+var font5x7 = map[rune][7]string{
+	' ':  {"     ", "     ", "     ", "     ", "     ", "     ", "     "},
+	'!':  {"  #  ", "  #  ", "  #  ", "  #  ", "  #  ", "     ", "  #  "},
+	'"':  {" # # ", " # # ", "     ", "     ", "     ", "     ", "     "},
+	'#':  {" # # ", " # # ", "#####", " # # ", "#####", " # # ", " # # "},
+	'$':  {"  #  ", " ####", "# #  ", " ### ", "  # #", "#### ", "  #  "},
+	'%':  {"##   ", "##  #", "   # ", "  #  ", " #   ", "#  ##", "   ##"},
+	'&':  {" ##  ", "#  # ", "# #  ", " #   ", "# # #", "#  # ", " ## #"},
+	'\'': {"  #  ", "  #  ", "     ", "     ", "     ", "     ", "     "},
+	'(':  {"   # ", "  #  ", " #   ", " #   ", " #   ", "  #  ", "   # "},
+	')':  {" #   ", "  #  ", "   # ", "   # ", "   # ", "  #  ", " #   "},
+	'*':  {"     ", "  #  ", "# # #", " ### ", "# # #", "  #  ", "     "},
+	'+':  {"     ", "  #  ", "  #  ", "#####", "  #  ", "  #  ", "     "},
+	',':  {"     ", "     ", "     ", "     ", " ##  ", "  #  ", " #   "},
+	'-':  {"     ", "     ", "     ", "#####", "     ", "     ", "     "},
+	'.':  {"     ", "     ", "     ", "     ", "     ", " ##  ", " ##  "},
+	'/':  {"     ", "    #", "   # ", "  #  ", " #   ", "#    ", "     "},
+	'0':  {" ### ", "#   #", "#  ##", "# # #", "##  #", "#   #", " ### "},
+	'1':  {"  #  ", " ##  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "},
+	'2':  {" ### ", "#   #", "    #", "   # ", "  #  ", " #   ", "#####"},
+	'3':  {"#####", "   # ", "  #  ", "   # ", "    #", "#   #", " ### "},
+	'4':  {"   # ", "  ## ", " # # ", "#  # ", "#####", "   # ", "   # "},
+	'5':  {"#####", "#    ", "#### ", "    #", "    #", "#   #", " ### "},
+	'6':  {"  ## ", " #   ", "#    ", "#### ", "#   #", "#   #", " ### "},
+	'7':  {"#####", "    #", "   # ", "  #  ", " #   ", " #   ", " #   "},
+	'8':  {" ### ", "#   #", "#   #", " ### ", "#   #", "#   #", " ### "},
+	'9':  {" ### ", "#   #", "#   #", " ####", "    #", "   # ", " ##  "},
+	':':  {"     ", " ##  ", " ##  ", "     ", " ##  ", " ##  ", "     "},
+	';':  {"     ", " ##  ", " ##  ", "     ", " ##  ", "  #  ", " #   "},
+	'<':  {"   # ", "  #  ", " #   ", "#    ", " #   ", "  #  ", "   # "},
+	'=':  {"     ", "     ", "#####", "     ", "#####", "     ", "     "},
+	'>':  {" #   ", "  #  ", "   # ", "    #", "   # ", "  #  ", " #   "},
+	'?':  {" ### ", "#   #", "    #", "   # ", "  #  ", "     ", "  #  "},
+	'@':  {" ### ", "#   #", "    #", " ## #", "# # #", "# # #", " ### "},
+	'A':  {" ### ", "#   #", "#   #", "#####", "#   #", "#   #", "#   #"},
+	'B':  {"#### ", "#   #", "#   #", "#### ", "#   #", "#   #", "#### "},
+	'C':  {" ### ", "#   #", "#    ", "#    ", "#    ", "#   #", " ### "},
+	'D':  {"###  ", "#  # ", "#   #", "#   #", "#   #", "#  # ", "###  "},
+	'E':  {"#####", "#    ", "#    ", "#### ", "#    ", "#    ", "#####"},
+	'F':  {"#####", "#    ", "#    ", "#### ", "#    ", "#    ", "#    "},
+	'G':  {" ### ", "#   #", "#    ", "# ###", "#   #", "#   #", " ####"},
+	'H':  {"#   #", "#   #", "#   #", "#####", "#   #", "#   #", "#   #"},
+	'I':  {" ### ", "  #  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "},
+	'J':  {"  ###", "   # ", "   # ", "   # ", "   # ", "#  # ", " ##  "},
+	'K':  {"#   #", "#  # ", "# #  ", "##   ", "# #  ", "#  # ", "#   #"},
+	'L':  {"#    ", "#    ", "#    ", "#    ", "#    ", "#    ", "#####"},
+	'M':  {"#   #", "## ##", "# # #", "# # #", "#   #", "#   #", "#   #"},
+	'N':  {"#   #", "#   #", "##  #", "# # #", "#  ##", "#   #", "#   #"},
+	'O':  {" ### ", "#   #", "#   #", "#   #", "#   #", "#   #", " ### "},
+	'P':  {"#### ", "#   #", "#   #", "#### ", "#    ", "#    ", "#    "},
+	'Q':  {" ### ", "#   #", "#   #", "#   #", "# # #", "#  # ", " ## #"},
+	'R':  {"#### ", "#   #", "#   #", "#### ", "# #  ", "#  # ", "#   #"},
+	'S':  {" ####", "#    ", "#    ", " ### ", "    #", "    #", "#### "},
+	'T':  {"#####", "  #  ", "  #  ", "  #  ", "  #  ", "  #  ", "  #  "},
+	'U':  {"#   #", "#   #", "#   #", "#   #", "#   #", "#   #", " ### "},
+	'V':  {"#   #", "#   #", "#   #", "#   #", "#   #", " # # ", "  #  "},
+	'W':  {"#   #", "#   #", "#   #", "# # #", "# # #", "# # #", " # # "},
+	'X':  {"#   #", "#   #", " # # ", "  #  ", " # # ", "#   #", "#   #"},
+	'Y':  {"#   #", "#   #", " # # ", "  #  ", "  #  ", "  #  ", "  #  "},
+	'Z':  {"#####", "    #", "   # ", "  #  ", " #   ", "#    ", "#####"},
+	'[':  {" ### ", " #   ", " #   ", " #   ", " #   ", " #   ", " ### "},
+	'\\': {"     ", "#    ", " #   ", "  #  ", "   # ", "    #", "     "},
+	']':  {" ### ", "   # ", "   # ", "   # ", "   # ", "   # ", " ### "},
+	'^':  {"  #  ", " # # ", "#   #", "     ", "     ", "     ", "     "},
+	'_':  {"     ", "     ", "     ", "     ", "     ", "     ", "#####"},
+	'`':  {" #   ", "  #  ", "     ", "     ", "     ", "     ", "     "},
+	'a':  {"     ", "     ", " ### ", "    #", " ####", "#   #", " ####"},
+	'b':  {"#    ", "#    ", "# ## ", "##  #", "#   #", "#   #", "#### "},
+	'c':  {"     ", "     ", " ### ", "#    ", "#    ", "#   #", " ### "},
+	'd':  {"    #", "    #", " ## #", "#  ##", "#   #", "#   #", " ####"},
+	'e':  {"     ", "     ", " ### ", "#   #", "#####", "#    ", " ### "},
+	'f':  {"  ## ", " #  #", " #   ", "###  ", " #   ", " #   ", " #   "},
+	'g':  {"     ", "     ", " ####", "#   #", " ####", "    #", " ### "},
+	'h':  {"#    ", "#    ", "# ## ", "##  #", "#   #", "#   #", "#   #"},
+	'i':  {"  #  ", "     ", " ##  ", "  #  ", "  #  ", "  #  ", " ### "},
+	'j':  {"   # ", "     ", "  ## ", "   # ", "   # ", "#  # ", " ##  "},
+	'k':  {"#    ", "#    ", "#  # ", "# #  ", "##   ", "# #  ", "#  # "},
+	'l':  {" ##  ", "  #  ", "  #  ", "  #  ", "  #  ", "  #  ", " ### "},
+	'm':  {"     ", "     ", "## # ", "# # #", "# # #", "#   #", "#   #"},
+	'n':  {"     ", "     ", "# ## ", "##  #", "#   #", "#   #", "#   #"},
+	'o':  {"     ", "     ", " ### ", "#   #", "#   #", "#   #", " ### "},
+	'p':  {"     ", "     ", "#### ", "#   #", "#### ", "#    ", "#    "},
+	'q':  {"     ", "     ", " ## #", "#  ##", " ####", "    #", "    #"},
+	'r':  {"     ", "     ", "# ## ", "##  #", "#    ", "#    ", "#    "},
+	's':  {"     ", "     ", " ### ", "#    ", " ### ", "    #", "#### "},
+	't':  {" #   ", " #   ", "###  ", " #   ", " #   ", " #  #", "  ## "},
+	'u':  {"     ", "     ", "#   #", "#   #", "#   #", "#  ##", " ## #"},
+	'v':  {"     ", "     ", "#   #", "#   #", "#   #", " # # ", "  #  "},
+	'w':  {"     ", "     ", "#   #", "#   #", "# # #", "# # #", " # # "},
+	'x':  {"     ", "     ", "#   #", " # # ", "  #  ", " # # ", "#   #"},
+	'y':  {"     ", "     ", "#   #", "#   #", " ####", "    #", " ### "},
+	'z':  {"     ", "     ", "#####", "   # ", "  #  ", " #   ", "#####"},
+	'{':  {"   # ", "  #  ", "  #  ", " #   ", "  #  ", "  #  ", "   # "},
+	'|':  {"  #  ", "  #  ", "  #  ", "  #  ", "  #  ", "  #  ", "  #  "},
+	'}':  {" #   ", "  #  ", "  #  ", "   # ", "  #  ", "  #  ", " #   "},
+	'~':  {"     ", "     ", " #   ", "# # #", "   # ", "     ", "     "},
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// GlossaryTerm represents a term entry in glossary.yaml
+type GlossaryTerm struct {
+	Term       string   `yaml:"term"`
+	Slug       string   `yaml:"slug"`
+	Variants   []string `yaml:"variants"`
+	Definition string   `yaml:"definition"`
+
+	pattern *regexp.Regexp
+}
+
+// GlossaryConfig represents the glossary.yaml structure
+type GlossaryConfig struct {
+	Terms []GlossaryTerm `yaml:"terms"`
+}
+
+var slugPattern = regexp.MustCompile(`[^a-z0-9]+`)
+
+// slugify turns a title into a lowercase, hyphen-separated slug
+func slugify(s string) string {
+	return strings.Trim(slugPattern.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// loadGlossary reads a book's glossary.yaml, sorted alphabetically. A book
+// without a glossary returns no terms and no error.
+func loadGlossary(bookSlug string) ([]GlossaryTerm, error) {
+	data, err := os.ReadFile(filepath.Join("books", bookSlug, "glossary.yaml"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config GlossaryConfig
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+
+	for i := range config.Terms {
+		term := &config.Terms[i]
+		if term.Term == "" {
+			return nil, fmt.Errorf("glossary term %d has no name", i+1)
+		}
+		if term.Slug == "" {
+			term.Slug = slugify(term.Term)
+		}
+
+		// Match the term and its variants as whole words, longest first,
+		// allowing the line breaks the transcriptions have inside phrases
+		forms := append([]string{term.Term}, term.Variants...)
+		sort.Slice(forms, func(a, b int) bool { return len(forms[a]) > len(forms[b]) })
+		for j, form := range forms {
+			forms[j] = strings.Join(strings.Fields(regexp.QuoteMeta(form)), `\s+`)
+		}
+		term.pattern = regexp.MustCompile(`(?i)\b(?:` + strings.Join(forms, "|") + `)\b`)
+	}
+
+	sort.SliceStable(config.Terms, func(a, b int) bool {
+		return strings.ToLower(config.Terms[a].Term) < strings.ToLower(config.Terms[b].Term)
+	})
+
+	return config.Terms, nil
+}
+
+// linkGlossaryTerms links the first occurrence of each glossary term in a
+// chapter to its definition on the glossary page. Footnotes, headings and
+// existing links are left alone.
+func linkGlossaryTerms(book *Book, content template.HTML) template.HTML {
+	if len(book.Glossary) == 0 {
+		return content
+	}
+
+	linked := make([]bool, len(book.Glossary))
+	skipDepth := 0
+
+	var out strings.Builder
+	for _, tok := range tokenizeHTML(string(content)) {
+		skippable := tok.Name == "aside" || tok.Name == "a" || tok.isHeading()
+		switch {
+		case tok.Kind == startTagToken && skippable:
+			skipDepth++
+		case tok.Kind == endTagToken && skippable && skipDepth > 0:
+			skipDepth--
+		case tok.Kind == textToken && skipDepth == 0:
+			out.WriteString(linkTermsInText(book, tok.Raw, linked))
+			continue
+		}
+		out.WriteString(tok.Raw)
+	}
+
+	return template.HTML(out.String())
+}
+
+// linkTermsInText links the earliest unlinked term in text, repeatedly,
+// until no unlinked term occurs in the rest of the text
+func linkTermsInText(book *Book, text string, linked []bool) string {
+	var out strings.Builder
+	for {
+		best, bestLoc := -1, []int(nil)
+		for i, term := range book.Glossary {
+			if linked[i] {
+				continue
+			}
+			loc := term.pattern.FindStringIndex(text)
+			if loc == nil {
+				continue
+			}
+			if bestLoc == nil || loc[0] < bestLoc[0] || (loc[0] == bestLoc[0] && loc[1] > bestLoc[1]) {
+				best, bestLoc = i, loc
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		term := book.Glossary[best]
+		linked[best] = true
+		out.WriteString(text[:bestLoc[0]])
+		out.WriteString(fmt.Sprintf(`<a href="%s/glossary#%s" class="glossary-term" title="%s">%s</a>`,
+			book.Path(), term.Slug, html.EscapeString(term.Definition), text[bestLoc[0]:bestLoc[1]]))
+		text = text[bestLoc[1]:]
+	}
+	out.WriteString(text)
+	return out.String()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"html/template"
+	"testing"
+)
+
+func TestLinkGlossaryTerms(t *testing.T) {
+	inContentRoot(t, map[string]string{
+		"books/b/glossary.yaml": `terms:
+  - term: Sepoy
+    variants: [sipahi]
+    definition: An Indian soldier.
+  - term: Jemadar
+    definition: "A junior officer <rank>."
+  - term: Subedar Major
+    definition: The senior Indian officer.
+`,
+	})
+	glossary, err := loadGlossary("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	book := &Book{Slug: "b", Glossary: glossary}
+
+	sepoy := `<a href="/book/b/glossary#sepoy" class="glossary-term" title="An Indian soldier.">`
+	jemadar := `<a href="/book/b/glossary#jemadar" class="glossary-term" title="A junior officer &lt;rank&gt;.">`
+	major := `<a href="/book/b/glossary#subedar-major" class="glossary-term" title="The senior Indian officer.">`
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{"no terms", `<p>A march.</p>`, `<p>A march.</p>`},
+		{
+			"first occurrence only",
+			`<p>A sepoy met a Sepoy.</p><p>Another sepoy.</p>`,
+			`<p>A ` + sepoy + `sepoy</a> met a Sepoy.</p><p>Another sepoy.</p>`,
+		},
+		{
+			"variant counts as the term",
+			`<p>The sipahi, a sepoy.</p>`,
+			`<p>The ` + sepoy + `sipahi</a>, a sepoy.</p>`,
+		},
+		{
+			"whole words only",
+			`<p>Sepoys and a sepoy.</p>`,
+			`<p>Sepoys and a ` + sepoy + `sepoy</a>.</p>`,
+		},
+		{
+			"several terms in one text",
+			`<p>The jemadar and the sepoy.</p>`,
+			`<p>The ` + jemadar + `jemadar</a> and the ` + sepoy + `sepoy</a>.</p>`,
+		},
+		{
+			"phrase across a line break",
+			"<p>The Subedar\nMajor spoke.</p>",
+			"<p>The " + major + "Subedar\nMajor</a> spoke.</p>",
+		},
+		{
+			"skips headings",
+			`<h2>The Sepoy</h2><p>A sepoy.</p>`,
+			`<h2>The Sepoy</h2><p>A ` + sepoy + `sepoy</a>.</p>`,
+		},
+		{
+			"skips links",
+			`<p><a href="/x">A sepoy</a> and a sepoy.</p>`,
+			`<p><a href="/x">A sepoy</a> and a ` + sepoy + `sepoy</a>.</p>`,
+		},
+		{
+			"skips footnotes",
+			`<aside epub:type="footnote"><p>1. A sepoy.</p></aside><p>A sepoy.</p>`,
+			`<aside epub:type="footnote"><p>1. A sepoy.</p></aside><p>A ` + sepoy + `sepoy</a>.</p>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := linkGlossaryTerms(book, template.HTML(tt.in)); string(got) != tt.want {
+				t.Errorf("linkGlossaryTerms(%q) =\n%s\nwant\n%s", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -3,6 +3,6 @@ module github.com/sashank-tirumala/personal-website-domain
 go 1.23.4
 
 require (
-	github.com/yuin/goldmark v1.7.12 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
+	github.com/yuin/goldmark v1.7.12
+	gopkg.in/yaml.v3 v3.0.1
 )
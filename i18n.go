@@ -0,0 +1,276 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultLanguage is the language of content that doesn't declare one. Its
+// pages are served without a language prefix; other languages are served
+// under /<lang>/.
+const defaultLanguage = "en"
+
+// translationsDir holds the interface strings of each language, one
+// <lang>.yaml file per language
+const translationsDir = "i18n"
+
+// Translation links a post, book or page to a version of it in another language
+type Translation struct {
+	Language string
+	Title    string
+	Path     string
+}
+
+// LanguageName returns the display name of the translation's language
+func (t Translation) LanguageName() string {
+	return languageName(t.Language)
+}
+
+// languageName returns the display name of a language code
+func languageName(lang string) string {
+	if name, ok := languageNames[strings.ToLower(lang)]; ok {
+		return name
+	}
+	return lang
+}
+
+// languagePrefix returns the path prefix of pages in a language
+func languagePrefix(lang string) string {
+	if lang == "" || lang == defaultLanguage {
+		return ""
+	}
+	return "/" + lang
+}
+
+// knownLanguage reports whether lang has a display name or a translation
+// file, so that it can be told apart from other top-level paths
+func knownLanguage(lang string) bool {
+	if _, ok := languageNames[lang]; ok {
+		return true
+	}
+	_, err := os.Stat(filepath.Join(translationsDir, lang+".yaml"))
+	return err == nil
+}
+
+// splitLanguagePrefix splits a language prefix such as /hi off a site path
+func splitLanguagePrefix(path string) (lang, rest string) {
+	segment, rest, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if segment != strings.ToLower(segment) || !languagePattern.MatchString(segment) || !knownLanguage(segment) {
+		return "", path
+	}
+	return segment, "/" + rest
+}
+
+// Lang returns the language of the post
+func (p Post) Lang() string {
+	if p.Metadata.Language != "" {
+		return p.Metadata.Language
+	}
+	return defaultLanguage
+}
+
+// Path returns the site path of the post
+func (p Post) Path() string {
+	return languagePrefix(p.Lang()) + "/post/" + p.Slug
+}
+
+// Lang returns the language of the book
+func (b Book) Lang() string {
+	if b.Metadata.Language != "" {
+		return b.Metadata.Language
+	}
+	return defaultLanguage
+}
+
+// Path returns the site path of the book, without a trailing slash
+func (b Book) Path() string {
+	return languagePrefix(b.Lang()) + "/book/" + b.Slug
+}
+
+// loadUIStrings returns the interface strings of a language. Strings
+// missing from its translation file fall back to the default language.
+func loadUIStrings(lang string) (map[string]string, error) {
+	ui := map[string]string{}
+	for _, l := range []string{defaultLanguage, lang} {
+		data, err := os.ReadFile(filepath.Join(translationsDir, l+".yaml"))
+		if os.IsNotExist(err) && l != defaultLanguage {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var values map[string]string
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, err
+		}
+		for key, value := range values {
+			ui[key] = value
+		}
+	}
+	return ui, nil
+}
+
+// uiString returns one interface string of a language. Pages are only
+// rendered once their strings load, so it is empty if they can't be read.
+func uiString(lang, key string) string {
+	ui, _ := loadUIStrings(lang)
+	return ui[key]
+}
+
+// titlePageFile returns the Markdown file of the home page in a language.
+// Translations sit next to the original as index.<lang>.md.
+func titlePageFile(lang string) string {
+	if lang == defaultLanguage {
+		return filepath.Join("title-page", "index.md")
+	}
+	return filepath.Join("title-page", "index."+lang+".md")
+}
+
+// linkPostTranslations fills in the translations of each post. A
+// translation names the slug of its original in translation_of, and the
+// original and all of its translations link to each other.
+func linkPostTranslations(posts []Post) {
+	groups := map[string][]int{}
+	for i, post := range posts {
+		key := post.Metadata.TranslationOf
+		if key == "" {
+			key = post.Slug
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	for _, group := range groups {
+		for _, i := range group {
+			posts[i].Translations = nil
+			for _, j := range group {
+				if j != i {
+					posts[i].Translations = append(posts[i].Translations, Translation{
+						Language: posts[j].Lang(),
+						Title:    posts[j].Metadata.Title,
+						Path:     posts[j].Path(),
+					})
+				}
+			}
+		}
+	}
+}
+
+// linkBookTranslations fills in the translations of each book, the same
+// way as linkPostTranslations
+func linkBookTranslations(books []Book) {
+	groups := map[string][]int{}
+	for i, book := range books {
+		key := book.Metadata.TranslationOf
+		if key == "" {
+			key = book.Slug
+		}
+		groups[key] = append(groups[key], i)
+	}
+
+	for _, group := range groups {
+		for _, i := range group {
+			books[i].Translations = nil
+			for _, j := range group {
+				if j != i {
+					books[i].Translations = append(books[i].Translations, Translation{
+						Language: books[j].Lang(),
+						Title:    books[j].Metadata.Title,
+						Path:     books[j].Path() + "/",
+					})
+				}
+			}
+		}
+	}
+}
+
+// postsInLanguage returns the posts written in lang
+func postsInLanguage(posts []Post, lang string) []Post {
+	var out []Post
+	for _, post := range posts {
+		if post.Lang() == lang {
+			out = append(out, post)
+		}
+	}
+	return out
+}
+
+// booksInLanguage returns the books written in lang
+func booksInLanguage(books []Book, lang string) []Book {
+	var out []Book
+	for _, book := range books {
+		if book.Lang() == lang {
+			out = append(out, book)
+		}
+	}
+	return out
+}
+
+// siteLanguages returns the languages the site has content in, the
+// default language first
+func siteLanguages(posts []Post, books []Book) []string {
+	seen := map[string]bool{defaultLanguage: true}
+	var others []string
+	add := func(lang string) {
+		if !seen[lang] {
+			seen[lang] = true
+			others = append(others, lang)
+		}
+	}
+	for _, post := range posts {
+		add(post.Lang())
+	}
+	for _, book := range books {
+		add(book.Lang())
+	}
+	if files, err := filepath.Glob(filepath.Join("title-page", "index.*.md")); err == nil {
+		for _, file := range files {
+			add(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(file), "index."), ".md"))
+		}
+	}
+	sort.Strings(others)
+	return append([]string{defaultLanguage}, others...)
+}
+
+// listingTranslations returns the versions of a listing page, such as
+// /posts/, in the other site languages
+func listingTranslations(languages []string, lang, path string) []Translation {
+	var out []Translation
+	for _, l := range languages {
+		if l != lang {
+			out = append(out, Translation{Language: l, Path: languagePrefix(l) + path})
+		}
+	}
+	return out
+}
+
+// withLanguage fills in the language and interface strings of template
+// data, taking the language from the post or book shown
+func withLanguage(data interface{}) (interface{}, error) {
+	page, ok := data.(PageData)
+	if !ok {
+		return data, nil
+	}
+
+	switch {
+	case page.Lang != "":
+	case page.Post != nil:
+		page.Lang = page.Post.Lang()
+	case page.Book != nil:
+		page.Lang = page.Book.Lang()
+	default:
+		page.Lang = defaultLanguage
+	}
+	page.LangPrefix = languagePrefix(page.Lang)
+
+	ui, err := loadUIStrings(page.Lang)
+	if err != nil {
+		return nil, err
+	}
+	page.UI = ui
+	return page, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v3"
+)
+
+// runLint checks the content for mistakes that would otherwise only show
+// up as a post or book quietly missing from the site
+func runLint(cfg *config, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+
+	problems := lintContent()
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problem(s) found", len(problems))
+	}
+	fmt.Println("No problems found")
+	return nil
+}
+
+// lintContent returns a description of every problem found in the posts,
+// books, interface strings and templates
+func lintContent() []string {
+	var problems []string
+	problem := func(path string, format string, args ...interface{}) {
+		problems = append(problems, path+": "+fmt.Sprintf(format, args...))
+	}
+
+	// Shortcode templates, which posts are rendered with
+	if err := loadShortcodeTemplates(); err != nil {
+		problem(shortcodeDir, "%v", err)
+	}
+
+	// Posts
+	var posts []Post
+	if entries, err := os.ReadDir("blogs"); err != nil {
+		problem("blogs", "%v", err)
+	} else {
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			dir := filepath.Join("blogs", entry.Name())
+			post, err := loadPost(entry.Name())
+			if err != nil {
+				problem(dir, "%v", err)
+				continue
+			}
+			posts = append(posts, *post)
+
+			metadata := filepath.Join(dir, "metadata.yaml")
+			if post.Metadata.Title == "" {
+				problem(metadata, "title is required")
+			}
+			if post.Metadata.Date.IsZero() {
+				problem(metadata, "date is required")
+			}
+			if lang := post.Metadata.Language; lang != "" && !knownLanguage(lang) {
+				problem(metadata, "language %q has no display name or translation file", lang)
+			}
+		}
+	}
+	for _, post := range posts {
+		if of := post.Metadata.TranslationOf; of != "" && !lintHasPost(posts, of) {
+			problem(filepath.Join("blogs", post.Slug, "metadata.yaml"), "translation_of names %q, which isn't a post", of)
+		}
+	}
+
+	// Books
+	var books []Book
+	if entries, err := os.ReadDir("books"); err != nil {
+		problem("books", "%v", err)
+	} else {
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				continue
+			}
+			dir := filepath.Join("books", entry.Name())
+			book, err := loadBook(entry.Name())
+			if err != nil {
+				problem(dir, "%v", err)
+				continue
+			}
+			books = append(books, *book)
+
+			for _, ch := range book.Chapters {
+				if _, err := loadChapter(book, ch.Slug); err != nil {
+					problem(filepath.Join(dir, "chapters.yaml"), "chapter %s: %v", ch.Slug, err)
+				}
+				// The XHTML would silently win over the Markdown
+				markdownPath := filepath.Join(dir, "chapters", ch.Slug+".md")
+				if _, err := os.Stat(markdownPath); err == nil {
+					if _, err := os.Stat(filepath.Join(dir, "chapters", ch.Slug+".xhtml")); err == nil {
+						problem(markdownPath, "is ignored because %s.xhtml exists", ch.Slug)
+					}
+				}
+			}
+		}
+	}
+	for _, book := range books {
+		if of := book.Metadata.TranslationOf; of != "" && !lintHasBook(books, of) {
+			problem(filepath.Join("books", book.Slug, "metadata.yaml"), "translation_of names %q, which isn't a book", of)
+		}
+	}
+
+	// Interface strings
+	defaults, err := loadUIStrings(defaultLanguage)
+	if err != nil {
+		problem(filepath.Join(translationsDir, defaultLanguage+".yaml"), "%v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(translationsDir, "*.yaml"))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			problem(file, "%v", err)
+			continue
+		}
+		var values map[string]string
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			problem(file, "%v", err)
+			continue
+		}
+		for key := range values {
+			if _, ok := defaults[key]; !ok && defaults != nil {
+				problem(file, "%s isn't one of the strings in %s.yaml", key, defaultLanguage)
+			}
+		}
+	}
+
+	// Templates
+	if _, err := template.ParseGlob(filepath.Join("templates", "*.html")); err != nil {
+		problem("templates", "%v", err)
+	}
+
+	return problems
+}
+
+func lintHasPost(posts []Post, slug string) bool {
+	for _, post := range posts {
+		if post.Slug == slug {
+			return true
+		}
+	}
+	return false
+}
+
+func lintHasBook(books []Book, slug string) bool {
+	for _, book := range books {
+		if book.Slug == slug {
+			return true
+		}
+	}
+	return false
+}
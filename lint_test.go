@@ -0,0 +1,29 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLintReportsReservedChapterSlugs(t *testing.T) {
+	inContentRoot(t, map[string]string{
+		"blogs/.keep":                     "",
+		"books/b/metadata.yaml":           "title: B\nauthor: A\n",
+		"books/b/chapters.yaml":           "chapters:\n  - slug: intro\n    title: Intro\n  - slug: glossary\n    title: Glossary\n",
+		"books/b/chapters/intro.xhtml":    "<h1>Intro</h1>\n",
+		"books/b/chapters/glossary.xhtml": "<h1>Glossary</h1>\n",
+		"i18n/en.yaml":                    "books: Books\n",
+		"templates/base.html":             "{{define \"base\"}}{{end}}\n",
+	})
+
+	problems := lintContent()
+	found := false
+	for _, problem := range problems {
+		if strings.HasPrefix(problem, "books/b: ") && strings.Contains(problem, "glossary: slug is reserved") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("no problem reported for the reserved slug: %q", problems)
+	}
+}
@@ -1,487 +1,323 @@
-//go:build !build
-
 package main
 
 import (
-	"bytes"
-	"html/template"
-	"log"
-	"net/http"
+	"errors"
+	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
-	"sort"
+	"slices"
 	"strings"
 	"time"
-
-	"github.com/yuin/goldmark"
-	"github.com/yuin/goldmark/extension"
-	"github.com/yuin/goldmark/parser"
-	"github.com/yuin/goldmark/renderer/html"
-	"gopkg.in/yaml.v3"
 )
 
-// PostMetadata represents the metadata for a blog post
-type PostMetadata struct {
-	Title       string    `yaml:"title"`
-	Date        time.Time `yaml:"date"`
-	Description string    `yaml:"description"`
-	Tags        []string  `yaml:"tags"`
-}
-
-// BookMetadata represents the metadata for a book
-type BookMetadata struct {
-	Title       string `yaml:"title"`
-	Subtitle    string `yaml:"subtitle"`
-	Author      string `yaml:"author"`
-	Translator  string `yaml:"translator"`
-	Editor      string `yaml:"editor"`
-	Illustrator string `yaml:"illustrator"`
-	Year        int    `yaml:"year"`
-	Description string `yaml:"description"`
-	EpubFile    string `yaml:"epub_file"`
-}
-
-// ChapterInfo represents a chapter entry in chapters.yaml
-type ChapterInfo struct {
-	Slug  string `yaml:"slug"`
-	Title string `yaml:"title"`
-}
-
-// ChaptersConfig represents the chapters.yaml structure
-type ChaptersConfig struct {
-	Chapters []ChapterInfo `yaml:"chapters"`
-}
-
-// Book represents a complete book
-type Book struct {
-	Metadata BookMetadata
-	Slug     string
-	Chapters []ChapterInfo
-	Snippet  template.HTML
-	Intro    template.HTML
-}
-
-// ChapterData represents data for rendering a chapter
-type ChapterData struct {
-	Title       string
-	Content     template.HTML
-	BookSlug    string
-	BookTitle   string
-	ChapterSlug string
-	PrevChapter *ChapterInfo
-	NextChapter *ChapterInfo
-}
-
-// Post represents a complete blog post
-type Post struct {
-	Metadata PostMetadata
-	Content  template.HTML
-	Slug     string
-}
-
-// PageData represents data passed to templates
-type PageData struct {
-	Title   string
-	Content template.HTML
-	Posts   []Post
-	Post    *Post
-	Books   []Book
-	Book    *Book
-	Chapter *ChapterData
-}
-
-var (
-	templates *template.Template
-	md        goldmark.Markdown
+// Exit codes of the command
+const (
+	exitOK    = 0
+	exitError = 1 // the command ran and failed
+	exitUsage = 2 // the command line was invalid
 )
 
-func init() {
-	// Initialize goldmark with extensions
-	md = goldmark.New(
-		goldmark.WithExtensions(
-			extension.GFM,
-			extension.Table,
-			extension.Strikethrough,
-			extension.Linkify,
-			extension.TaskList,
-		),
-		goldmark.WithParserOptions(
-			parser.WithAutoHeadingID(),
-		),
-		goldmark.WithRendererOptions(
-			html.WithHardWraps(),
-			html.WithXHTML(),
-		),
-	)
+// config holds the settings shared by the subcommands
+type config struct {
+	Root    string // directory holding blogs/, books/, templates/ and static/
+	Out     string // directory the site is built into
+	Addr    string // address the server listens on
+	BaseURL string // prepended to site paths in absolute URLs
+
+	// Options of the build report
+	ReportFile string // file to write the report to as JSON
+	Strict     bool   // fail a build that skipped content or had warnings
+
+	// UpdateAnchors records paragraph ids in the books' anchors.yaml
+	UpdateAnchors bool
+
+	// Options of the server
+	TLSCert      string
+	TLSKey       string
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	LogFormat    string
+
+	// Options of the analytics
+	AnalyticsFile  string
+	AnalyticsToken string
+
+	// Options of the metrics
+	MetricsToken string
+
+	// Options of the new commands
+	Slug          string
+	Lang          string
+	TranslationOf string
+	Author        string
+	After         string
+	Before        string
+
+	// Options of the typography command
+	Write bool
+	Style string
 }
 
-func main() {
-	// Parse templates
-	var err error
-	templates, err = template.ParseGlob(filepath.Join("templates", "*.html"))
-	if err != nil {
-		log.Fatal("Error parsing templates:", err)
-	}
-
-	// Define routes
-	http.HandleFunc("/", homeHandler)
-	http.HandleFunc("/posts/", postsHandler)
-	http.HandleFunc("/post/", postHandler)
-	http.HandleFunc("/books/", booksHandler)
-	http.HandleFunc("/book/", bookHandler)
-
-	// Serve static files
-	fs := http.FileServer(http.Dir("static"))
-	http.Handle("/static/", http.StripPrefix("/static/", fs))
-
-	// Start server
-	port := ":8080"
-	log.Printf("Server starting on http://localhost%s", port)
-	if err := http.ListenAndServe(port, nil); err != nil {
-		log.Fatal("Server failed to start:", err)
-	}
-}
-
-func homeHandler(w http.ResponseWriter, r *http.Request) {
-	if r.URL.Path != "/" {
-		http.NotFound(w, r)
-		return
-	}
-
-	// Read content from title-page directory
-	content, err := readMarkdownFile("title-page/index.md")
-	if err != nil {
-		log.Printf("Error reading title page: %v", err)
-		content = template.HTML("<p>Welcome to my blog!</p>")
-	}
-
-	data := PageData{
-		Title:   "My Personal Website",
-		Content: content,
-	}
-
-	renderTemplate(w, "home.html", data)
+// command is a subcommand of the site tool, e.g. serve or build
+type command struct {
+	Name    string
+	Args    string // synopsis of the positional arguments
+	Summary string
+	Help    string   // more about the command, shown by -h
+	Flags   []string // names of the shared flags the command takes
+	Run     func(cfg *config, args []string) error
 }
 
-func postsHandler(w http.ResponseWriter, r *http.Request) {
-	posts, err := loadAllPosts()
-	if err != nil {
-		http.Error(w, "Error loading posts", http.StatusInternalServerError)
-		log.Printf("Error loading posts: %v", err)
-		return
-	}
-
-	data := PageData{
-		Title: "Blog Posts",
-		Posts: posts,
-	}
-
-	renderTemplate(w, "posts.html", data)
+var commands = []command{
+	{
+		Name:    "serve",
+		Summary: "Serve the site",
+		Help: "/healthz and /readyz are open to anyone. /metrics is open to requests from this machine\n" +
+			"that a reverse proxy didn't forward, and to anyone giving -metrics-token. The /analytics\n" +
+			"dashboard needs -analytics-file and -analytics-token. Tokens are given as a bearer token\n" +
+			"or as the password of basic authentication.",
+		Flags: []string{"root", "addr", "base-url", "tls", "timeouts", "log-format", "analytics", "metrics"},
+		Run:   runServe,
+	},
+	{
+		Name:    "build",
+		Summary: "Build the static site",
+		Flags:   []string{"root", "out", "base-url", "report", "anchors"},
+		Run:     runBuild,
+	},
+	{
+		Name:    "lint",
+		Summary: "Check posts, books and translations for mistakes",
+		Flags:   []string{"root"},
+		Run:     runLint,
+	},
+	{
+		Name:    "check",
+		Summary: "Build the site and check that its internal links resolve",
+		Flags:   []string{"root", "out", "base-url", "report", "anchors"},
+		Run:     runCheck,
+	},
+	{
+		Name:    "typography",
+		Args:    "<book> [chapter...]",
+		Summary: "Show how normalizing its punctuation would change a book's chapters",
+		Flags:   []string{"root", "typography"},
+		Run:     runTypography,
+	},
+	{
+		Name:    "new post",
+		Args:    "<title>",
+		Summary: "Create a post dated today",
+		Flags:   []string{"root", "slug", "lang", "translation-of"},
+		Run:     runNewPost,
+	},
+	{
+		Name:    "new book",
+		Args:    "<title>",
+		Summary: "Create a book with no chapters",
+		Flags:   []string{"root", "slug", "author", "lang", "translation-of"},
+		Run:     runNewBook,
+	},
+	{
+		Name:    "new chapter",
+		Args:    "<book> <title>",
+		Summary: "Add a chapter to a book",
+		Flags:   []string{"root", "slug", "after", "before"},
+		Run:     runNewChapter,
+	},
 }
 
-func postHandler(w http.ResponseWriter, r *http.Request) {
-	// Extract slug from URL
-	slug := strings.TrimPrefix(r.URL.Path, "/post/")
-	if slug == "" {
-		http.NotFound(w, r)
-		return
-	}
+// progName is the name the tool was run as, for help text
+var progName = filepath.Base(os.Args[0])
 
-	post, err := loadPost(slug)
-	if err != nil {
-		http.NotFound(w, r)
-		return
-	}
+// errUsage is returned by commands given invalid arguments
+var errUsage = errors.New("invalid usage")
 
-	data := PageData{
-		Title: post.Metadata.Title,
-		Post:  post,
-	}
-
-	renderTemplate(w, "post.html", data)
+func main() {
+	os.Exit(run(os.Args[1:]))
 }
 
-func loadAllPosts() ([]Post, error) {
-	var posts []Post
-
-	blogsDir := "blogs"
-	entries, err := os.ReadDir(blogsDir)
-	if err != nil {
-		return nil, err
-	}
-
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
+// run runs the subcommand named by args and returns the exit code
+func run(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+		usage()
+		if len(args) == 0 {
+			return exitUsage
 		}
-
-		post, err := loadPost(entry.Name())
-		if err != nil {
-			log.Printf("Error loading post %s: %v", entry.Name(), err)
-			continue
-		}
-
-		posts = append(posts, *post)
+		return exitOK
 	}
 
-	// Sort posts by date (newest first)
-	sort.Slice(posts, func(i, j int) bool {
-		return posts[i].Metadata.Date.After(posts[j].Metadata.Date)
-	})
-
-	return posts, nil
-}
-
-func loadPost(slug string) (*Post, error) {
-	postDir := filepath.Join("blogs", slug)
-
-	// Read metadata
-	metadataPath := filepath.Join(postDir, "metadata.yaml")
-	metadata, err := readMetadata(metadataPath)
-	if err != nil {
-		return nil, err
-	}
-
-	// Read content
-	contentPath := filepath.Join(postDir, "index.md")
-	content, err := readMarkdownFile(contentPath)
-	if err != nil {
-		return nil, err
-	}
-
-	return &Post{
-		Metadata: *metadata,
-		Content:  content,
-		Slug:     slug,
-	}, nil
-}
-
-func readMetadata(path string) (*PostMetadata, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, err
-	}
-
-	var metadata PostMetadata
-	err = yaml.Unmarshal(data, &metadata)
-	if err != nil {
-		return nil, err
-	}
-
-	return &metadata, nil
-}
-
-func readMarkdownFile(path string) (template.HTML, error) {
-	content, err := os.ReadFile(path)
-	if err != nil {
-		return "", err
+	// Command names are one or more words, such as build or new post
+	var cmd *command
+	for i := range commands {
+		words := strings.Fields(commands[i].Name)
+		if len(args) >= len(words) && slices.Equal(args[:len(words)], words) {
+			cmd = &commands[i]
+		}
 	}
-
-	var buf bytes.Buffer
-	if err := md.Convert(content, &buf); err != nil {
-		return "", err
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "%s: unknown command %q\n\n", progName, strings.Join(args, " "))
+		usage()
+		return exitUsage
 	}
 
-	return template.HTML(buf.String()), nil
-}
-
-func renderTemplate(w http.ResponseWriter, tmpl string, data interface{}) {
-	err := templates.ExecuteTemplate(w, tmpl, data)
+	cfg := &config{}
+	fs := newFlagSet(cmd, cfg)
+	positional, err := parseFlags(fs, args[len(strings.Fields(cmd.Name)):])
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		log.Printf("Template execution error: %v", err)
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
 	}
-}
 
-func booksHandler(w http.ResponseWriter, r *http.Request) {
-	books, err := loadAllBooks()
-	if err != nil {
-		http.Error(w, "Error loading books", http.StatusInternalServerError)
-		log.Printf("Error loading books: %v", err)
-		return
+	if err := cfg.apply(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", progName, cmd.Name, err)
+		return exitError
 	}
 
-	data := PageData{
-		Title: "Books",
-		Books: books,
+	if err := cmd.Run(cfg, positional); err != nil {
+		if errors.Is(err, errUsage) {
+			fs.Usage()
+			return exitUsage
+		}
+		fmt.Fprintf(os.Stderr, "%s %s: %v\n", progName, cmd.Name, err)
+		return exitError
 	}
-
-	renderTemplate(w, "books.html", data)
+	return exitOK
 }
 
-func bookHandler(w http.ResponseWriter, r *http.Request) {
-	// URL format: /book/{bookSlug}/ or /book/{bookSlug}/{chapterSlug} or /book/{bookSlug}/download
-	path := strings.TrimPrefix(r.URL.Path, "/book/")
-	path = strings.TrimSuffix(path, "/")
-
-	parts := strings.SplitN(path, "/", 2)
-	if len(parts) == 0 || parts[0] == "" {
-		http.NotFound(w, r)
-		return
-	}
-
-	bookSlug := parts[0]
-
-	// Load the book
-	book, err := loadBook(bookSlug)
-	if err != nil {
-		http.NotFound(w, r)
-		return
-	}
-
-	// If only book slug, show table of contents
-	if len(parts) == 1 || parts[1] == "" {
-		data := PageData{
-			Title: book.Metadata.Title,
-			Book:  book,
+// newFlagSet returns the flag set of a command, registering the shared
+// flags it takes
+func newFlagSet(cmd *command, cfg *config) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	for _, name := range cmd.Flags {
+		switch name {
+		case "root":
+			fs.StringVar(&cfg.Root, "root", ".", "directory holding the site's content, templates and static files")
+		case "out":
+			fs.StringVar(&cfg.Out, "out", "", "directory to build the site into (default <root>/public)")
+		case "report":
+			fs.StringVar(&cfg.ReportFile, "report", "", "file to write the build report to as JSON (default none)")
+			fs.BoolVar(&cfg.Strict, "strict", false, "fail if any content was skipped or there were warnings")
+		case "anchors":
+			fs.BoolVar(&cfg.UpdateAnchors, "update-anchors", false, "record the current paragraph ids in each book's anchors.yaml, aliasing changed ones")
+		case "addr":
+			fs.StringVar(&cfg.Addr, "addr", ":8080", "address to listen on, or unix:<path> for a Unix socket")
+		case "tls":
+			fs.StringVar(&cfg.TLSCert, "tls-cert", "", "certificate file to serve HTTPS and HTTP/2 with")
+			fs.StringVar(&cfg.TLSKey, "tls-key", "", "private key file of the certificate")
+		case "timeouts":
+			fs.DurationVar(&cfg.ReadTimeout, "read-timeout", 10*time.Second, "maximum time to read a request")
+			fs.DurationVar(&cfg.WriteTimeout, "write-timeout", 60*time.Second, "maximum time to write a response")
+			fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", 120*time.Second, "maximum time to keep an idle connection open")
+		case "analytics":
+			fs.StringVar(&cfg.AnalyticsFile, "analytics-file", "", "file to record anonymous page views and downloads in (default none)")
+			fs.StringVar(&cfg.AnalyticsToken, "analytics-token", os.Getenv("SITE_ANALYTICS_TOKEN"), "token that opens the /analytics dashboard (default $SITE_ANALYTICS_TOKEN)")
+		case "metrics":
+			fs.StringVar(&cfg.MetricsToken, "metrics-token", os.Getenv("SITE_METRICS_TOKEN"), "token that opens /metrics to requests from elsewhere (default $SITE_METRICS_TOKEN)")
+		case "log-format":
+			fs.StringVar(&cfg.LogFormat, "log-format", "text", "format of the logs, text or json")
+		case "base-url":
+			fs.StringVar(&cfg.BaseURL, "base-url", os.Getenv("SITE_BASE_URL"), "URL the site is published at, for absolute links (default $SITE_BASE_URL)")
+		case "typography":
+			fs.BoolVar(&cfg.Write, "write", false, "rewrite the chapters rather than only showing the changes")
+			fs.StringVar(&cfg.Style, "style", "", "american or british (default from the book's typography.yaml, else "+defaultTypographyStyle+")")
+		case "slug":
+			fs.StringVar(&cfg.Slug, "slug", "", "slug to create it under (default made from the title)")
+		case "lang":
+			fs.StringVar(&cfg.Lang, "lang", "", "language it is written in (default "+defaultLanguage+")")
+		case "translation-of":
+			fs.StringVar(&cfg.TranslationOf, "translation-of", "", "slug of the original it translates")
+		case "author":
+			fs.StringVar(&cfg.Author, "author", "", "author of the book")
+		case "after":
+			fs.StringVar(&cfg.After, "after", "", "slug of the chapter to insert it after (default the last chapter)")
+		case "before":
+			fs.StringVar(&cfg.Before, "before", "", "slug of the chapter to insert it before")
 		}
-		renderTemplate(w, "book.html", data)
-		return
 	}
 
-	// Handle EPUB download
-	if strings.HasSuffix(parts[1], ".epub") && parts[1] == book.Metadata.EpubFile {
-		epubPath := filepath.Join("books", bookSlug, book.Metadata.EpubFile)
-		w.Header().Set("Content-Type", "application/epub+zip")
-		w.Header().Set("Content-Disposition", "attachment; filename=\""+book.Metadata.EpubFile+"\"")
-		http.ServeFile(w, r, epubPath)
-		return
-	}
-
-	// Otherwise, show the chapter
-	chapterSlug := parts[1]
-	chapter, err := loadChapter(book, chapterSlug)
-	if err != nil {
-		http.NotFound(w, r)
-		return
-	}
-
-	data := PageData{
-		Title:   chapter.Title + " - " + book.Metadata.Title,
-		Book:    book,
-		Chapter: chapter,
+	fs.Usage = func() {
+		synopsis := progName + " " + cmd.Name + " [flags]"
+		if cmd.Args != "" {
+			synopsis += " " + cmd.Args
+		}
+		fmt.Fprintf(fs.Output(), "usage: %s\n\n%s.\n\n", synopsis, cmd.Summary)
+		if cmd.Help != "" {
+			fmt.Fprintf(fs.Output(), "%s\n\n", cmd.Help)
+		}
+		fmt.Fprintf(fs.Output(), "Flags:\n")
+		fs.PrintDefaults()
 	}
-
-	renderTemplate(w, "chapter.html", data)
+	return fs
 }
 
-func loadAllBooks() ([]Book, error) {
-	var books []Book
-
-	booksDir := "books"
-	entries, err := os.ReadDir(booksDir)
-	if err != nil {
-		return nil, err
+// parseFlags parses the flags of a command, which may come before, after
+// or between its positional arguments, and returns the positional ones.
+// Everything after -- is positional.
+func parseFlags(fs *flag.FlagSet, args []string) ([]string, error) {
+	var rest []string
+	if i := slices.Index(args, "--"); i >= 0 {
+		args, rest = args[:i], args[i+1:]
 	}
 
-	for _, entry := range entries {
-		if !entry.IsDir() {
-			continue
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
 		}
-
-		book, err := loadBook(entry.Name())
-		if err != nil {
-			log.Printf("Error loading book %s: %v", entry.Name(), err)
-			continue
+		if fs.NArg() == 0 {
+			return append(positional, rest...), nil
 		}
-
-		books = append(books, *book)
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
 	}
-
-	return books, nil
 }
 
-func loadBook(slug string) (*Book, error) {
-	bookDir := filepath.Join("books", slug)
-
-	// Read metadata
-	metadataPath := filepath.Join(bookDir, "metadata.yaml")
-	metadataData, err := os.ReadFile(metadataPath)
-	if err != nil {
-		return nil, err
+// apply resolves the configured directories and makes the content root
+// the working directory, which content is loaded relative to
+func (cfg *config) apply() error {
+	if cfg.Root == "" {
+		cfg.Root = "."
 	}
-
-	var metadata BookMetadata
-	err = yaml.Unmarshal(metadataData, &metadata)
-	if err != nil {
-		return nil, err
+	if cfg.Out == "" {
+		cfg.Out = filepath.Join(cfg.Root, "public")
 	}
 
-	// Read chapters config
-	chaptersPath := filepath.Join(bookDir, "chapters.yaml")
-	chaptersData, err := os.ReadFile(chaptersPath)
+	out, err := filepath.Abs(cfg.Out)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	cfg.Out = out
+	outputDir = out
 
-	var chaptersConfig ChaptersConfig
-	err = yaml.Unmarshal(chaptersData, &chaptersConfig)
-	if err != nil {
-		return nil, err
+	// The report is written relative to where the command was run
+	if cfg.ReportFile != "" {
+		if cfg.ReportFile, err = filepath.Abs(cfg.ReportFile); err != nil {
+			return err
+		}
 	}
 
-	// Read snippet (optional) - short intro for books list
-	var snippet template.HTML
-	snippetPath := filepath.Join(bookDir, "snippet.html")
-	if snippetData, err := os.ReadFile(snippetPath); err == nil {
-		snippet = template.HTML(snippetData)
-	}
+	baseURL = strings.TrimSuffix(cfg.BaseURL, "/")
+	updateAnchors = cfg.UpdateAnchors
 
-	// Read intro (optional) - longer intro for book page
-	var intro template.HTML
-	introPath := filepath.Join(bookDir, "intro.html")
-	if introData, err := os.ReadFile(introPath); err == nil {
-		intro = template.HTML(introData)
+	if err := os.Chdir(cfg.Root); err != nil {
+		return fmt.Errorf("content root: %w", err)
 	}
-
-	return &Book{
-		Metadata: metadata,
-		Slug:     slug,
-		Chapters: chaptersConfig.Chapters,
-		Snippet:  snippet,
-		Intro:    intro,
-	}, nil
+	return nil
 }
 
-func loadChapter(book *Book, chapterSlug string) (*ChapterData, error) {
-	// Find chapter index
-	chapterIndex := -1
-	var chapterInfo ChapterInfo
-	for i, ch := range book.Chapters {
-		if ch.Slug == chapterSlug {
-			chapterIndex = i
-			chapterInfo = ch
-			break
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s <command> [flags] [arguments]\n\nCommands:\n", progName)
+	for _, cmd := range commands {
+		name := cmd.Name
+		if cmd.Args != "" {
+			name += " " + cmd.Args
 		}
+		fmt.Fprintf(os.Stderr, "  %-28s %s\n", name, cmd.Summary)
 	}
-
-	if chapterIndex == -1 {
-		return nil, os.ErrNotExist
-	}
-
-	// Read chapter content
-	chapterPath := filepath.Join("books", book.Slug, "chapters", chapterSlug+".xhtml")
-	content, err := os.ReadFile(chapterPath)
-	if err != nil {
-		return nil, err
-	}
-
-	// Determine prev/next chapters
-	var prevChapter, nextChapter *ChapterInfo
-	if chapterIndex > 0 {
-		prevChapter = &book.Chapters[chapterIndex-1]
-	}
-	if chapterIndex < len(book.Chapters)-1 {
-		nextChapter = &book.Chapters[chapterIndex+1]
-	}
-
-	return &ChapterData{
-		Title:       chapterInfo.Title,
-		Content:     template.HTML(content),
-		BookSlug:    book.Slug,
-		BookTitle:   book.Metadata.Title,
-		ChapterSlug: chapterSlug,
-		PrevChapter: prevChapter,
-		NextChapter: nextChapter,
-	}, nil
+	fmt.Fprintf(os.Stderr, "\nRun '%s <command> -h' for the flags of a command.\n", progName)
 }
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name           string
+		args           []string
+		wantPositional []string
+		wantSlug       string
+		wantLang       string
+	}{
+		{"none", nil, nil, "", ""},
+		{"flags first", []string{"-slug", "s", "Title"}, []string{"Title"}, "s", ""},
+		{"flags last", []string{"Title", "-slug", "s"}, []string{"Title"}, "s", ""},
+		{"flags between", []string{"book", "-lang=hi", "Title", "-slug", "s"}, []string{"book", "Title"}, "s", "hi"},
+		{"double dash", []string{"-slug", "s", "--", "-not-a-flag", "x"}, []string{"-not-a-flag", "x"}, "s", ""},
+		{"flags before double dash only", []string{"a", "--", "-slug", "s"}, []string{"a", "-slug", "s"}, "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var slug, lang string
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.StringVar(&slug, "slug", "", "")
+			fs.StringVar(&lang, "lang", "", "")
+			positional, err := parseFlags(fs, tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(positional, tt.wantPositional) {
+				t.Errorf("positional = %q, want %q", positional, tt.wantPositional)
+			}
+			if slug != tt.wantSlug || lang != tt.wantLang {
+				t.Errorf("slug, lang = %q, %q, want %q, %q", slug, lang, tt.wantSlug, tt.wantLang)
+			}
+		})
+	}
+}
+
+func TestParseFlagsUnknownFlag(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	if _, err := parseFlags(fs, []string{"Title", "-nope"}); err == nil {
+		t.Error("unknown flag gave no error")
+	}
+}
+
+func TestRunExitCodes(t *testing.T) {
+	// Keep usage and errors out of the test output
+	stderr := os.Stderr
+	devNull, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	os.Stderr = devNull
+	defer func() { os.Stderr = stderr }()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"no command", nil, exitUsage},
+		{"help", []string{"help"}, exitOK},
+		{"-h", []string{"-h"}, exitOK},
+		{"unknown command", []string{"publish"}, exitUsage},
+		{"unknown subcommand", []string{"new", "page"}, exitUsage},
+		{"command help", []string{"build", "-h"}, exitOK},
+		{"unknown flag", []string{"build", "-nope"}, exitUsage},
+		{"flag of another command", []string{"lint", "-addr", ":80"}, exitUsage},
+		{"unexpected argument", []string{"lint", "-root", ".", "extra"}, exitUsage},
+		{"missing argument", []string{"new", "post", "-root", t.TempDir()}, exitUsage},
+		{"missing content root", []string{"lint", "-root", "/does/not/exist"}, exitError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := run(tt.args); got != tt.want {
+				t.Errorf("run(%q) = %d, want %d", tt.args, got, tt.want)
+			}
+			os.Chdir(wd)
+		})
+	}
+}
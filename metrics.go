@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request duration
+// histogram
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// siteMetrics holds the counters exposed at /metrics in the Prometheus text
+// format
+type siteMetrics struct {
+	mu             sync.Mutex
+	requests       map[[2]string]int // by route and status code
+	latency        map[string]*latencyHistogram
+	renderErrors   int
+	contentErrors  map[string]int   // by kind of content
+	contentFailure map[string]error // of the last attempt to list each kind
+	token          string           // opens /metrics to requests from elsewhere
+}
+
+type latencyHistogram struct {
+	counts []int // per bucket, not cumulative
+	count  int
+	sum    float64
+}
+
+var metrics = &siteMetrics{
+	requests:       map[[2]string]int{},
+	latency:        map[string]*latencyHistogram{},
+	contentErrors:  map[string]int{},
+	contentFailure: map[string]error{},
+}
+
+// observeRequest counts a request and its duration
+func (m *siteMetrics) observeRequest(route string, status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[[2]string{route, strconv.Itoa(status)}]++
+
+	h, ok := m.latency[route]
+	if !ok {
+		h = &latencyHistogram{counts: make([]int, len(latencyBuckets))}
+		m.latency[route] = h
+	}
+	seconds := d.Seconds()
+	for i, bound := range latencyBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// renderFailed counts a template that failed to render
+func (m *siteMetrics) renderFailed() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.renderErrors++
+}
+
+// contentFailed counts a post or book that failed to load
+func (m *siteMetrics) contentFailed(kind string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.contentErrors[kind]++
+}
+
+// contentListed records whether the posts or books could be listed at all
+func (m *siteMetrics) contentListed(kind string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.contentFailure[kind] = err
+}
+
+// writeTo writes the metrics in the Prometheus text exposition format
+func (m *siteMetrics) writeTo(w *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP site_http_requests_total Requests served, by route and status code.\n")
+	fmt.Fprintf(w, "# TYPE site_http_requests_total counter\n")
+	keys := make([][2]string, 0, len(m.requests))
+	for key := range m.requests {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		fmt.Fprintf(w, "site_http_requests_total{route=%q,code=%q} %d\n", key[0], key[1], m.requests[key])
+	}
+
+	fmt.Fprintf(w, "# HELP site_http_request_duration_seconds Time taken to serve requests, by route.\n")
+	fmt.Fprintf(w, "# TYPE site_http_request_duration_seconds histogram\n")
+	routes := make([]string, 0, len(m.latency))
+	for route := range m.latency {
+		routes = append(routes, route)
+	}
+	sort.Strings(routes)
+	for _, route := range routes {
+		h := m.latency[route]
+		cumulative := 0
+		for i, bound := range latencyBuckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "site_http_request_duration_seconds_bucket{route=%q,le=%q} %d\n", route, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+		}
+		fmt.Fprintf(w, "site_http_request_duration_seconds_bucket{route=%q,le=\"+Inf\"} %d\n", route, h.count)
+		fmt.Fprintf(w, "site_http_request_duration_seconds_sum{route=%q} %s\n", route, strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(w, "site_http_request_duration_seconds_count{route=%q} %d\n", route, h.count)
+	}
+
+	fmt.Fprintf(w, "# HELP site_template_render_errors_total Templates that failed to render.\n")
+	fmt.Fprintf(w, "# TYPE site_template_render_errors_total counter\n")
+	fmt.Fprintf(w, "site_template_render_errors_total %d\n", m.renderErrors)
+
+	fmt.Fprintf(w, "# HELP site_content_load_errors_total Posts and books that failed to load, by kind.\n")
+	fmt.Fprintf(w, "# TYPE site_content_load_errors_total counter\n")
+	for _, kind := range []string{"post", "book"} {
+		fmt.Fprintf(w, "site_content_load_errors_total{kind=%q} %d\n", kind, m.contentErrors[kind])
+	}
+}
+
+// requestRoute names the route of a request for metrics, after any
+// language prefix
+func requestRoute(urlPath string) string {
+	_, p := splitLanguagePrefix(urlPath)
+	segments := strings.Split(strings.Trim(p, "/"), "/")
+
+	switch segments[0] {
+	case "":
+		return "home"
+	case "posts":
+		return "posts"
+	case "post":
+		return "post"
+	case "books":
+		return "books"
+	case "static":
+		return "static"
+	case "api":
+		return "api"
+	case "opds.xml", "opds.json":
+		return "opds"
+	case "analytics":
+		return "analytics"
+	case "healthz", "readyz", "metrics":
+		return "health"
+	case "book":
+		switch {
+		case len(segments) < 3 || segments[2] == "all" || segments[2] == "glossary":
+			return "book"
+		case path.Ext(segments[2]) == ".epub":
+			return "epub"
+		case path.Ext(segments[2]) != "" || len(segments) > 3:
+			// Covers, citations and generated editions
+			return "book"
+		default:
+			return "chapter"
+		}
+	}
+	return "other"
+}
+
+// instrument counts requests and their durations by route
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		metrics.observeRequest(requestRoute(r.URL.Path), rec.status, time.Since(start))
+	})
+}
+
+// metricsHandler serves the metrics for Prometheus to scrape, to requests
+// from this machine and to holders of the token
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if !localRequest(r) && (metrics.token == "" || !checkToken(r, metrics.token)) {
+		if metrics.token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="metrics"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var b strings.Builder
+	metrics.writeTo(&b)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+// localRequest reports whether a request comes from this machine without
+// having been forwarded by a reverse proxy
+func localRequest(r *http.Request) bool {
+	if r.Header.Get("X-Forwarded-For") != "" || r.Header.Get("Forwarded") != "" {
+		return false
+	}
+	host := clientAddr(r)
+	if host == "" {
+		// Unix sockets are only reachable from this machine
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// healthzHandler reports that the server is up
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte("ok\n"))
+}
+
+// readyzHandler reports whether the server can serve pages: its templates
+// parsed and the posts and books could be listed
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	var problems []string
+	if templates == nil {
+		problems = append(problems, "templates not loaded")
+	}
+	metrics.mu.Lock()
+	for _, kind := range []string{"post", "book"} {
+		if err := metrics.contentFailure[kind]; err != nil {
+			problems = append(problems, kind+"s not loaded: "+err.Error())
+		}
+	}
+	metrics.mu.Unlock()
+	for _, dir := range []string{"blogs", "books", "templates", "static"} {
+		if _, err := os.Stat(dir); err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if len(problems) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(strings.Join(problems, "\n") + "\n"))
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
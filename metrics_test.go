@@ -0,0 +1,96 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestRoute(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "home"},
+		{"", "home"},
+		{"/posts", "posts"},
+		{"/post/hello", "post"},
+		{"/books", "books"},
+		{"/book/b", "book"},
+		{"/book/b/", "book"},
+		{"/book/b/all", "book"},
+		{"/book/b/glossary", "book"},
+		{"/book/b/cover.svg", "book"},
+		{"/book/b/citation.bib", "book"},
+		{"/book/b/export/pdf", "book"},
+		{"/book/b/b.epub", "epub"},
+		{"/book/b/intro", "chapter"},
+		{"/static/style.css", "static"},
+		{"/api/v1/posts", "api"},
+		{"/opds.xml", "opds"},
+		{"/opds.json", "opds"},
+		{"/analytics", "analytics"},
+		{"/healthz", "health"},
+		{"/readyz", "health"},
+		{"/metrics", "health"},
+		{"/favicon.ico", "other"},
+		{"/hi/", "home"},
+		{"/hi/post/hello", "post"},
+		{"/hi/book/b/intro", "chapter"},
+		{"/xx/post/hello", "other"},
+	}
+	for _, tt := range tests {
+		if got := requestRoute(tt.path); got != tt.want {
+			t.Errorf("requestRoute(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsHandlerAccess(t *testing.T) {
+	t.Cleanup(func() { metrics.token = "" })
+	tests := []struct {
+		name          string
+		token         string
+		remoteAddr    string
+		forwardedFor  string
+		authorization string
+		want          int
+	}{
+		{"loopback", "", "127.0.0.1:5000", "", "", http.StatusOK},
+		{"loopback IPv6", "", "[::1]:5000", "", "", http.StatusOK},
+		{"unix socket", "", "@", "", "", http.StatusOK},
+		{"loopback with a token set", "secret", "127.0.0.1:5000", "", "", http.StatusOK},
+		{"forwarded by a local proxy", "", "127.0.0.1:5000", "203.0.113.9", "", http.StatusNotFound},
+		{"forwarded from loopback", "", "127.0.0.1:5000", "127.0.0.1", "", http.StatusNotFound},
+		{"remote without a token set", "", "203.0.113.9:5000", "", "", http.StatusNotFound},
+		{"remote, token set but not given", "secret", "203.0.113.9:5000", "", "", http.StatusUnauthorized},
+		{"remote, wrong token", "secret", "203.0.113.9:5000", "", "Bearer nope", http.StatusUnauthorized},
+		{"remote, token", "secret", "203.0.113.9:5000", "", "Bearer secret", http.StatusOK},
+		{"forwarded, token", "secret", "127.0.0.1:5000", "203.0.113.9", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics.token = tt.token
+			r := httptest.NewRequest("GET", "/metrics", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			metricsHandler(w, r)
+			if w.Code != tt.want {
+				t.Fatalf("status = %d, want %d", w.Code, tt.want)
+			}
+			if tt.want == http.StatusOK && !strings.Contains(w.Body.String(), "site_http_requests_total") {
+				t.Errorf("body = %q", w.Body.String())
+			}
+			if tt.want == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("no WWW-Authenticate header")
+			}
+		})
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"mime"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	opdsAcquisitionType = "application/atom+xml;profile=opds-catalog;kind=acquisition"
+	opdsJSONType        = "application/opds+json"
+	opdsOpenAccessRel   = "http://opds-spec.org/acquisition/open-access"
+	opdsImageRel        = "http://opds-spec.org/image"
+	opdsThumbnailRel    = "http://opds-spec.org/image/thumbnail"
+	epubMediaType       = "application/epub+zip"
+)
+
+// OPDSFeed represents an OPDS 1.2 acquisition feed
+type OPDSFeed struct {
+	XMLName  xml.Name    `xml:"feed"`
+	Xmlns    string      `xml:"xmlns,attr"`
+	XmlnsDC  string      `xml:"xmlns:dc,attr"`
+	XmlnsOPF string      `xml:"xmlns:opf,attr"`
+	ID       string      `xml:"id"`
+	Title    string      `xml:"title"`
+	Updated  string      `xml:"updated"`
+	Author   *OPDSPerson `xml:"author,omitempty"`
+	Links    []OPDSLink  `xml:"link"`
+	Entries  []OPDSEntry `xml:"entry"`
+}
+
+// OPDSEntry represents a single publication in an OPDS 1.2 feed
+type OPDSEntry struct {
+	ID           string         `xml:"id"`
+	Title        string         `xml:"title"`
+	Updated      string         `xml:"updated"`
+	Authors      []OPDSPerson   `xml:"author"`
+	Contributors []OPDSPerson   `xml:"contributor"`
+	Issued       string         `xml:"dc:issued,omitempty"`
+	Language     string         `xml:"dc:language,omitempty"`
+	Publisher    string         `xml:"dc:publisher,omitempty"`
+	Identifiers  []string       `xml:"dc:identifier"`
+	Categories   []OPDSCategory `xml:"category"`
+	Rights       string         `xml:"rights,omitempty"`
+	Summary      string         `xml:"summary,omitempty"`
+	Links        []OPDSLink     `xml:"link"`
+}
+
+// OPDSCategory represents an Atom category, used for subjects
+type OPDSCategory struct {
+	Term  string `xml:"term,attr"`
+	Label string `xml:"label,attr"`
+}
+
+// OPDSPerson represents an Atom person construct, with the MARC relator
+// code of a contributor's role
+type OPDSPerson struct {
+	Role string `xml:"opf:role,attr,omitempty"`
+	Name string `xml:"name"`
+}
+
+// OPDSLink represents an Atom link
+type OPDSLink struct {
+	Rel   string `xml:"rel,attr" json:"rel,omitempty"`
+	Href  string `xml:"href,attr" json:"href"`
+	Type  string `xml:"type,attr" json:"type"`
+	Title string `xml:"title,attr,omitempty" json:"title,omitempty"`
+}
+
+// OPDS2Feed represents an OPDS 2.0 JSON feed
+type OPDS2Feed struct {
+	Metadata     OPDS2FeedMetadata  `json:"metadata"`
+	Links        []OPDSLink         `json:"links"`
+	Publications []OPDS2Publication `json:"publications"`
+}
+
+// OPDS2FeedMetadata represents the metadata of an OPDS 2.0 feed
+type OPDS2FeedMetadata struct {
+	Title    string `json:"title"`
+	Modified string `json:"modified"`
+}
+
+// OPDS2Publication represents a single publication in an OPDS 2.0 feed
+type OPDS2Publication struct {
+	Metadata OPDS2Metadata `json:"metadata"`
+	Links    []OPDSLink    `json:"links"`
+	Images   []OPDSLink    `json:"images,omitempty"`
+}
+
+// OPDS2Metadata represents the metadata of an OPDS 2.0 publication
+type OPDS2Metadata struct {
+	Type        string   `json:"@type"`
+	Identifier  string   `json:"identifier"`
+	Title       string   `json:"title"`
+	Subtitle    string   `json:"subtitle,omitempty"`
+	Author      string   `json:"author,omitempty"`
+	Translator  string   `json:"translator,omitempty"`
+	Editor      string   `json:"editor,omitempty"`
+	Illustrator string   `json:"illustrator,omitempty"`
+	Published   string   `json:"published,omitempty"`
+	Modified    string   `json:"modified"`
+	Language    string   `json:"language,omitempty"`
+	Publisher   string   `json:"publisher,omitempty"`
+	Subject     []string `json:"subject,omitempty"`
+	Description string   `json:"description,omitempty"`
+}
+
+// bookUpdated returns the last modification time of a book's metadata or EPUB
+func bookUpdated(book *Book) time.Time {
+	var updated time.Time
+	paths := []string{filepath.Join("books", book.Slug, "metadata.yaml")}
+	if book.Metadata.EpubFile != "" {
+		paths = append(paths, filepath.Join("books", book.Slug, book.Metadata.EpubFile))
+	}
+
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && info.ModTime().After(updated) {
+			updated = info.ModTime()
+		}
+	}
+
+	if updated.IsZero() {
+		updated = time.Now()
+	}
+	return updated.UTC()
+}
+
+// bookLinks returns the HTML page and EPUB acquisition links for a book
+func bookLinks(book *Book) []OPDSLink {
+	links := []OPDSLink{
+		{Rel: "alternate", Href: book.Path() + "/", Type: "text/html", Title: book.Metadata.Title},
+	}
+	if book.Metadata.EpubFile != "" {
+		links = append(links, OPDSLink{
+			Rel:  opdsOpenAccessRel,
+			Href: book.Path() + "/" + book.Metadata.EpubFile,
+			Type: epubMediaType,
+		})
+	}
+	return links
+}
+
+// bookCoverLink returns the raster cover image link of a book, hand-made or
+// generated, or its SVG cover when there is no raster one
+func bookCoverLink(book *Book, rel string) OPDSLink {
+	href := book.CoverImageURL()
+	if href == "" {
+		href = book.CoverURL()
+	}
+	return OPDSLink{
+		Rel:  rel,
+		Href: href,
+		Type: mime.TypeByExtension(filepath.Ext(href)),
+	}
+}
+
+// bookIdentifier returns the identifier used for a book in feeds,
+// preferring its ISBN
+func bookIdentifier(book *Book) string {
+	if isbn := book.Metadata.ISBN(); isbn != "" {
+		return identifierURN(BookIdentifier{Scheme: "isbn", Value: isbn})
+	}
+	return "urn:personal-website:book:" + book.Slug
+}
+
+// opdsFeedID returns the identifier of the books feed in a language
+func opdsFeedID(lang string) string {
+	if lang == defaultLanguage {
+		return "urn:personal-website:books"
+	}
+	return "urn:personal-website:books:" + lang
+}
+
+// buildOPDSFeed builds an OPDS 1.2 acquisition feed for the books library
+// in one language
+func buildOPDSFeed(books []Book, lang string) ([]byte, error) {
+	ui, err := loadUIStrings(lang)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := languagePrefix(lang)
+	feed := OPDSFeed{
+		Xmlns:    "http://www.w3.org/2005/Atom",
+		XmlnsDC:  "http://purl.org/dc/terms/",
+		XmlnsOPF: "http://www.idpf.org/2007/opf",
+		ID:       opdsFeedID(lang),
+		Title:    ui["books"],
+		Links: []OPDSLink{
+			{Rel: "self", Href: prefix + "/opds.xml", Type: opdsAcquisitionType},
+			{Rel: "start", Href: prefix + "/opds.xml", Type: opdsAcquisitionType},
+			{Rel: "alternate", Href: prefix + "/opds.json", Type: opdsJSONType},
+		},
+	}
+
+	var feedUpdated time.Time
+	for i := range books {
+		book := &books[i]
+		updated := bookUpdated(book)
+		if updated.After(feedUpdated) {
+			feedUpdated = updated
+		}
+
+		entry := OPDSEntry{
+			ID:        bookIdentifier(book),
+			Title:     book.Metadata.Title,
+			Updated:   updated.Format(time.RFC3339),
+			Language:  book.Metadata.Language,
+			Publisher: book.Metadata.Publisher,
+			Rights:    strings.TrimSpace(book.Metadata.CopyrightStatus() + " " + book.Metadata.License),
+			Summary:   book.Metadata.Description,
+			Links:     bookLinks(book),
+		}
+		for _, id := range book.Metadata.Identifiers {
+			entry.Identifiers = append(entry.Identifiers, identifierURN(id))
+		}
+		for _, subject := range book.Metadata.Subjects {
+			entry.Categories = append(entry.Categories, OPDSCategory{Term: subject, Label: subject})
+		}
+		for _, rel := range []string{opdsImageRel, opdsThumbnailRel} {
+			entry.Links = append(entry.Links, bookCoverLink(book, rel))
+		}
+		if book.Metadata.Author != "" {
+			entry.Authors = append(entry.Authors, OPDSPerson{Name: book.Metadata.Author})
+		}
+		for _, contributor := range []OPDSPerson{
+			{Role: "trl", Name: book.Metadata.Translator},
+			{Role: "edt", Name: book.Metadata.Editor},
+			{Role: "ill", Name: book.Metadata.Illustrator},
+		} {
+			if contributor.Name != "" {
+				entry.Contributors = append(entry.Contributors, contributor)
+			}
+		}
+		if book.Metadata.Year != 0 {
+			entry.Issued = strconv.Itoa(book.Metadata.Year)
+		}
+
+		feed.Entries = append(feed.Entries, entry)
+	}
+
+	if feedUpdated.IsZero() {
+		feedUpdated = time.Now().UTC()
+	}
+	feed.Updated = feedUpdated.Format(time.RFC3339)
+
+	data, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// buildOPDS2Feed builds an OPDS 2.0 JSON feed for the books library in
+// one language
+func buildOPDS2Feed(books []Book, lang string) ([]byte, error) {
+	ui, err := loadUIStrings(lang)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := languagePrefix(lang)
+	feed := OPDS2Feed{
+		Metadata: OPDS2FeedMetadata{Title: ui["books"]},
+		Links: []OPDSLink{
+			{Rel: "self", Href: prefix + "/opds.json", Type: opdsJSONType},
+			{Rel: "alternate", Href: prefix + "/opds.xml", Type: opdsAcquisitionType},
+		},
+		Publications: []OPDS2Publication{},
+	}
+
+	var feedUpdated time.Time
+	for i := range books {
+		book := &books[i]
+		updated := bookUpdated(book)
+		if updated.After(feedUpdated) {
+			feedUpdated = updated
+		}
+
+		metadata := OPDS2Metadata{
+			Type:        "http://schema.org/Book",
+			Identifier:  bookIdentifier(book),
+			Title:       book.Metadata.Title,
+			Subtitle:    book.Metadata.Subtitle,
+			Author:      book.Metadata.Author,
+			Translator:  book.Metadata.Translator,
+			Editor:      book.Metadata.Editor,
+			Illustrator: book.Metadata.Illustrator,
+			Modified:    updated.Format(time.RFC3339),
+			Language:    book.Metadata.Language,
+			Publisher:   book.Metadata.Publisher,
+			Subject:     book.Metadata.Subjects,
+			Description: book.Metadata.Description,
+		}
+		if book.Metadata.Year != 0 {
+			metadata.Published = strconv.Itoa(book.Metadata.Year)
+		}
+
+		publication := OPDS2Publication{
+			Metadata: metadata,
+			Links:    bookLinks(book),
+			Images:   []OPDSLink{bookCoverLink(book, "")},
+		}
+		feed.Publications = append(feed.Publications, publication)
+	}
+
+	if feedUpdated.IsZero() {
+		feedUpdated = time.Now().UTC()
+	}
+	feed.Metadata.Modified = feedUpdated.Format(time.RFC3339)
+
+	return json.MarshalIndent(feed, "", "  ")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestOPDSFeedsIdentifyBooksAlike(t *testing.T) {
+	inContentRoot(t, map[string]string{
+		"i18n/en.yaml": "books: Books\n",
+	})
+	books := []Book{
+		{Slug: "plain", Metadata: BookMetadata{Title: "Plain", Author: "A"}},
+		{Slug: "printed", Metadata: BookMetadata{
+			Title:       "Printed",
+			Author:      "A",
+			Translator:  "T",
+			Editor:      "E",
+			Illustrator: "I",
+			Identifiers: []BookIdentifier{{Scheme: "isbn", Value: "9780306406157"}},
+		}},
+	}
+
+	atom, err := buildOPDSFeed(books, defaultLanguage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := buildOPDS2Feed(books, defaultLanguage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var feed OPDS2Feed
+	if err := json.Unmarshal(data, &feed); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range books {
+		id := bookIdentifier(&books[i])
+		if !strings.Contains(string(atom), "<id>"+id+"</id>") {
+			t.Errorf("OPDS 1.2 feed has no entry with id %s:\n%s", id, atom)
+		}
+		if got := feed.Publications[i].Metadata.Identifier; got != id {
+			t.Errorf("OPDS 2 identifier = %s, want %s", got, id)
+		}
+	}
+	if id := bookIdentifier(&books[1]); !strings.HasPrefix(id, "urn:isbn:") {
+		t.Errorf("identifier of a book with an ISBN = %s", id)
+	}
+
+	for _, want := range []string{
+		`xmlns:opf="http://www.idpf.org/2007/opf"`,
+		`<contributor opf:role="trl">`,
+		`<contributor opf:role="edt">`,
+		`<contributor opf:role="ill">`,
+	} {
+		if !strings.Contains(string(atom), want) {
+			t.Errorf("OPDS 1.2 feed lacks %s:\n%s", want, atom)
+		}
+	}
+	metadata := feed.Publications[1].Metadata
+	if metadata.Translator != "T" || metadata.Editor != "E" || metadata.Illustrator != "I" {
+		t.Errorf("OPDS 2 contributors = %+v", metadata)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"html/template"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errMissingPassage is returned for a quoted passage that is no longer in
+// its book. A build fails on it rather than leaving the post out.
+var errMissingPassage = errors.New("quoted passage no longer exists")
+
+// ordinalRangePattern matches paragraph ranges given by position, e.g. 3 or
+// 3-5, counting the chapter's paragraphs and blockquotes from 1
+var ordinalRangePattern = regexp.MustCompile(`^(\d+)(?:-(\d+))?$`)
+
+// passageTemplate renders a quoted passage with its attribution
+var passageTemplate = template.Must(template.New("passage").Parse(`<figure class="passage">
+<blockquote cite="{{.URL}}" lang="{{.Lang}}">
+{{.Content}}
+</blockquote>
+<figcaption>&mdash; <a href="{{.URL}}">{{.Chapter}}</a>, <cite>{{.Book}}</cite>{{with .Author}} {{$.By}} {{.}}{{end}}</figcaption>
+</figure>
+`))
+
+// passageData is the data of passageTemplate
+type passageData struct {
+	Content template.HTML
+	URL     string
+	Chapter string
+	Book    string
+	Author  string
+	By      string // introduces the author, in the book's language
+	Lang    string
+}
+
+// renderPassage quotes paragraphs of a book chapter, linking back to where
+// they are:
+//
+//	{{< passage book="from-sepoy-to-subedar" chapter="the_retreat_from_kabul" paragraphs="3-5" >}}
+//
+// Paragraphs are given by position or, to survive edits to the chapter, by
+// their ids, e.g. paragraphs="p-0dd34590..p-2fefddef".
+func renderPassage(call ShortcodeCall) (template.HTML, error) {
+	bookSlug := cmp.Or(call.Get("book"), call.Param(0))
+	chapterSlug := cmp.Or(call.Get("chapter"), call.Param(1))
+	paragraphs := cmp.Or(call.Get("paragraphs"), call.Param(2))
+	if bookSlug == "" || chapterSlug == "" || paragraphs == "" {
+		return "", errors.New("passage needs a book, chapter and paragraphs")
+	}
+
+	book, err := loadBook(bookSlug)
+	if err != nil {
+		return "", fmt.Errorf("%w: book %s: %v", errMissingPassage, bookSlug, err)
+	}
+	var chapterInfo *ChapterInfo
+	for i := range book.Chapters {
+		if book.Chapters[i].Slug == chapterSlug {
+			chapterInfo = &book.Chapters[i]
+			break
+		}
+	}
+	if chapterInfo == nil {
+		return "", fmt.Errorf("%w: book %s has no chapter %s", errMissingPassage, bookSlug, chapterSlug)
+	}
+
+	content, id, err := chapterPassage(book, chapterSlug, paragraphs)
+	if err != nil {
+		return "", fmt.Errorf("%s/%s: %w", bookSlug, chapterSlug, err)
+	}
+
+	var buf bytes.Buffer
+	err = passageTemplate.Execute(&buf, passageData{
+		Content: content,
+		URL:     book.Path() + "/" + chapterSlug + "#" + id,
+		Chapter: chapterInfo.Title,
+		Book:    book.Metadata.Title,
+		Author:  book.Metadata.Author,
+		By:      uiString(book.Lang(), "passage_by"),
+		Lang:    book.Lang(),
+	})
+	return template.HTML(buf.String()), err
+}
+
+// chapterPassage returns the markup of a chapter from the start of the
+// first paragraph of a range to the end of the last, without footnote
+// references, and the id of the first paragraph
+func chapterPassage(book *Book, chapterSlug, paragraphs string) (template.HTML, string, error) {
+	content, err := readChapter(book, chapterSlug)
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", errMissingPassage, err)
+	}
+	tokens := tokenizeHTML(content)
+	ids := assignAnchorIDs(tokens, collectAnchoredBlocks(tokens))
+
+	var order []int
+	for i := range ids {
+		order = append(order, i)
+	}
+	sort.Ints(order)
+
+	// find returns the token index of the paragraph with an id, following
+	// the aliases of passages edited since the id was taken
+	find := func(id string) (int, error) {
+		if anchors := book.chapterAnchors(chapterSlug); anchors != nil {
+			if alias, ok := anchors.Aliases[id]; ok {
+				id = alias
+			}
+		}
+		for _, i := range order {
+			if ids[i].ID == id {
+				return i, nil
+			}
+		}
+		return 0, fmt.Errorf("%w: no paragraph %s", errMissingPassage, id)
+	}
+
+	var first, last int
+	if m := ordinalRangePattern.FindStringSubmatch(paragraphs); m != nil {
+		from, _ := strconv.Atoi(m[1])
+		to := from
+		if m[2] != "" {
+			to, _ = strconv.Atoi(m[2])
+		}
+		if from < 1 || to > len(order) {
+			return "", "", fmt.Errorf("%w: no paragraphs %s, the chapter has %d", errMissingPassage, paragraphs, len(order))
+		}
+		if to < from {
+			return "", "", fmt.Errorf("paragraph range %s is backwards", paragraphs)
+		}
+		first, last = order[from-1], order[to-1]
+	} else {
+		fromID, toID, ok := strings.Cut(paragraphs, "..")
+		if !ok {
+			toID = fromID
+		}
+		if first, err = find(strings.TrimSpace(fromID)); err != nil {
+			return "", "", err
+		}
+		if last, err = find(strings.TrimSpace(toID)); err != nil {
+			return "", "", err
+		}
+		if last < first {
+			return "", "", fmt.Errorf("paragraph range %s is backwards", paragraphs)
+		}
+	}
+
+	// The passage ends with the element closing the last paragraph
+	end := len(tokens) - 1
+	depth := 0
+	for i := last; i < len(tokens); i++ {
+		if tokens[i].Name != tokens[last].Name {
+			continue
+		}
+		if tokens[i].Kind == startTagToken {
+			depth++
+		} else if tokens[i].Kind == endTagToken {
+			depth--
+		}
+		if depth == 0 {
+			end = i
+			break
+		}
+	}
+
+	// Footnote references would point at notes the post doesn't have
+	var out strings.Builder
+	skipDepth := 0
+	for _, tok := range tokens[first : end+1] {
+		switch {
+		case tok.isNoteRef():
+			skipDepth++
+		case skipDepth > 0 && tok.Kind == startTagToken && tok.Name == "a":
+			skipDepth++
+		case skipDepth > 0 && tok.Kind == endTagToken && tok.Name == "a":
+			skipDepth--
+		case skipDepth == 0:
+			out.WriteString(tok.Raw)
+		}
+	}
+	return template.HTML(out.String()), ids[first].ID, nil
+}
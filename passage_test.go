@@ -0,0 +1,167 @@
+package main
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const passageChapter = `<h1>One</h1>
+<p>The first paragraph opens the chapter.</p>
+<p>The second paragraph has a note.<a href="#footnote1" epub:type="noteref"><sup>1</sup></a></p>
+<blockquote>A quoted third paragraph.</blockquote>
+<p>The fourth paragraph closes it.</p>
+<aside id="footnote1" epub:type="footnote"><p>1. The note.</p></aside>
+`
+
+// passageIDs returns the ids of the paragraphs of passageChapter, in order
+func passageIDs(t *testing.T) []string {
+	t.Helper()
+	tokens := tokenizeHTML(passageChapter)
+	anchors := assignAnchorIDs(tokens, collectAnchoredBlocks(tokens))
+	var order []int
+	for i := range anchors {
+		order = append(order, i)
+	}
+	sort.Ints(order)
+	var ids []string
+	for _, i := range order {
+		ids = append(ids, anchors[i].ID)
+	}
+	return ids
+}
+
+func TestChapterPassage(t *testing.T) {
+	inContentRoot(t, map[string]string{
+		"books/b/chapters/one.xhtml": passageChapter,
+	})
+	ids := passageIDs(t)
+	if len(ids) != 4 {
+		t.Fatalf("ids = %q, want 4", ids)
+	}
+	book := &Book{Slug: "b", Anchors: &AnchorManifest{Chapters: map[string]*ChapterAnchors{
+		"one": {Aliases: map[string]string{"p-edited": ids[2]}},
+	}}}
+
+	tests := []struct {
+		name       string
+		paragraphs string
+		want       []string // in the passage
+		notWant    []string
+		wantID     string
+		missing    bool // fails with errMissingPassage
+		wantErr    bool // fails otherwise
+	}{
+		{name: "one by position", paragraphs: "1", want: []string{"first paragraph"}, notWant: []string{"second", "<h1>"}, wantID: ids[0]},
+		{name: "range by position", paragraphs: "2-3", want: []string{"second paragraph", "quoted third"}, notWant: []string{"first", "fourth"}, wantID: ids[1]},
+		{name: "whole chapter", paragraphs: "1-4", want: []string{"first", "fourth"}, notWant: []string{"The note."}, wantID: ids[0]},
+		{name: "blockquote closes with it", paragraphs: "3", want: []string{"<blockquote>", "</blockquote>"}, notWant: []string{"fourth"}, wantID: ids[2]},
+		{name: "position zero", paragraphs: "0", missing: true},
+		{name: "past the end", paragraphs: "5", missing: true},
+		{name: "range past the end", paragraphs: "3-9", missing: true},
+		{name: "backwards by position", paragraphs: "3-1", wantErr: true},
+		{name: "one by id", paragraphs: ids[1], want: []string{"second paragraph"}, notWant: []string{"first", "quoted"}, wantID: ids[1]},
+		{name: "range by id", paragraphs: ids[1] + ".." + ids[3], want: []string{"second", "quoted third", "fourth"}, wantID: ids[1]},
+		{name: "spaces around ids", paragraphs: ids[0] + " .. " + ids[1], want: []string{"first", "second"}, wantID: ids[0]},
+		{name: "id through an alias", paragraphs: "p-edited", want: []string{"quoted third"}, wantID: ids[2]},
+		{name: "range ending at an alias", paragraphs: ids[0] + "..p-edited", want: []string{"first", "quoted third"}, notWant: []string{"fourth"}, wantID: ids[0]},
+		{name: "unknown id", paragraphs: "p-gone", missing: true},
+		{name: "range to an unknown id", paragraphs: ids[0] + "..p-gone", missing: true},
+		{name: "backwards by id", paragraphs: ids[3] + ".." + ids[0], wantErr: true},
+		{name: "footnote reference stripped", paragraphs: "2", want: []string{"has a note.</p>"}, notWant: []string{"noteref", "<sup>", "#footnote1"}, wantID: ids[1]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, id, err := chapterPassage(book, "one", tt.paragraphs)
+			switch {
+			case tt.missing:
+				if !errors.Is(err, errMissingPassage) {
+					t.Fatalf("error = %v, want errMissingPassage", err)
+				}
+				return
+			case tt.wantErr:
+				if err == nil || errors.Is(err, errMissingPassage) {
+					t.Fatalf("error = %v, want an error other than errMissingPassage", err)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+			if id != tt.wantID {
+				t.Errorf("id = %s, want %s", id, tt.wantID)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(content), want) {
+					t.Errorf("passage lacks %q:\n%s", want, content)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(string(content), notWant) {
+					t.Errorf("passage has %q:\n%s", notWant, content)
+				}
+			}
+		})
+	}
+
+	if _, _, err := chapterPassage(book, "gone", "1"); !errors.Is(err, errMissingPassage) {
+		t.Errorf("passage of a missing chapter: error = %v, want errMissingPassage", err)
+	}
+}
+
+func TestRenderPassage(t *testing.T) {
+	inContentRoot(t, map[string]string{
+		"books/b/metadata.yaml":      "title: The Book\nauthor: A. Author\n",
+		"books/b/chapters.yaml":      "chapters:\n  - slug: one\n    title: Chapter One\n",
+		"books/b/chapters/one.xhtml": passageChapter,
+		"books/h/metadata.yaml":      "title: H\nauthor: A. Author\nlanguage: hi\n",
+		"books/h/chapters.yaml":      "chapters:\n  - slug: one\n    title: One\n",
+		"books/h/chapters/one.xhtml": passageChapter,
+		"i18n/en.yaml":               "passage_by: by\n",
+		"i18n/hi.yaml":               "passage_by: \"लेखक:\"\n",
+	})
+	ids := passageIDs(t)
+
+	tests := []struct {
+		name    string
+		call    ShortcodeCall
+		want    []string
+		missing bool
+		wantErr bool
+	}{
+		{name: "named", call: ShortcodeCall{Args: map[string]string{"book": "b", "chapter": "one", "paragraphs": "2"}},
+			want: []string{`<blockquote cite="/book/b/one#` + ids[1] + `"`, "second paragraph", `<a href="/book/b/one#` + ids[1] + `">Chapter One</a>`, "<cite>The Book</cite> by A. Author"}},
+		{name: "in the book's language", call: ShortcodeCall{Args: map[string]string{"book": "h", "chapter": "one", "paragraphs": "1"}},
+			want: []string{`lang="hi"`, `<a href="/hi/book/h/one#`, "<cite>H</cite> लेखक: A. Author"}},
+		{name: "positional", call: ShortcodeCall{Args: map[string]string{}, Params: []string{"b", "one", "1-2"}},
+			want: []string{"first paragraph", "second paragraph"}},
+		{name: "no paragraphs", call: ShortcodeCall{Args: map[string]string{"book": "b", "chapter": "one"}}, wantErr: true},
+		{name: "unknown book", call: ShortcodeCall{Args: map[string]string{"book": "x", "chapter": "one", "paragraphs": "1"}}, missing: true},
+		{name: "unknown chapter", call: ShortcodeCall{Args: map[string]string{"book": "b", "chapter": "two", "paragraphs": "1"}}, missing: true},
+		{name: "unknown paragraph", call: ShortcodeCall{Args: map[string]string{"book": "b", "chapter": "one", "paragraphs": "9"}}, missing: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := renderPassage(tt.call)
+			switch {
+			case tt.missing:
+				if !errors.Is(err, errMissingPassage) {
+					t.Fatalf("error = %v, want errMissingPassage", err)
+				}
+				return
+			case tt.wantErr:
+				if err == nil || errors.Is(err, errMissingPassage) {
+					t.Fatalf("error = %v, want an error other than errMissingPassage", err)
+				}
+				return
+			case err != nil:
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(out), want) {
+					t.Errorf("passage lacks %q:\n%s", want, out)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// BuildReport records what a build generated, what it left out and how long
+// each step took. It is printed after the build and can be written as JSON.
+type BuildReport struct {
+	Started   time.Time   `json:"started"`
+	Duration  float64     `json:"duration_seconds"`
+	Output    string      `json:"output"`
+	Pages     int         `json:"pages"`
+	Generated []string    `json:"generated"` // paths under the output directory
+	Skipped   []BuildSkip `json:"skipped"`
+	Warnings  []string    `json:"warnings"`
+	Steps     []BuildStep `json:"steps"`
+}
+
+// BuildSkip is content left out of the site because it failed to load
+type BuildSkip struct {
+	Kind   string `json:"kind"` // post, book or chapter
+	Slug   string `json:"slug"`
+	Reason string `json:"reason"`
+	err    error
+}
+
+// BuildStep is the time a step of the build took
+type BuildStep struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+}
+
+// report collects the report of the build in progress. It is nil outside a
+// build, when recording does nothing.
+var report *BuildReport
+
+func newBuildReport(output string) *BuildReport {
+	return &BuildReport{
+		Started:   time.Now(),
+		Output:    output,
+		Generated: []string{},
+		Skipped:   []BuildSkip{},
+		Warnings:  []string{},
+		Steps:     []BuildStep{},
+	}
+}
+
+// generated records a file written to the output directory
+func (r *BuildReport) generated(path string) {
+	if r == nil {
+		return
+	}
+	if rel, err := filepath.Rel(r.Output, path); err == nil {
+		path = filepath.ToSlash(rel)
+	}
+	if strings.HasSuffix(path, ".html") {
+		r.Pages++
+	}
+	r.Generated = append(r.Generated, path)
+}
+
+// skip records content that failed to load
+func (r *BuildReport) skip(kind, slug string, err error) {
+	if r == nil {
+		return
+	}
+	r.Skipped = append(r.Skipped, BuildSkip{Kind: kind, Slug: slug, Reason: err.Error(), err: err})
+}
+
+// warn logs a problem that didn't stop the build and records it
+func (r *BuildReport) warn(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Print(msg)
+	if r == nil {
+		return
+	}
+	r.Warnings = append(r.Warnings, msg)
+}
+
+// step records that a step of the build, begun at start, is done
+func (r *BuildReport) step(name string, start time.Time) {
+	if r == nil {
+		return
+	}
+	r.Steps = append(r.Steps, BuildStep{Name: name, Seconds: time.Since(start).Seconds()})
+}
+
+// finish records the duration of the whole build
+func (r *BuildReport) finish() {
+	r.Duration = time.Since(r.Started).Seconds()
+}
+
+// problems returns how much content was skipped and how many warnings
+// there were
+func (r *BuildReport) problems() int {
+	return len(r.Skipped) + len(r.Warnings)
+}
+
+// writeText writes the report for people to read
+func (r *BuildReport) writeText(w io.Writer) {
+	fmt.Fprintf(w, "\nBuild report for %s\n", r.Output)
+	fmt.Fprintf(w, "  %d pages, %d files generated in %s\n", r.Pages, len(r.Generated), seconds(r.Duration))
+
+	if len(r.Skipped) > 0 {
+		fmt.Fprintf(w, "\nSkipped (%d):\n", len(r.Skipped))
+		for _, s := range r.Skipped {
+			fmt.Fprintf(w, "  %s %s: %s\n", s.Kind, s.Slug, s.Reason)
+		}
+	}
+	if len(r.Warnings) > 0 {
+		fmt.Fprintf(w, "\nWarnings (%d):\n", len(r.Warnings))
+		for _, warning := range r.Warnings {
+			fmt.Fprintf(w, "  %s\n", warning)
+		}
+	}
+
+	fmt.Fprintf(w, "\nTimings:\n")
+	for _, s := range r.Steps {
+		fmt.Fprintf(w, "  %-10s %s\n", s.Name, seconds(s.Seconds))
+	}
+}
+
+// writeJSON writes the report as JSON to path
+func (r *BuildReport) writeJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// seconds formats a duration given in seconds
+func seconds(s float64) string {
+	return time.Duration(s * float64(time.Second)).Round(time.Millisecond).String()
+}
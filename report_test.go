@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildReportCounts(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// Recording outside a build does nothing
+	var none *BuildReport
+	none.generated("public/index.html")
+	none.skip("post", "p", errors.New("bad front matter"))
+	none.warn("ignored")
+	none.step("posts", time.Now())
+
+	out := t.TempDir()
+	r := newBuildReport(out)
+	r.generated(filepath.Join(out, "index.html"))
+	r.generated(filepath.Join(out, "post", "p", "index.html"))
+	r.generated(filepath.Join(out, "feed.xml"))
+	r.skip("chapter", "b/one", errors.New("no such file"))
+	r.warn("Error copying %s: %v", "cover.jpg", "denied")
+	r.step("posts", time.Now())
+	r.finish()
+
+	if r.Pages != 2 {
+		t.Errorf("Pages = %d, want 2", r.Pages)
+	}
+	if want := []string{"index.html", "post/p/index.html", "feed.xml"}; !slices.Equal(r.Generated, want) {
+		t.Errorf("Generated = %q, want %q", r.Generated, want)
+	}
+	if r.problems() != 2 {
+		t.Errorf("problems() = %d, want 2", r.problems())
+	}
+
+	var text strings.Builder
+	r.writeText(&text)
+	for _, want := range []string{
+		"2 pages, 3 files generated",
+		"Skipped (1):\n  chapter b/one: no such file",
+		"Warnings (1):\n  Error copying cover.jpg: denied",
+		"posts",
+	} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text report lacks %q:\n%s", want, text.String())
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "report.json")
+	if err := r.writeJSON(path); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written BuildReport
+	if err := json.Unmarshal(data, &written); err != nil {
+		t.Fatal(err)
+	}
+	if written.Pages != 2 || len(written.Generated) != 3 || len(written.Skipped) != 1 || len(written.Warnings) != 1 || len(written.Steps) != 1 {
+		t.Errorf("JSON report doesn't match:\n%s", data)
+	}
+}
+
+func TestFinishReportStrict(t *testing.T) {
+	// Keep the printed report out of the test output
+	stdout := os.Stdout
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer devNull.Close()
+	os.Stdout = devNull
+	defer func() { os.Stdout = stdout }()
+	defer func(r *BuildReport) { report = r }(report)
+
+	tests := []struct {
+		name     string
+		skipped  int
+		warnings int
+		strict   bool
+		wantErr  bool
+	}{
+		{"clean", 0, 0, false, false},
+		{"clean, strict", 0, 0, true, false},
+		{"problems", 1, 1, false, false},
+		{"skipped, strict", 1, 0, true, true},
+		{"warning, strict", 0, 1, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report = newBuildReport(t.TempDir())
+			for i := 0; i < tt.skipped; i++ {
+				report.Skipped = append(report.Skipped, BuildSkip{Kind: "post", Slug: "p", Reason: "bad"})
+			}
+			for i := 0; i < tt.warnings; i++ {
+				report.Warnings = append(report.Warnings, "careful")
+			}
+
+			err := finishReport(&config{Strict: tt.strict})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("finishReport() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
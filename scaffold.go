@@ -0,0 +1,298 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// scaffoldSlug returns the slug to create content under, made from the
+// title with words joined by sep unless one was given
+func scaffoldSlug(slug, title, sep string) (string, error) {
+	if slug == "" {
+		slug = strings.ReplaceAll(slugify(title), "-", sep)
+	}
+	if slug == "" {
+		return "", fmt.Errorf("can't make a slug from %q; pass one with -slug", title)
+	}
+	if slug != filepath.Base(slug) || strings.HasPrefix(slug, ".") {
+		return "", fmt.Errorf("slug %q must be a single path segment", slug)
+	}
+	return slug, nil
+}
+
+// today returns the current date as midnight UTC
+func today() time.Time {
+	y, m, d := time.Now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// writeScaffold creates files relative to dir, which must not exist yet
+func writeScaffold(dir string, files map[string]string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("%s already exists", dir)
+	}
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(files[name]), 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Created: %s\n", path)
+	}
+	return nil
+}
+
+// runNewPost creates blogs/<slug>/ with metadata.yaml and index.md
+func runNewPost(cfg *config, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	title := args[0]
+
+	s, err := scaffoldSlug(cfg.Slug, title, "-")
+	if err != nil {
+		return err
+	}
+	if cfg.Lang != "" && !languagePattern.MatchString(cfg.Lang) {
+		return fmt.Errorf("language %q is not a BCP 47 language tag", cfg.Lang)
+	}
+	if cfg.TranslationOf != "" {
+		if _, err := os.Stat(filepath.Join("blogs", cfg.TranslationOf, "metadata.yaml")); err != nil {
+			return fmt.Errorf("no post %s to translate", cfg.TranslationOf)
+		}
+	}
+
+	var metadata strings.Builder
+	fmt.Fprintf(&metadata, "title: %s\n", strconv.Quote(title))
+	fmt.Fprintf(&metadata, "date: %s\n", today().Format(time.RFC3339))
+	fmt.Fprintf(&metadata, "description: \"\"\n")
+	fmt.Fprintf(&metadata, "tags: []\n")
+	if cfg.Lang != "" && cfg.Lang != defaultLanguage {
+		fmt.Fprintf(&metadata, "language: %s\n", strconv.Quote(cfg.Lang))
+	}
+	if cfg.TranslationOf != "" {
+		fmt.Fprintf(&metadata, "translation_of: %s\n", strconv.Quote(cfg.TranslationOf))
+	}
+
+	return writeScaffold(filepath.Join("blogs", s), map[string]string{
+		"metadata.yaml": metadata.String(),
+		"index.md":      "# " + title + "\n\n",
+	})
+}
+
+// runNewBook creates books/<slug>/ with metadata.yaml and an empty
+// chapters.yaml
+func runNewBook(cfg *config, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	title := args[0]
+
+	s, err := scaffoldSlug(cfg.Slug, title, "-")
+	if err != nil {
+		return err
+	}
+	lang := cfg.Lang
+	if lang == "" {
+		lang = defaultLanguage
+	}
+	if !languagePattern.MatchString(lang) {
+		return fmt.Errorf("language %q is not a BCP 47 language tag", lang)
+	}
+	if cfg.TranslationOf != "" {
+		if _, err := os.Stat(filepath.Join("books", cfg.TranslationOf, "metadata.yaml")); err != nil {
+			return fmt.Errorf("no book %s to translate", cfg.TranslationOf)
+		}
+	}
+
+	var metadata strings.Builder
+	fmt.Fprintf(&metadata, "title: %s\n", strconv.Quote(title))
+	fmt.Fprintf(&metadata, "author: %s\n", strconv.Quote(cfg.Author))
+	fmt.Fprintf(&metadata, "year: %d\n", today().Year())
+	fmt.Fprintf(&metadata, "description: \"\"\n")
+	fmt.Fprintf(&metadata, "language: %s\n", strconv.Quote(lang))
+	fmt.Fprintf(&metadata, "subjects: []\n")
+	if cfg.TranslationOf != "" {
+		fmt.Fprintf(&metadata, "translation_of: %s\n", strconv.Quote(cfg.TranslationOf))
+	}
+
+	return writeScaffold(filepath.Join("books", s), map[string]string{
+		"metadata.yaml": metadata.String(),
+		"chapters.yaml": "chapters: []\n",
+	})
+}
+
+// runNewChapter adds a chapter to a book's chapters.yaml and creates its
+// XHTML file. The chapter goes after the book's last page unless -after or
+// -before names the entry to place it next to.
+func runNewChapter(cfg *config, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	bookSlug, title := args[0], args[1]
+	if cfg.After != "" && cfg.Before != "" {
+		return fmt.Errorf("pass only one of -after and -before")
+	}
+
+	book, err := loadBook(bookSlug)
+	if err != nil {
+		return fmt.Errorf("book %s: %w", bookSlug, err)
+	}
+	s, err := scaffoldSlug(cfg.Slug, title, "_")
+	if err != nil {
+		return err
+	}
+	if _, err := flattenChapters([]ChapterInfo{{Slug: s, Title: title}}); err != nil {
+		return err
+	}
+	for _, ch := range book.Chapters {
+		if ch.Slug == s {
+			return fmt.Errorf("book %s already has a chapter %s", bookSlug, s)
+		}
+	}
+	chapterPath := filepath.Join("books", bookSlug, "chapters", s+".xhtml")
+	if _, err := os.Stat(chapterPath); err == nil {
+		return fmt.Errorf("%s already exists", chapterPath)
+	}
+
+	chaptersPath := filepath.Join("books", bookSlug, "chapters.yaml")
+	data, err := os.ReadFile(chaptersPath)
+	if err != nil {
+		return err
+	}
+	data, err = insertChapter(data, ChapterInfo{Slug: s, Title: title}, cfg.After, cfg.Before)
+	if err != nil {
+		return fmt.Errorf("%s: %w", chaptersPath, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(chapterPath), 0755); err != nil {
+		return err
+	}
+	content := "<h1>" + template.HTMLEscapeString(title) + "</h1>\n\n<p></p>\n"
+	if err := os.WriteFile(chapterPath, []byte(content), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Created: %s\n", chapterPath)
+
+	if err := os.WriteFile(chaptersPath, data, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Updated: %s\n", chaptersPath)
+	return nil
+}
+
+// insertChapter adds an entry to the chapters.yaml document in data,
+// editing the text in place so that the rest of the file keeps its layout
+func insertChapter(data []byte, entry ChapterInfo, after, before string) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	chapters := chaptersSequence(&doc)
+	if chapters == nil {
+		return nil, errors.New("no chapters list")
+	}
+
+	// An empty list is replaced outright
+	if len(chapters.Content) == 0 {
+		lines := strings.Split(string(data), "\n")
+		lines[chapters.Line-1] = "chapters:\n" + strings.TrimSuffix(chapterEntryText(entry, 0), "\n")
+		return []byte(strings.Join(lines, "\n")), nil
+	}
+
+	// Find the entry to insert next to, by default the last page
+	var ref *yaml.Node
+	target := after
+	if before != "" {
+		target = before
+	}
+	var walk func(seq *yaml.Node)
+	walk = func(seq *yaml.Node) {
+		for _, item := range seq.Content {
+			slug := mappingValue(item, "slug")
+			if (target == "" && slug != nil) || (slug != nil && slug.Value == target) {
+				ref = item
+			}
+			if children := mappingValue(item, "chapters"); children != nil && children.Kind == yaml.SequenceNode {
+				walk(children)
+			}
+		}
+	}
+	walk(chapters)
+	if ref == nil {
+		if target == "" {
+			return nil, errors.New("no chapters to insert after; use -after or -before")
+		}
+		return nil, fmt.Errorf("no chapter %s", target)
+	}
+
+	// Entries are written at the indentation of the one they go next to
+	text := chapterEntryText(entry, ref.Column-3)
+	lines := strings.Split(string(data), "\n")
+	at := ref.Line - 1
+	if before == "" {
+		at = lastLine(ref)
+	}
+	lines = append(lines[:at], append([]string{strings.TrimSuffix(text, "\n")}, lines[at:]...)...)
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// chaptersSequence returns the chapters list of a chapters.yaml document
+func chaptersSequence(doc *yaml.Node) *yaml.Node {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		return nil
+	}
+	seq := mappingValue(doc.Content[0], "chapters")
+	if seq == nil || seq.Kind != yaml.SequenceNode {
+		return nil
+	}
+	return seq
+}
+
+// mappingValue returns the value of key in a mapping node
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// lastLine returns the last line a node and its children are on
+func lastLine(node *yaml.Node) int {
+	last := node.Line
+	for _, child := range node.Content {
+		if l := lastLine(child); l > last {
+			last = l
+		}
+	}
+	return last
+}
+
+// chapterEntryText returns the chapters.yaml text of an entry whose dash
+// is indented by indent spaces
+func chapterEntryText(entry ChapterInfo, indent int) string {
+	pad := strings.Repeat(" ", indent)
+	return fmt.Sprintf("%s- slug: %s\n%s  title: %s\n", pad, entry.Slug, pad, strconv.Quote(entry.Title))
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestInsertChapter(t *testing.T) {
+	const flat = `# Reading order
+chapters:
+  - slug: one
+    title: "One"
+  - slug: two
+    title: "Two"
+`
+	const nested = `chapters:
+- slug: part_one
+  title: "Part One"
+  type: bodymatter
+  chapters:
+  - slug: one
+    title: "One"
+  - slug: two
+    title: "Two"
+- slug: epilogue
+  title: "Epilogue"
+`
+	entry := ChapterInfo{Slug: "new", Title: `A "New" Chapter`}
+
+	tests := []struct {
+		name          string
+		data          string
+		after, before string
+		want          string
+	}{
+		{"at the end", flat, "", "", `# Reading order
+chapters:
+  - slug: one
+    title: "One"
+  - slug: two
+    title: "Two"
+  - slug: new
+    title: "A \"New\" Chapter"
+`},
+		{"after", flat, "one", "", `# Reading order
+chapters:
+  - slug: one
+    title: "One"
+  - slug: new
+    title: "A \"New\" Chapter"
+  - slug: two
+    title: "Two"
+`},
+		{"before", flat, "", "one", `# Reading order
+chapters:
+  - slug: new
+    title: "A \"New\" Chapter"
+  - slug: one
+    title: "One"
+  - slug: two
+    title: "Two"
+`},
+		{"into a part", nested, "one", "", `chapters:
+- slug: part_one
+  title: "Part One"
+  type: bodymatter
+  chapters:
+  - slug: one
+    title: "One"
+  - slug: new
+    title: "A \"New\" Chapter"
+  - slug: two
+    title: "Two"
+- slug: epilogue
+  title: "Epilogue"
+`},
+		{"after a part", nested, "part_one", "", `chapters:
+- slug: part_one
+  title: "Part One"
+  type: bodymatter
+  chapters:
+  - slug: one
+    title: "One"
+  - slug: two
+    title: "Two"
+- slug: new
+  title: "A \"New\" Chapter"
+- slug: epilogue
+  title: "Epilogue"
+`},
+		{"empty list", "chapters: []\n", "", "", `chapters:
+- slug: new
+  title: "A \"New\" Chapter"
+`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := insertChapter([]byte(tt.data), entry, tt.after, tt.before)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got\n%s\nwant\n%s", got, tt.want)
+			}
+
+			// The result must still be a valid chapters.yaml
+			var config ChaptersConfig
+			if err := yaml.Unmarshal(got, &config); err != nil {
+				t.Fatalf("result doesn't parse: %v", err)
+			}
+			chapters, err := flattenChapters(config.Chapters)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, ch := range chapters {
+				found = found || (ch.Slug == entry.Slug && ch.Title == entry.Title)
+			}
+			if !found {
+				t.Errorf("inserted chapter missing from %+v", chapters)
+			}
+		})
+	}
+}
+
+func TestInsertChapterErrors(t *testing.T) {
+	entry := ChapterInfo{Slug: "new", Title: "New"}
+	tests := []struct {
+		name          string
+		data          string
+		after, before string
+	}{
+		{"unknown chapter to follow", "chapters:\n  - slug: one\n    title: One\n", "nope", ""},
+		{"unknown chapter to precede", "chapters:\n  - slug: one\n    title: One\n", "", "nope"},
+		{"no chapters list", "title: Book\n", "", ""},
+		{"not yaml", "chapters: [\n", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := insertChapter([]byte(tt.data), entry, tt.after, tt.before); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
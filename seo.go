@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+	"strings"
+	"time"
+)
+
+const (
+	siteName = "Sashank Tirumala's Blog"
+
+	// defaultSocialImage is shown in link previews of pages without their own image
+	defaultSocialImage = "/static/images/social-default.png"
+
+	// descriptionLength is the longest description put in page metadata
+	descriptionLength = 200
+)
+
+// SEOMetadata represents the link preview and structured data of a page
+type SEOMetadata struct {
+	SiteName    string
+	Title       string
+	Description string
+	URL         string
+	Image       string
+	Type        string // OpenGraph type: "website", "article" or "book"
+	Published   time.Time
+	Tags        []string
+	Alternates  []SEOAlternate
+	JSONLD      template.JS
+}
+
+// SEOAlternate is a version of a page in another language, for hreflang links
+type SEOAlternate struct {
+	Language string
+	URL      string
+}
+
+// jsonLD is a schema.org JSON-LD object
+type jsonLD map[string]interface{}
+
+func schemaPerson(name string) jsonLD {
+	return jsonLD{"@type": "Person", "name": name}
+}
+
+// bookJSONLD describes a book as a schema.org Book
+func bookJSONLD(book *Book) jsonLD {
+	ld := jsonLD{
+		"@type": "Book",
+		"name":  book.Metadata.Title,
+		"url":   absoluteURL(book.Path() + "/"),
+	}
+	if book.Metadata.Subtitle != "" {
+		ld["alternativeHeadline"] = book.Metadata.Subtitle
+	}
+	if book.Metadata.Author != "" {
+		ld["author"] = schemaPerson(book.Metadata.Author)
+	}
+	if book.Metadata.Translator != "" {
+		ld["translator"] = schemaPerson(book.Metadata.Translator)
+	}
+	if book.Metadata.Editor != "" {
+		ld["editor"] = schemaPerson(book.Metadata.Editor)
+	}
+	if book.Metadata.Illustrator != "" {
+		ld["illustrator"] = schemaPerson(book.Metadata.Illustrator)
+	}
+	if book.Metadata.Year != 0 {
+		ld["datePublished"] = book.Metadata.Year
+	}
+	if book.Metadata.Description != "" {
+		ld["description"] = book.Metadata.Description
+	}
+	if book.Metadata.Language != "" {
+		ld["inLanguage"] = book.Metadata.Language
+	}
+	if book.Metadata.Publisher != "" {
+		ld["publisher"] = jsonLD{"@type": "Organization", "name": book.Metadata.Publisher}
+	}
+	if book.Metadata.Edition != "" {
+		ld["bookEdition"] = book.Metadata.Edition
+	}
+	if isbn := book.Metadata.ISBN(); isbn != "" {
+		ld["isbn"] = isbn
+	}
+	if len(book.Metadata.Subjects) > 0 {
+		ld["keywords"] = strings.Join(book.Metadata.Subjects, ", ")
+	}
+	if url := book.CoverImageURL(); url != "" {
+		ld["image"] = absoluteURL(url)
+	} else {
+		ld["image"] = absoluteURL(book.CoverURL())
+	}
+	return ld
+}
+
+// pageSEO derives the link preview and structured data of a page from the
+// content it shows. path is the site path the page is served at.
+func pageSEO(data PageData, path string) SEOMetadata {
+	seo := SEOMetadata{
+		SiteName: siteName,
+		Title:    data.Title,
+		URL:      absoluteURL(path),
+		Image:    absoluteURL(defaultSocialImage),
+		Type:     "website",
+	}
+	ld := jsonLD{
+		"@type": "WebPage",
+		"name":  data.Title,
+		"url":   seo.URL,
+	}
+
+	switch {
+	case data.Chapter != nil && data.Book != nil:
+		book, chapter := data.Book, data.Chapter
+		seo.URL = absoluteURL(book.Path() + "/" + chapter.ChapterSlug)
+		seo.Type = "article"
+		if url := book.CoverImageURL(); url != "" {
+			seo.Image = absoluteURL(url)
+		}
+		// Chapters usually open by repeating their title as a heading
+		text := strings.TrimSpace(strings.TrimPrefix(plainText(string(chapter.Content)), chapter.Title))
+		seo.Description = truncateWords(text, descriptionLength)
+		ld = jsonLD{
+			"@type":    "Chapter",
+			"name":     chapter.Title,
+			"url":      seo.URL,
+			"isPartOf": bookJSONLD(book),
+		}
+		if book.Metadata.Author != "" {
+			ld["author"] = schemaPerson(book.Metadata.Author)
+		}
+		if book.Metadata.Translator != "" {
+			ld["translator"] = schemaPerson(book.Metadata.Translator)
+		}
+		for i, ch := range book.Chapters {
+			if ch.Slug == chapter.ChapterSlug {
+				ld["position"] = i + 1
+				if ch.Pages != "" {
+					ld["pagination"] = ch.Pages
+				}
+			}
+		}
+
+	case data.Book != nil:
+		book := data.Book
+		seo.Type = "book"
+		seo.Description = book.Metadata.Description
+		seo.Tags = book.Metadata.Subjects
+		if strings.TrimSuffix(path, "/") == book.Path() {
+			seo.URL = absoluteURL(book.Path() + "/")
+		}
+		if url := book.CoverImageURL(); url != "" {
+			seo.Image = absoluteURL(url)
+		}
+		ld = bookJSONLD(book)
+		ld["url"] = seo.URL
+
+	case data.Post != nil:
+		post := data.Post
+		seo.URL = absoluteURL(post.Path())
+		seo.Type = "article"
+		if url := post.SocialImageURL(); url != "" {
+			seo.Image = absoluteURL(url)
+		}
+		seo.Description = truncateWords(post.Excerpt, descriptionLength)
+		seo.Published = post.Metadata.Date
+		seo.Tags = post.Metadata.Tags
+		ld = jsonLD{
+			"@type":         "BlogPosting",
+			"headline":      post.Metadata.Title,
+			"url":           seo.URL,
+			"datePublished": post.Metadata.Date.Format(time.RFC3339),
+			"author":        schemaPerson(siteAuthor),
+			"image":         seo.Image,
+		}
+		if seo.Description != "" {
+			ld["description"] = seo.Description
+		}
+		if len(post.Metadata.Tags) > 0 {
+			ld["keywords"] = strings.Join(post.Metadata.Tags, ", ")
+		}
+
+	case data.Content != "":
+		seo.Description = truncateWords(plainText(string(data.Content)), descriptionLength)
+	}
+
+	if len(data.Translations) > 0 {
+		seo.Alternates = append(seo.Alternates, SEOAlternate{Language: data.Lang, URL: seo.URL})
+		for _, t := range data.Translations {
+			seo.Alternates = append(seo.Alternates, SEOAlternate{Language: t.Language, URL: absoluteURL(t.Path)})
+		}
+		for _, alt := range seo.Alternates {
+			if alt.Language == defaultLanguage {
+				seo.Alternates = append(seo.Alternates, SEOAlternate{Language: "x-default", URL: alt.URL})
+				break
+			}
+		}
+	}
+	if _, ok := ld["inLanguage"]; !ok && data.Lang != "" {
+		ld["inLanguage"] = data.Lang
+	}
+
+	if seo.Description != "" {
+		if _, ok := ld["description"]; !ok {
+			ld["description"] = seo.Description
+		}
+	}
+	ld["@context"] = "https://schema.org"
+
+	if encoded, err := json.Marshal(ld); err == nil {
+		seo.JSONLD = template.JS(encoded)
+	}
+	return seo
+}
+
+// withSEO fills in the SEO metadata of template data that doesn't have any
+func withSEO(data interface{}, path string) interface{} {
+	page, ok := data.(PageData)
+	if !ok || page.SEO.Title != "" {
+		return data
+	}
+	page.SEO = pageSEO(page, path)
+	return page
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPageSEOJSONLD(t *testing.T) {
+	defer func(url string) { baseURL = url }(baseURL)
+	baseURL = "https://example.com"
+
+	book := &Book{Slug: "b", Metadata: BookMetadata{Title: "Sepoy", Author: "Sita Ram"}, Chapters: []ChapterInfo{{Slug: "one"}, {Slug: "two", Pages: "5-9"}}}
+	post := &Post{Slug: "p", Metadata: PostMetadata{Title: "Hello", Date: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)}}
+
+	tests := []struct {
+		name     string
+		data     PageData
+		path     string
+		wantType string
+		wantURL  string
+		wantLD   map[string]interface{}
+	}{
+		{
+			"page", PageData{Title: "Books", Content: "<p>All the books.</p>"}, "/books",
+			"website", "https://example.com/books",
+			map[string]interface{}{"@type": "WebPage", "description": "All the books."},
+		},
+		{
+			"book", PageData{Title: "Sepoy", Book: book}, "/book/b",
+			"book", "https://example.com/book/b/",
+			map[string]interface{}{"@type": "Book", "url": "https://example.com/book/b/", "author": map[string]interface{}{"@type": "Person", "name": "Sita Ram"}},
+		},
+		{
+			"chapter", PageData{Title: "Two", Book: book, Chapter: &ChapterData{ChapterSlug: "two", Title: "Two", Content: "<h1>Two</h1><p>It begins.</p>"}}, "/book/b/two",
+			"article", "https://example.com/book/b/two",
+			map[string]interface{}{"@type": "Chapter", "position": 2.0, "pagination": "5-9", "description": "It begins."},
+		},
+		{
+			"post", PageData{Title: "Hello", Post: post, Lang: "en"}, "/post/p",
+			"article", "https://example.com/post/p",
+			map[string]interface{}{"@type": "BlogPosting", "headline": "Hello", "datePublished": "2025-01-02T00:00:00Z", "inLanguage": "en"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seo := pageSEO(tt.data, tt.path)
+			if seo.Type != tt.wantType || seo.URL != tt.wantURL {
+				t.Errorf("type, URL = %s, %s; want %s, %s", seo.Type, seo.URL, tt.wantType, tt.wantURL)
+			}
+			var ld map[string]interface{}
+			if err := json.Unmarshal([]byte(seo.JSONLD), &ld); err != nil {
+				t.Fatalf("JSON-LD doesn't parse: %v\n%s", err, seo.JSONLD)
+			}
+			if ld["@context"] != "https://schema.org" {
+				t.Errorf("@context = %v", ld["@context"])
+			}
+			for key, want := range tt.wantLD {
+				if fmt.Sprint(ld[key]) != fmt.Sprint(want) {
+					t.Errorf("%s = %v, want %v", key, ld[key], want)
+				}
+			}
+		})
+	}
+}
+
+func TestPageSEOAlternates(t *testing.T) {
+	defer func(url string) { baseURL = url }(baseURL)
+	baseURL = "https://example.com"
+
+	tests := []struct {
+		name         string
+		lang         string
+		translations []Translation
+		want         string
+	}{
+		{"no translations", "en", nil, "[]"},
+		{
+			"original in the default language",
+			"en",
+			[]Translation{{Language: "hi", Path: "/hi/post/p"}},
+			"[{en https://example.com/post/p} {hi https://example.com/hi/post/p} {x-default https://example.com/post/p}]",
+		},
+		{
+			"translation into another language",
+			"hi",
+			[]Translation{{Language: "en", Path: "/post/p"}},
+			"[{hi https://example.com/hi/post/p} {en https://example.com/post/p} {x-default https://example.com/post/p}]",
+		},
+		{
+			"no version in the default language",
+			"hi",
+			[]Translation{{Language: "fr", Path: "/fr/post/p"}},
+			"[{hi https://example.com/hi/post/p} {fr https://example.com/fr/post/p}]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := languagePrefix(tt.lang) + "/post/p"
+			seo := pageSEO(PageData{Title: "P", Lang: tt.lang, Translations: tt.translations}, path)
+			if got := fmt.Sprint(seo.Alternates); got != tt.want {
+				t.Errorf("alternates = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
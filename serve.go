@@ -0,0 +1,678 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// runServe serves the site, rendering every page from the content on each
+// request
+func runServe(cfg *config, args []string) error {
+	if len(args) > 0 {
+		return errUsage
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		return errors.New("-tls-cert and -tls-key must be given together")
+	}
+	if err := setupLogging(cfg.LogFormat); err != nil {
+		return err
+	}
+
+	// Parse templates
+	var err error
+	templates, err = template.ParseGlob(filepath.Join("templates", "*.html"))
+	if err != nil {
+		return fmt.Errorf("parsing templates: %w", err)
+	}
+	if err := loadShortcodeTemplates(); err != nil {
+		return fmt.Errorf("parsing shortcode templates: %w", err)
+	}
+
+	metrics.token = cfg.MetricsToken
+	if cfg.AnalyticsFile != "" {
+		analytics, err = openAnalytics(cfg.AnalyticsFile, cfg.AnalyticsToken)
+		if err != nil {
+			return fmt.Errorf("analytics: %w", err)
+		}
+	}
+
+	// Load the content once up front so that problems show at startup and
+	// readiness reflects them
+	if _, err := loadAllPosts(); err != nil {
+		log.Printf("Error loading posts: %v", err)
+	}
+	if _, err := loadAllBooks(); err != nil {
+		log.Printf("Error loading books: %v", err)
+	}
+
+	return listenAndServe(cfg, accessLog(instrument(languageHandler(siteMux()))))
+}
+
+// siteMux returns the routes of the site
+func siteMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", homeHandler)
+	mux.HandleFunc("/posts/", postsHandler)
+	mux.HandleFunc("/post/", postHandler)
+	mux.HandleFunc("/books/", booksHandler)
+	mux.HandleFunc("/book/", bookHandler)
+	mux.HandleFunc("/opds.xml", opdsHandler)
+	mux.HandleFunc("/opds.json", opds2Handler)
+	mux.HandleFunc(apiPrefix+"/", apiHandler)
+	mux.HandleFunc("/analytics", analyticsHandler)
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/readyz", readyzHandler)
+	mux.HandleFunc("/metrics", metricsHandler)
+
+	// Serve static files
+	fs := http.FileServer(http.Dir("static"))
+	mux.Handle("/static/", http.StripPrefix("/static/", fs))
+	return mux
+}
+
+type languageKey struct{}
+
+// languageHandler serves pages in languages other than the default under a
+// language prefix such as /hi/posts/. It strips the prefix and records the
+// language on the request, and redirects prefixed default-language paths.
+func languageHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lang, rest := splitLanguagePrefix(r.URL.Path)
+		switch lang {
+		case "":
+			next.ServeHTTP(w, r)
+		case defaultLanguage:
+			http.Redirect(w, r, rest, http.StatusMovedPermanently)
+		default:
+			r = r.Clone(context.WithValue(r.Context(), languageKey{}, lang))
+			r.URL.Path = rest
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// requestLanguage returns the language of the site section a request is for
+func requestLanguage(r *http.Request) string {
+	if lang, ok := r.Context().Value(languageKey{}).(string); ok {
+		return lang
+	}
+	return defaultLanguage
+}
+
+// loadSiteLanguages returns the languages the site has content in
+func loadSiteLanguages() ([]string, error) {
+	posts, err := loadAllPosts()
+	if err != nil {
+		return nil, err
+	}
+	books, err := loadAllBooks()
+	if err != nil {
+		return nil, err
+	}
+	return siteLanguages(posts, books), nil
+}
+
+func homeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	lang := requestLanguage(r)
+	languages, err := loadSiteLanguages()
+	if err != nil {
+		http.Error(w, "Error loading content", http.StatusInternalServerError)
+		log.Printf("Error loading content: %v", err)
+		return
+	}
+	if !slices.Contains(languages, lang) {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Read content from title-page directory, falling back to the
+	// default language when the page hasn't been translated
+	content, err := readMarkdownFile(titlePageFile(lang))
+	if os.IsNotExist(err) {
+		content, err = readMarkdownFile(titlePageFile(defaultLanguage))
+	}
+	if err != nil {
+		log.Printf("Error reading title page: %v", err)
+		content = template.HTML("<p>" + template.HTMLEscapeString(uiString(lang, "welcome")) + "</p>")
+	}
+
+	data := PageData{
+		Title:        uiString(lang, "site_title"),
+		Content:      content,
+		Lang:         lang,
+		Translations: listingTranslations(languages, lang, "/"),
+	}
+
+	renderTemplate(w, r, "home.html", data)
+}
+
+func postsHandler(w http.ResponseWriter, r *http.Request) {
+	posts, err := loadAllPosts()
+	if err != nil {
+		http.Error(w, "Error loading posts", http.StatusInternalServerError)
+		log.Printf("Error loading posts: %v", err)
+		return
+	}
+
+	lang := requestLanguage(r)
+	languages, err := loadSiteLanguages()
+	if err != nil || !slices.Contains(languages, lang) {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := PageData{
+		Title:        uiString(lang, "blog_posts"),
+		Posts:        postsInLanguage(posts, lang),
+		Lang:         lang,
+		Translations: listingTranslations(languages, lang, "/posts/"),
+	}
+
+	renderTemplate(w, r, "posts.html", data)
+}
+
+func postHandler(w http.ResponseWriter, r *http.Request) {
+	// Extract slug from URL
+	slug := strings.TrimPrefix(r.URL.Path, "/post/")
+	slug = strings.TrimSuffix(slug, "/")
+	slug, file, _ := strings.Cut(slug, "/")
+	if slug == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Load every post so that translations can be linked
+	posts, err := loadAllPosts()
+	if err != nil {
+		http.Error(w, "Error loading posts", http.StatusInternalServerError)
+		log.Printf("Error loading posts: %v", err)
+		return
+	}
+	linkPostTranslations(posts)
+
+	var post *Post
+	for i := range posts {
+		if posts[i].Slug == slug {
+			post = &posts[i]
+		}
+	}
+	if post == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Posts are only served under the prefix of their own language
+	if post.Lang() != requestLanguage(r) {
+		http.Redirect(w, r, strings.TrimSuffix(post.Path()+"/"+file, "/"), http.StatusMovedPermanently)
+		return
+	}
+
+	// Handle generated social preview cards
+	if img, ok := findGeneratedImage(postCardImages(post), file); ok {
+		serveGeneratedImage(w, img)
+		return
+	}
+
+	// Handle citation downloads
+	if _, ok := findCitationFormat(file); ok {
+		serveCitation(w, post.Citation(), file)
+		return
+	}
+
+	// Handle the files bundled with the post
+	if file != "" {
+		if p, ok := postBundleFile(post.Slug, file); ok {
+			http.ServeFile(w, r, p)
+			return
+		}
+		http.NotFound(w, r)
+		return
+	}
+
+	data := PageData{
+		Title:        post.Metadata.Title,
+		Post:         post,
+		Translations: post.Translations,
+		ReferencedBy: referencedBy(posts)["post:"+post.Slug],
+	}
+
+	renderTemplate(w, r, "post.html", data)
+	analytics.record(r, AnalyticsEvent{Kind: eventView, Post: post.Slug})
+}
+
+func renderTemplate(w http.ResponseWriter, r *http.Request, tmpl string, data interface{}) {
+	data, err := withLanguage(data)
+	if err == nil {
+		path := languagePrefix(requestLanguage(r)) + r.URL.Path
+		err = templates.ExecuteTemplate(w, tmpl, withSEO(data, path))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		log.Printf("Template execution error: %v", err)
+		metrics.renderFailed()
+	}
+}
+
+func booksHandler(w http.ResponseWriter, r *http.Request) {
+	books, err := loadAllBooks()
+	if err != nil {
+		http.Error(w, "Error loading books", http.StatusInternalServerError)
+		log.Printf("Error loading books: %v", err)
+		return
+	}
+
+	lang := requestLanguage(r)
+	languages, err := loadSiteLanguages()
+	if err != nil || !slices.Contains(languages, lang) {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := PageData{
+		Title:        uiString(lang, "books"),
+		Books:        booksInLanguage(books, lang),
+		Lang:         lang,
+		Translations: listingTranslations(languages, lang, "/books/"),
+	}
+
+	renderTemplate(w, r, "books.html", data)
+}
+
+func bookHandler(w http.ResponseWriter, r *http.Request) {
+	// URL format: /book/{bookSlug}/ or /book/{bookSlug}/{chapterSlug} or /book/{bookSlug}/all or /book/{bookSlug}/download
+	path := strings.TrimPrefix(r.URL.Path, "/book/")
+	path = strings.TrimSuffix(path, "/")
+
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) == 0 || parts[0] == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	bookSlug := parts[0]
+
+	// Load every book so that translations can be linked
+	books, err := loadAllBooks()
+	if err != nil {
+		http.Error(w, "Error loading books", http.StatusInternalServerError)
+		log.Printf("Error loading books: %v", err)
+		return
+	}
+	linkBookTranslations(books)
+
+	var book *Book
+	for i := range books {
+		if books[i].Slug == bookSlug {
+			book = &books[i]
+		}
+	}
+	if book == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// Books are only served under the prefix of their own language
+	if book.Lang() != requestLanguage(r) {
+		http.Redirect(w, r, book.Path()+strings.TrimPrefix(r.URL.Path, "/book/"+bookSlug), http.StatusMovedPermanently)
+		return
+	}
+
+	// If only book slug, show table of contents
+	if len(parts) == 1 || parts[1] == "" {
+		data := PageData{
+			Title:        book.Metadata.Title,
+			Book:         book,
+			Translations: book.Translations,
+		}
+		renderTemplate(w, r, "book.html", data)
+		return
+	}
+
+	// Handle the whole book on a single page
+	if parts[1] == "all" {
+		all, err := loadAllChapters(book)
+		if err != nil {
+			http.Error(w, "Error loading chapters", http.StatusInternalServerError)
+			log.Printf("Error loading chapters for %s: %v", bookSlug, err)
+			return
+		}
+
+		data := PageData{
+			Title:   book.Metadata.Title,
+			Book:    book,
+			BookAll: all,
+		}
+		renderTemplate(w, r, "book_all.html", data)
+		return
+	}
+
+	// Handle cover image
+	if book.Metadata.Cover != "" && parts[1] == book.Metadata.Cover {
+		http.ServeFile(w, r, filepath.Join("books", bookSlug, book.Metadata.Cover))
+		return
+	}
+
+	// Handle generated covers
+	if img, ok := findGeneratedImage(bookCoverImages(book), parts[1]); ok {
+		serveGeneratedImage(w, img)
+		return
+	}
+
+	// Handle EPUB download
+	if strings.HasSuffix(parts[1], ".epub") && parts[1] == book.Metadata.EpubFile {
+		epubPath := filepath.Join("books", bookSlug, book.Metadata.EpubFile)
+		w.Header().Set("Content-Type", "application/epub+zip")
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+book.Metadata.EpubFile+"\"")
+		http.ServeFile(w, r, epubPath)
+		if isFirstRange(r) {
+			analytics.record(r, AnalyticsEvent{Kind: eventDownload, Book: book.Slug})
+		}
+		return
+	}
+
+	// Handle book citation downloads
+	if format, ok := findCitationFormat(parts[1]); ok {
+		serveCitation(w, book.Citation(), format.FileName)
+		return
+	}
+
+	// Handle chapter citation downloads
+	if chapterSlug, file, ok := strings.Cut(parts[1], "/"); ok {
+		if _, err := loadChapter(book, chapterSlug); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		serveCitation(w, book.ChapterCitation(chapterSlug), file)
+		return
+	}
+
+	// Handle the glossary page
+	if parts[1] == "glossary" && len(book.Glossary) > 0 {
+		data := PageData{
+			Title: uiString(book.Lang(), "glossary") + " - " + book.Metadata.Title,
+			Book:  book,
+		}
+		renderTemplate(w, r, "glossary.html", data)
+		return
+	}
+
+	// Handle generated editions
+	for _, export := range bookExports(book) {
+		if parts[1] != export.FileName {
+			continue
+		}
+
+		data, err := export.Build(book)
+		if err != nil {
+			http.Error(w, "Error generating "+export.FileName, http.StatusInternalServerError)
+			log.Printf("Error generating %s: %v", export.FileName, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", export.ContentType)
+		w.Header().Set("Content-Disposition", "attachment; filename=\""+export.FileName+"\"")
+		w.Write(data)
+		return
+	}
+
+	// Otherwise, show the chapter
+	chapterSlug := parts[1]
+	chapter, err := loadChapter(book, chapterSlug)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	chapter.Content = linkGlossaryTerms(book, chapter.Content)
+
+	// Posts that refer to the chapter are listed under it
+	posts, err := loadAllPosts()
+	if err != nil {
+		log.Printf("Error loading posts: %v", err)
+	}
+
+	data := PageData{
+		Title:        chapter.Title + " - " + book.Metadata.Title,
+		Book:         book,
+		Chapter:      chapter,
+		ReferencedBy: referencedBy(posts)["book:"+book.Slug+"/"+chapterSlug],
+	}
+
+	renderTemplate(w, r, "chapter.html", data)
+	analytics.record(r, AnalyticsEvent{Kind: eventView, Book: book.Slug, Chapter: chapterSlug})
+}
+
+// analyticsHandler shows the analytics dashboard to holders of the token
+func analyticsHandler(w http.ResponseWriter, r *http.Request) {
+	if analytics == nil || analytics.token == "" {
+		http.NotFound(w, r)
+		return
+	}
+	if !checkToken(r, analytics.token) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="analytics"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	events, err := analytics.readEvents()
+	if err != nil {
+		http.Error(w, "Error reading analytics", http.StatusInternalServerError)
+		log.Printf("Error reading analytics: %v", err)
+		return
+	}
+	posts, err := loadAllPosts()
+	if err != nil {
+		http.Error(w, "Error loading posts", http.StatusInternalServerError)
+		log.Printf("Error loading posts: %v", err)
+		return
+	}
+	books, err := loadAllBooks()
+	if err != nil {
+		http.Error(w, "Error loading books", http.StatusInternalServerError)
+		log.Printf("Error loading books: %v", err)
+		return
+	}
+
+	summary := summarizeAnalytics(events, posts, books, time.Now())
+	data := PageData{
+		Title:     "Analytics",
+		Analytics: &summary,
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Robots-Tag", "noindex")
+	renderTemplate(w, r, "analytics.html", data)
+}
+
+func opdsHandler(w http.ResponseWriter, r *http.Request) {
+	books, err := loadAllBooks()
+	if err != nil {
+		http.Error(w, "Error loading books", http.StatusInternalServerError)
+		log.Printf("Error loading books: %v", err)
+		return
+	}
+
+	lang := requestLanguage(r)
+	feed, err := buildOPDSFeed(booksInLanguage(books, lang), lang)
+	if err != nil {
+		http.Error(w, "Error building OPDS feed", http.StatusInternalServerError)
+		log.Printf("Error building OPDS feed: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", opdsAcquisitionType)
+	w.Write(feed)
+}
+
+func opds2Handler(w http.ResponseWriter, r *http.Request) {
+	books, err := loadAllBooks()
+	if err != nil {
+		http.Error(w, "Error loading books", http.StatusInternalServerError)
+		log.Printf("Error loading books: %v", err)
+		return
+	}
+
+	lang := requestLanguage(r)
+	feed, err := buildOPDS2Feed(booksInLanguage(books, lang), lang)
+	if err != nil {
+		http.Error(w, "Error building OPDS feed", http.StatusInternalServerError)
+		log.Printf("Error building OPDS feed: %v", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", opdsJSONType)
+	w.Write(feed)
+}
+
+// apiHandler serves the read-only JSON API. Paths are accepted with or
+// without the .json suffix the static build uses.
+func apiHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, apiPrefix+"/")
+	path = strings.TrimSuffix(strings.TrimSuffix(path, "/"), ".json")
+	parts := strings.Split(path, "/")
+
+	if path == "schema" {
+		data, err := os.ReadFile(apiSchemaFile)
+		if err != nil {
+			http.Error(w, "Error loading schema", http.StatusInternalServerError)
+			log.Printf("Error loading API schema: %v", err)
+			return
+		}
+		serveJSON(w, r, data)
+		return
+	}
+
+	var v interface{}
+	switch parts[0] {
+	case "posts":
+		posts, err := loadAllPosts()
+		if err != nil {
+			http.Error(w, "Error loading posts", http.StatusInternalServerError)
+			log.Printf("Error loading posts: %v", err)
+			return
+		}
+		linkPostTranslations(posts)
+
+		switch len(parts) {
+		case 1:
+			v = apiPostList(posts)
+		case 2:
+			for i := range posts {
+				if posts[i].Slug == parts[1] {
+					v = apiPost(&posts[i])
+				}
+			}
+		}
+
+	case "books":
+		books, err := loadAllBooks()
+		if err != nil {
+			http.Error(w, "Error loading books", http.StatusInternalServerError)
+			log.Printf("Error loading books: %v", err)
+			return
+		}
+		linkBookTranslations(books)
+
+		var book *Book
+		if len(parts) > 1 {
+			for i := range books {
+				if books[i].Slug == parts[1] {
+					book = &books[i]
+				}
+			}
+		}
+
+		switch {
+		case len(parts) == 1:
+			v = apiBookList(books)
+		case book == nil:
+		case len(parts) == 2:
+			v = apiBook(book)
+		case len(parts) == 4 && parts[2] == "chapters" && slices.ContainsFunc(book.Chapters, func(ch ChapterInfo) bool { return ch.Slug == parts[3] }):
+			chapter, err := apiChapter(book, parts[3])
+			if err != nil {
+				http.Error(w, "Error loading chapter", http.StatusInternalServerError)
+				log.Printf("Error loading chapter %s/%s: %v", book.Slug, parts[3], err)
+				return
+			}
+			v = chapter
+		}
+	}
+
+	if v == nil {
+		http.Error(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+
+	data, err := encodeAPI(v)
+	if err != nil {
+		http.Error(w, "Error encoding response", http.StatusInternalServerError)
+		log.Printf("Error encoding API response: %v", err)
+		return
+	}
+	serveJSON(w, r, data)
+}
+
+// serveJSON writes an API response, answering conditional requests whose
+// ETag still matches with 304 Not Modified
+func serveJSON(w http.ResponseWriter, r *http.Request, data []byte) {
+	etag := apiETag(data)
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == etag || tag == "W/"+etag || tag == "*" {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(data)
+}
+
+func serveCitation(w http.ResponseWriter, citation Citation, file string) {
+	format, ok := findCitationFormat(file)
+	if !ok {
+		http.Error(w, "404 page not found", http.StatusNotFound)
+		return
+	}
+
+	data, err := format.Render(citation)
+	if err != nil {
+		http.Error(w, "Error rendering citation", http.StatusInternalServerError)
+		log.Printf("Error rendering citation %s: %v", citation.Key, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType)
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+format.FileName+"\"")
+	w.Write(data)
+}
+
+func serveGeneratedImage(w http.ResponseWriter, img generatedImage) {
+	data, err := img.Render()
+	if err != nil {
+		http.Error(w, "Error generating "+img.FileName, http.StatusInternalServerError)
+		log.Printf("Error generating %s: %v", img.FileName, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", img.ContentType)
+	w.Write(data)
+}
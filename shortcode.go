@@ -0,0 +1,406 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Shortcodes are written on lines of their own:
+//
+//	{{< figure src="images/map.png" caption="The route north" >}}
+//
+// Shortcodes that wrap Markdown are closed by a tag naming them:
+//
+//	{{< note title="Spelling" >}}
+//	Names follow the *original* edition.
+//	{{< /note >}}
+var shortcodeTagPattern = regexp.MustCompile(`^\{\{<\s*(/?)([A-Za-z][\w-]*)\s*(.*?)\s*>\}\}\s*$`)
+
+// shortcodeDir holds shortcodes written as templates, one per file named
+// after the shortcode. A template named <name>.inner.html wraps Markdown,
+// which it is given as .Inner.
+var shortcodeDir = filepath.Join("templates", "shortcodes")
+
+// shortcodeTemplates are the shortcodes in shortcodeDir, parsed by
+// loadShortcodeTemplates
+var shortcodeTemplates = map[string]Shortcode{}
+
+// Shortcode renders a shortcode used in Markdown
+type Shortcode struct {
+	Inner  bool // whether it wraps Markdown and needs a closing tag
+	Render func(call ShortcodeCall) (template.HTML, error)
+}
+
+// ShortcodeCall is a use of a shortcode, as given to its Go function or
+// template
+type ShortcodeCall struct {
+	Name   string
+	Args   map[string]string // named arguments, key="value"
+	Params []string          // positional arguments
+	Inner  template.HTML     // the Markdown it wraps, rendered
+	Lang   string            // of the post or chapter it's in
+}
+
+// Get returns a named argument, or "" if it wasn't given
+func (c ShortcodeCall) Get(key string) string {
+	return c.Args[key]
+}
+
+// Param returns a positional argument, or "" if it wasn't given
+func (c ShortcodeCall) Param(i int) string {
+	if i < 0 || i >= len(c.Params) {
+		return ""
+	}
+	return c.Params[i]
+}
+
+// shortcodes are the shortcodes written in Go. A site adds its own by
+// calling registerShortcode from an init function.
+var shortcodes = map[string]Shortcode{
+	"figure":  {Render: renderFigure},
+	"video":   {Render: renderVideo},
+	"youtube": {Render: renderEmbed("https://www.youtube-nocookie.com/embed/")},
+	"vimeo":   {Render: renderEmbed("https://player.vimeo.com/video/")},
+	"note":    {Inner: true, Render: renderCallout("note")},
+	"warning": {Inner: true, Render: renderCallout("warning")},
+	"aside":   {Inner: true, Render: renderCallout("")},
+	"details": {Inner: true, Render: renderDetails},
+	"passage": {Render: renderPassage},
+}
+
+// registerShortcode makes a shortcode written in Go available to Markdown,
+// replacing any built-in one of the same name
+func registerShortcode(name string, sc Shortcode) {
+	shortcodes[name] = sc
+}
+
+// loadShortcodeTemplates parses the shortcodes written as templates. It is
+// called with the page templates, before any Markdown is rendered.
+func loadShortcodeTemplates() error {
+	files, err := filepath.Glob(filepath.Join(shortcodeDir, "*.html"))
+	if err != nil {
+		return err
+	}
+
+	loaded := map[string]Shortcode{}
+	for _, file := range files {
+		name, inner := strings.CutSuffix(strings.TrimSuffix(filepath.Base(file), ".html"), ".inner")
+		if _, ok := loaded[name]; ok {
+			return fmt.Errorf("%s: shortcode %s has more than one template", file, name)
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		t, err := template.New(name).Parse(string(data))
+		if err != nil {
+			return err
+		}
+		loaded[name] = Shortcode{Inner: inner, Render: func(call ShortcodeCall) (template.HTML, error) {
+			return executeShortcode(t, name, call)
+		}}
+	}
+	shortcodeTemplates = loaded
+	return nil
+}
+
+// lookupShortcode finds a shortcode, preferring a template over one written
+// in Go so that a site can restyle the built-ins
+func lookupShortcode(name string) (Shortcode, bool) {
+	if sc, ok := shortcodeTemplates[name]; ok {
+		return sc, true
+	}
+	sc, ok := shortcodes[name]
+	return sc, ok
+}
+
+// builtinShortcodes are the templates of the built-in shortcodes
+var builtinShortcodes = template.Must(template.New("").Parse(`
+{{define "figure"}}<figure>
+<img src="{{.Get "src"}}" alt="{{or (.Get "alt") (.Get "caption")}}" loading="lazy">
+{{with .Get "caption"}}<figcaption>{{.}}</figcaption>
+{{end}}</figure>
+{{end}}
+{{define "video"}}<figure class="video">
+<video src="{{.Get "src"}}" controls preload="metadata"{{with .Get "poster"}} poster="{{.}}"{{end}}></video>
+{{with .Get "caption"}}<figcaption>{{.}}</figcaption>
+{{end}}</figure>
+{{end}}
+{{define "embed"}}<figure class="video embed">
+<iframe src="{{.Get "src"}}" title="{{or (.Get "title") "Video"}}" loading="lazy" allow="fullscreen; picture-in-picture" allowfullscreen></iframe>
+{{with .Get "caption"}}<figcaption>{{.}}</figcaption>
+{{end}}</figure>
+{{end}}
+{{define "callout"}}<aside class="callout callout-{{.Name}}" role="note">
+{{with .Get "title"}}<p class="callout-title">{{.}}</p>
+{{end}}{{.Inner}}</aside>
+{{end}}
+{{define "details"}}<details{{if .Get "open"}} open{{end}}>
+<summary>{{or (.Get "summary") (.Param 0)}}</summary>
+{{.Inner}}</details>
+{{end}}
+`))
+
+func renderFigure(call ShortcodeCall) (template.HTML, error) {
+	if call.Get("src") == "" {
+		return "", errors.New("figure needs a src")
+	}
+	return executeShortcode(builtinShortcodes, "figure", call)
+}
+
+func renderVideo(call ShortcodeCall) (template.HTML, error) {
+	if call.Get("src") == "" {
+		return "", errors.New("video needs a src")
+	}
+	return executeShortcode(builtinShortcodes, "video", call)
+}
+
+// renderEmbed returns a shortcode that embeds the player of a video site,
+// given the id of the video as its first argument or id="..."
+func renderEmbed(playerURL string) func(call ShortcodeCall) (template.HTML, error) {
+	return func(call ShortcodeCall) (template.HTML, error) {
+		id := call.Get("id")
+		if id == "" {
+			id = call.Param(0)
+		}
+		if id == "" {
+			return "", fmt.Errorf("%s needs the id of a video", call.Name)
+		}
+		call.Args["src"] = playerURL + id
+		return executeShortcode(builtinShortcodes, "embed", call)
+	}
+}
+
+// renderCallout returns a shortcode that sets Markdown apart under the
+// interface string named key, which title="..." replaces
+func renderCallout(key string) func(call ShortcodeCall) (template.HTML, error) {
+	return func(call ShortcodeCall) (template.HTML, error) {
+		if _, ok := call.Args["title"]; !ok && key != "" {
+			ui, err := loadUIStrings(call.Lang)
+			if err != nil {
+				return "", err
+			}
+			call.Args["title"] = ui[key]
+		}
+		return executeShortcode(builtinShortcodes, "callout", call)
+	}
+}
+
+func renderDetails(call ShortcodeCall) (template.HTML, error) {
+	if _, ok := call.Args["summary"]; !ok && call.Param(0) == "" {
+		ui, err := loadUIStrings(call.Lang)
+		if err != nil {
+			return "", err
+		}
+		call.Args["summary"] = ui["details"]
+	}
+	return executeShortcode(builtinShortcodes, "details", call)
+}
+
+// executeShortcode renders a shortcode with the named template
+func executeShortcode(t *template.Template, name string, call ShortcodeCall) (template.HTML, error) {
+	var buf bytes.Buffer
+	if err := t.ExecuteTemplate(&buf, name, call); err != nil {
+		return "", err
+	}
+	return template.HTML(buf.String()), nil
+}
+
+// parseShortcodeArgs splits the arguments of a shortcode into named ones,
+// key="value" or key=value, and positional ones
+func parseShortcodeArgs(s string) (map[string]string, []string, error) {
+	args := map[string]string{}
+	var params []string
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		key := ""
+		if i := strings.IndexAny(s, "= \t\""); i > 0 && s[i] == '=' {
+			key, s = s[:i], s[i+1:]
+		}
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			quoted, err := strconv.QuotedPrefix(s)
+			if err != nil {
+				return nil, nil, fmt.Errorf("unterminated string in %s", s)
+			}
+			value, _ = strconv.Unquote(quoted)
+			s = s[len(quoted):]
+		} else {
+			end := strings.IndexAny(s, " \t")
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+		}
+
+		if key != "" {
+			args[key] = value
+		} else {
+			params = append(params, value)
+		}
+	}
+	return args, params, nil
+}
+
+// kindShortcode is the kind of shortcode nodes in the Markdown AST
+var kindShortcode = ast.NewNodeKind("Shortcode")
+
+// shortcodeNode is a shortcode block. The Markdown a shortcode wraps is
+// parsed into its children.
+type shortcodeNode struct {
+	ast.BaseBlock
+	call      ShortcodeCall
+	shortcode Shortcode
+	err       error // found while parsing, reported when rendering
+	closed    bool
+}
+
+func (n *shortcodeNode) Kind() ast.NodeKind {
+	return kindShortcode
+}
+
+func (n *shortcodeNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Name": n.call.Name}, nil)
+}
+
+// markdownLanguageKey holds the language of the post or chapter being
+// converted, which built-in shortcodes label their output in
+var markdownLanguageKey = parser.NewContextKey()
+
+// markdownLanguage returns the language of the Markdown being converted
+func markdownLanguage(pc parser.Context) string {
+	if lang, ok := pc.Get(markdownLanguageKey).(string); ok {
+		return lang
+	}
+	return defaultLanguage
+}
+
+// shortcodeParser parses shortcode tags at the start of a line
+type shortcodeParser struct{}
+
+func (p *shortcodeParser) Trigger() []byte {
+	return []byte{'{'}
+}
+
+func (p *shortcodeParser) Open(parent ast.Node, reader text.Reader, pc parser.Context) (ast.Node, parser.State) {
+	line, _ := reader.PeekLine()
+	m := shortcodeTagPattern.FindSubmatch(line)
+	if m == nil || len(m[1]) > 0 {
+		// Closing tags are taken by the shortcode they close
+		return nil, parser.NoChildren
+	}
+	reader.AdvanceToEOL()
+
+	node := &shortcodeNode{call: ShortcodeCall{Name: string(m[2]), Lang: markdownLanguage(pc)}}
+	node.call.Args, node.call.Params, node.err = parseShortcodeArgs(string(m[3]))
+	for _, key := range []string{"src", "poster"} {
+		if v, ok := node.call.Args[key]; ok {
+			node.call.Args[key] = bundleURL(bundleBase(pc), v)
+		}
+	}
+	sc, ok := lookupShortcode(node.call.Name)
+	if !ok {
+		node.err = errors.New("unknown shortcode")
+		return node, parser.NoChildren
+	}
+	node.shortcode = sc
+	if !sc.Inner {
+		return node, parser.NoChildren
+	}
+	return node, parser.HasChildren
+}
+
+func (p *shortcodeParser) Continue(node ast.Node, reader text.Reader, pc parser.Context) parser.State {
+	n := node.(*shortcodeNode)
+	if !n.shortcode.Inner {
+		return parser.Close
+	}
+	line, _ := reader.PeekLine()
+	if m := shortcodeTagPattern.FindSubmatch(line); m != nil && len(m[1]) > 0 && string(m[2]) == n.call.Name {
+		reader.AdvanceToEOL()
+		n.closed = true
+		return parser.Close
+	}
+	return parser.Continue | parser.HasChildren
+}
+
+func (p *shortcodeParser) Close(node ast.Node, reader text.Reader, pc parser.Context) {}
+
+func (p *shortcodeParser) CanInterruptParagraph() bool {
+	return true
+}
+
+func (p *shortcodeParser) CanAcceptIndentedLine() bool {
+	return false
+}
+
+// shortcodeRenderer renders shortcode nodes, rendering the Markdown they
+// wrap with the renderer of the document
+type shortcodeRenderer struct {
+	renderer renderer.Renderer
+}
+
+func (r *shortcodeRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindShortcode, r.render)
+}
+
+func (r *shortcodeRenderer) render(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*shortcodeNode)
+	if n.err != nil {
+		return ast.WalkStop, fmt.Errorf("shortcode %s: %w", n.call.Name, n.err)
+	}
+	if n.shortcode.Inner && !n.closed {
+		return ast.WalkStop, fmt.Errorf("shortcode %s is never closed with {{< /%s >}}", n.call.Name, n.call.Name)
+	}
+
+	call := n.call
+	call.Args = make(map[string]string, len(n.call.Args))
+	for k, v := range n.call.Args {
+		call.Args[k] = v
+	}
+	if n.shortcode.Inner {
+		var inner bytes.Buffer
+		for child := n.FirstChild(); child != nil; child = child.NextSibling() {
+			if err := r.renderer.Render(&inner, source, child); err != nil {
+				return ast.WalkStop, err
+			}
+		}
+		call.Inner = template.HTML(inner.String())
+	}
+
+	out, err := n.shortcode.Render(call)
+	if err != nil {
+		return ast.WalkStop, fmt.Errorf("shortcode %s: %w", n.call.Name, err)
+	}
+	w.WriteString(string(out))
+	return ast.WalkSkipChildren, nil
+}
+
+// shortcodeExtension adds shortcodes to a goldmark Markdown
+type shortcodeExtension struct{}
+
+func (e shortcodeExtension) Extend(m goldmark.Markdown) {
+	m.Parser().AddOptions(parser.WithBlockParsers(
+		util.Prioritized(&shortcodeParser{}, 150),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(&shortcodeRenderer{renderer: m.Renderer()}, 500),
+	))
+}
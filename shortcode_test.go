@@ -0,0 +1,178 @@
+package main
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseShortcodeArgs(t *testing.T) {
+	tests := []struct {
+		in         string
+		wantArgs   map[string]string
+		wantParams []string
+	}{
+		{``, map[string]string{}, nil},
+		{`src="a b.png" caption="A \"map\""`, map[string]string{"src": "a b.png", "caption": `A "map"`}, nil},
+		{`open=true`, map[string]string{"open": "true"}, nil},
+		{`dQw4w9WgXcQ`, map[string]string{}, []string{"dQw4w9WgXcQ"}},
+		{`"Read more" open=1  extra`, map[string]string{"open": "1"}, []string{"Read more", "extra"}},
+		{`title=""`, map[string]string{"title": ""}, nil},
+		{`url=https://e.org/?a=b`, map[string]string{"url": "https://e.org/?a=b"}, nil},
+		{"a=1\tb=2", map[string]string{"a": "1", "b": "2"}, nil},
+	}
+	for _, tt := range tests {
+		args, params, err := parseShortcodeArgs(tt.in)
+		if err != nil {
+			t.Errorf("parseShortcodeArgs(%q): %v", tt.in, err)
+			continue
+		}
+		if !maps.Equal(args, tt.wantArgs) || !slices.Equal(params, tt.wantParams) {
+			t.Errorf("parseShortcodeArgs(%q) = %q, %q, want %q, %q", tt.in, args, params, tt.wantArgs, tt.wantParams)
+		}
+	}
+
+	if _, _, err := parseShortcodeArgs(`caption="unterminated`); err == nil {
+		t.Error("unterminated string gave no error")
+	}
+}
+
+func TestShortcodeTagPattern(t *testing.T) {
+	tests := []struct {
+		line                string
+		match               bool
+		closing, name, args string
+	}{
+		{`{{< figure src="a.png" >}}`, true, "", "figure", `src="a.png"`},
+		{`{{<note>}}`, true, "", "note", ""},
+		{`{{< /note >}}`, true, "/", "note", ""},
+		{"{{< my-code x >}}  \n", true, "", "my-code", "x"},
+		{`text {{< figure >}}`, false, "", "", ""},
+		{`{{< figure >}} text`, false, "", "", ""},
+		{`{{< 1st >}}`, false, "", "", ""},
+		{`{{ figure }}`, false, "", "", ""},
+	}
+	for _, tt := range tests {
+		m := shortcodeTagPattern.FindStringSubmatch(tt.line)
+		if (m != nil) != tt.match {
+			t.Errorf("%q: match = %v, want %v", tt.line, m != nil, tt.match)
+			continue
+		}
+		if m != nil && (m[1] != tt.closing || m[2] != tt.name || m[3] != tt.args) {
+			t.Errorf("%q: got %q %q %q, want %q %q %q", tt.line, m[1], m[2], m[3], tt.closing, tt.name, tt.args)
+		}
+	}
+}
+
+func TestShortcodes(t *testing.T) {
+	inContentRoot(t, map[string]string{
+		"templates/shortcodes/greet.html":      `<b>Hello {{.Get "name"}}</b>`,
+		"templates/shortcodes/box.inner.html":  `<div class="box">{{.Inner}}</div>`,
+		"templates/shortcodes/figure.html":     `<img class="site" src="{{.Get "src"}}">`,
+		"templates/shortcodes/mention.html":    `{{/* .Inner isn't used */}}<i>{{.Param 0}}</i>`,
+		"templates/shortcodes/unrelated.txt":   `not a shortcode`,
+		"templates/shortcodes/nested/bad.html": `{{.Broken`,
+		"i18n/en.yaml":                         "note: Note\nwarning: Warning\ndetails: Details\n",
+		"i18n/hi.yaml":                         "note: टिप्पणी\n",
+	})
+	if err := loadShortcodeTemplates(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { shortcodeTemplates = map[string]Shortcode{} })
+
+	tests := []struct {
+		name     string
+		base     string // the site path of the post
+		markdown string
+		want     []string // in the output, in order
+	}{
+		{"built-in", "", "{{< youtube abc >}}\n", []string{`src="https://www.youtube-nocookie.com/embed/abc"`}},
+		{"wrapping Markdown", "", "{{< details \"More\" >}}\nSome *text*.\n{{< /details >}}\n", []string{"<summary>More</summary>", "<em>text</em>", "</details>"}},
+		{"nested", "", "{{< details >}}\n{{< note >}}\ninner\n{{< /note >}}\n{{< /details >}}\n", []string{"<details>", `<aside class="callout callout-note"`, "inner", "</aside>", "</details>"}},
+		{"callout title", "", "{{< warning >}}\nx\n{{< /warning >}}\n", []string{`<aside class="callout callout-warning"`, `<p class="callout-title">Warning</p>`}},
+		{"callout title in the post's language", "/hi/post/p", "{{< note >}}\nx\n{{< /note >}}\n", []string{`<p class="callout-title">टिप्पणी</p>`}},
+		{"callout title falls back to English", "/hi/post/p", "{{< warning >}}\nx\n{{< /warning >}}\n", []string{`<p class="callout-title">Warning</p>`}},
+		{"callout title given", "/hi/post/p", "{{< note title=\"Aside\" >}}\nx\n{{< /note >}}\n", []string{`<p class="callout-title">Aside</p>`}},
+		{"aside has no title", "", "{{< aside >}}\nx\n{{< /aside >}}\n", []string{`<aside class="callout callout-aside" role="note">`, "<p>x</p>"}},
+		{"details summary", "", "{{< details >}}\nx\n{{< /details >}}\n", []string{"<summary>Details</summary>"}},
+		{"template", "", "{{< greet name=\"Sita\" >}}\n", []string{"<b>Hello Sita</b>"}},
+		{"template wrapping Markdown", "", "{{< box >}}\n**bold**\n{{< /box >}}\n", []string{`<div class="box">`, "<strong>bold</strong>", "</div>"}},
+		{"template named like a built-in", "", "{{< figure src=\"/a.png\" >}}\n", []string{`<img class="site" src="/a.png">`}},
+		{"mentioning .Inner doesn't wrap", "", "{{< mention Sita >}}\n\nafter\n", []string{"<i>Sita</i>", "<p>after</p>"}},
+		{"interrupts a paragraph", "", "before\n{{< greet name=x >}}\n", []string{"<p>before</p>", "<b>Hello x</b>"}},
+		{"inline is text", "", "see {{< greet >}} here\n", []string{"<p>see {{&lt; greet &gt;}} here</p>"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, _, err := convertMarkdown([]byte(tt.markdown), tt.base)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rest := string(out)
+			for _, want := range tt.want {
+				i := strings.Index(rest, want)
+				if i < 0 {
+					t.Fatalf("output lacks %q after earlier parts:\n%s", want, out)
+				}
+				rest = rest[i+len(want):]
+			}
+		})
+	}
+}
+
+func TestShortcodeErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		markdown string
+		want     string
+	}{
+		{"unknown", "{{< nope >}}\n", "unknown shortcode"},
+		{"never closed", "{{< note >}}\ntext\n", "never closed"},
+		{"missing argument", "{{< figure >}}\n", "figure needs a src"},
+		{"bad arguments", "{{< figure src=\"a >}}\n", "unterminated string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := convertMarkdown([]byte(tt.markdown), "")
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadShortcodeTemplatesErrors(t *testing.T) {
+	for name, files := range map[string]map[string]string{
+		"bad template": {"templates/shortcodes/bad.html": `{{.Broken`},
+		"two templates": {
+			"templates/shortcodes/box.html":       `<div></div>`,
+			"templates/shortcodes/box.inner.html": `<div>{{.Inner}}</div>`,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			inContentRoot(t, files)
+			if err := loadShortcodeTemplates(); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestChapterShortcodesUseBookLanguage(t *testing.T) {
+	inContentRoot(t, map[string]string{
+		"books/b/chapters/one.md": "# One\n\n{{< note >}}\nx\n{{< /note >}}\n",
+		"i18n/en.yaml":            "note: Note\n",
+		"i18n/hi.yaml":            "note: टिप्पणी\n",
+	})
+	for lang, want := range map[string]string{"en": "Note", "hi": "टिप्पणी"} {
+		book := &Book{Slug: "b", Metadata: BookMetadata{Language: lang}}
+		content, err := readChapterMarkup(book, "one")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(content, `<p class="callout-title">`+want+"</p>") {
+			t.Errorf("%s chapter lacks the %s title:\n%s", lang, want, content)
+		}
+	}
+}
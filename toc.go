@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// chapterTypes are the accepted epub:type roles of chapters.yaml entries
+var chapterTypes = map[string]bool{
+	"frontmatter": true,
+	"bodymatter":  true,
+	"backmatter":  true,
+}
+
+// reservedChapterSlugs are the pages of a book served in place of a
+// chapter of the same name
+var reservedChapterSlugs = map[string]bool{
+	"all":      true,
+	"glossary": true,
+}
+
+// TOCEntry represents an entry of a rendered table of contents
+type TOCEntry struct {
+	Title    string
+	URL      string // empty for parts without a page of their own
+	Type     string
+	Children []TOCEntry
+}
+
+// flattenChapters returns the entries of the chapters.yaml tree that have a
+// page, in reading order. Entries without a role of their own inherit the
+// role of the part they're in, and slugs that would clash with the other
+// pages and files of a book are rejected.
+func flattenChapters(entries []ChapterInfo) ([]ChapterInfo, error) {
+	var flat []ChapterInfo
+	seen := map[string]bool{}
+
+	var walk func(entries []ChapterInfo, role string) error
+	walk = func(entries []ChapterInfo, role string) error {
+		for _, entry := range entries {
+			if entry.Type != "" && !chapterTypes[entry.Type] {
+				return fmt.Errorf("%q: type must be one of frontmatter, bodymatter or backmatter, not %q", entry.Title, entry.Type)
+			}
+			if entry.Type == "" {
+				entry.Type = role
+			}
+
+			switch {
+			case entry.Slug == "" && len(entry.Chapters) == 0:
+				return fmt.Errorf("%q: entries need a slug, nested chapters or both", entry.Title)
+			case entry.Slug != "" && entry.Title == "":
+				return fmt.Errorf("%s: title is required", entry.Slug)
+			case seen[entry.Slug]:
+				return fmt.Errorf("%s: slug is used more than once", entry.Slug)
+			case reservedChapterSlugs[entry.Slug]:
+				return fmt.Errorf("%s: slug is reserved for the book's own %s page", entry.Slug, entry.Slug)
+			case strings.ContainsAny(entry.Slug, "./"):
+				// Covers, citations, the EPUB and the other editions are
+				// served by file name next to the chapters
+				return fmt.Errorf("%s: slugs can't contain . or /", entry.Slug)
+			}
+
+			if entry.Slug != "" {
+				seen[entry.Slug] = true
+				page := entry
+				page.Chapters = nil
+				flat = append(flat, page)
+			}
+			if err := walk(entry.Chapters, entry.Type); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(entries, ""); err != nil {
+		return nil, err
+	}
+	return flat, nil
+}
+
+// tocEntries builds a table of contents from the chapters.yaml tree,
+// linking each page with href
+func tocEntries(entries []ChapterInfo, href func(slug string) string) []TOCEntry {
+	var toc []TOCEntry
+	for _, entry := range entries {
+		e := TOCEntry{
+			Title:    entry.Title,
+			Type:     entry.Type,
+			Children: tocEntries(entry.Chapters, href),
+		}
+		if entry.Slug != "" {
+			e.URL = href(entry.Slug)
+		}
+		toc = append(toc, e)
+	}
+	return toc
+}
+
+// TOC returns the book's nested table of contents
+func (b Book) TOC() []TOCEntry {
+	return tocEntries(b.Contents, func(slug string) string {
+		return b.Path() + "/" + slug
+	})
+}
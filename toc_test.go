@@ -0,0 +1,89 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestFlattenChapters(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []ChapterInfo
+		want    []ChapterInfo
+	}{
+		{"none", nil, nil},
+		{
+			"flat",
+			[]ChapterInfo{{Slug: "one", Title: "One"}, {Slug: "two", Title: "Two", Type: "backmatter"}},
+			[]ChapterInfo{{Slug: "one", Title: "One"}, {Slug: "two", Title: "Two", Type: "backmatter"}},
+		},
+		{
+			"part without a page",
+			[]ChapterInfo{
+				{Title: "Part One", Chapters: []ChapterInfo{{Slug: "one", Title: "One"}, {Slug: "two", Title: "Two"}}},
+				{Slug: "three", Title: "Three"},
+			},
+			[]ChapterInfo{{Slug: "one", Title: "One"}, {Slug: "two", Title: "Two"}, {Slug: "three", Title: "Three"}},
+		},
+		{
+			"part with a page comes before its chapters",
+			[]ChapterInfo{{Slug: "part", Title: "Part", Chapters: []ChapterInfo{{Slug: "one", Title: "One"}}}},
+			[]ChapterInfo{{Slug: "part", Title: "Part"}, {Slug: "one", Title: "One"}},
+		},
+		{
+			"roles are inherited",
+			[]ChapterInfo{
+				{Title: "Front", Type: "frontmatter", Chapters: []ChapterInfo{
+					{Slug: "preface", Title: "Preface"},
+					{Slug: "map", Title: "Map", Type: "backmatter"},
+					{Title: "Section", Chapters: []ChapterInfo{{Slug: "note", Title: "Note"}}},
+				}},
+				{Slug: "one", Title: "One"},
+			},
+			[]ChapterInfo{
+				{Slug: "preface", Title: "Preface", Type: "frontmatter"},
+				{Slug: "map", Title: "Map", Type: "backmatter"},
+				{Slug: "note", Title: "Note", Type: "frontmatter"},
+				{Slug: "one", Title: "One"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := flattenChapters(tt.entries)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.EqualFunc(got, tt.want, func(a, b ChapterInfo) bool {
+				return a.Slug == b.Slug && a.Title == b.Title && a.Type == b.Type && len(a.Chapters) == 0
+			}) {
+				t.Errorf("got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlattenChaptersErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []ChapterInfo
+	}{
+		{"unknown type", []ChapterInfo{{Slug: "one", Title: "One", Type: "part"}}},
+		{"no slug or chapters", []ChapterInfo{{Title: "Empty"}}},
+		{"no title", []ChapterInfo{{Slug: "one"}}},
+		{"duplicate slug", []ChapterInfo{{Slug: "one", Title: "One"}, {Title: "Part", Chapters: []ChapterInfo{{Slug: "one", Title: "Again"}}}}},
+		{"reserved slug", []ChapterInfo{{Slug: "all", Title: "All"}}},
+		{"nested reserved slug", []ChapterInfo{{Title: "Part", Chapters: []ChapterInfo{{Slug: "glossary", Title: "Glossary"}}}}},
+		{"file name slug", []ChapterInfo{{Slug: "cover.svg", Title: "Cover"}}},
+		{"citation slug", []ChapterInfo{{Slug: "cite.bib", Title: "Cite"}}},
+		{"slash in slug", []ChapterInfo{{Slug: "one/two", Title: "Two"}}},
+		{"nested error", []ChapterInfo{{Title: "Part", Chapters: []ChapterInfo{{Slug: "x", Title: "X", Type: "nope"}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := flattenChapters(tt.entries); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
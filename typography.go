@@ -0,0 +1,548 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// The transcribed chapters mix straight and curly quotes, double hyphens
+// and dashes, dots and ellipses. A book's typography.yaml says how its
+// punctuation should be set: American style quotes with double marks and
+// closes up its dashes, British style quotes with single marks and spaces
+// them. The typography command or, with on_load, readChapter makes it so.
+// Only running text is changed: tags, attributes, comments, code and terms
+// given in another language are left as written.
+
+// TypographyConfig represents the typography.yaml structure
+type TypographyConfig struct {
+	Style  string          `yaml:"style"`   // american or british
+	OnLoad bool            `yaml:"on_load"` // normalize chapters as they are read
+	Rules  map[string]bool `yaml:"rules"`   // rules to turn off, e.g. quotes: false
+	Keep   []string        `yaml:"keep"`    // terms to leave as written, besides the glossary's
+}
+
+// typographyStyle is how a style sets its quotes, dashes and ellipses
+type typographyStyle struct {
+	Quotes   [2]rune // opening and closing marks of a quotation
+	Inner    [2]rune // of a quotation within a quotation
+	Dash     string  // a dash setting off a phrase
+	Ellipsis string
+}
+
+// typographyStyles are the styles a book can be set in
+var typographyStyles = map[string]typographyStyle{
+	"american": {Quotes: [2]rune{'“', '”'}, Inner: [2]rune{'‘', '’'}, Dash: "—", Ellipsis: "…"},
+	"british":  {Quotes: [2]rune{'‘', '’'}, Inner: [2]rune{'“', '”'}, Dash: " – ", Ellipsis: "…"},
+}
+
+const defaultTypographyStyle = "american"
+
+// typographyRules are the rules of the normalization, in the order they
+// are applied. quotes curls straight quotes; quote_style sets every
+// quotation in the style's marks, alternating with its depth.
+var typographyRules = []string{"nbsp", "ellipses", "dashes", "spaces", "quotes", "quote_style"}
+
+// typographySkipElements are elements whose text is never changed
+var typographySkipElements = map[string]bool{
+	"code": true, "pre": true, "kbd": true, "samp": true, "var": true, "tt": true,
+	"script": true, "style": true, "math": true, "svg": true,
+}
+
+// loadTypographyConfig reads a book's typography.yaml. A book without one
+// is set in the default style and isn't normalized as it is read.
+func loadTypographyConfig(bookSlug string) (*TypographyConfig, error) {
+	config := &TypographyConfig{}
+
+	data, err := os.ReadFile(filepath.Join("books", bookSlug, "typography.yaml"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := yaml.Unmarshal(data, config); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Style == "" {
+		config.Style = defaultTypographyStyle
+	}
+	if err := config.check(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// check reports a style or rule that doesn't exist
+func (c *TypographyConfig) check() error {
+	if _, ok := typographyStyles[c.Style]; !ok {
+		return fmt.Errorf("unknown style %q, want american or british", c.Style)
+	}
+	for rule := range c.Rules {
+		if !typographyRuleKnown(rule) {
+			return fmt.Errorf("unknown rule %q, want one of %s", rule, strings.Join(typographyRules, ", "))
+		}
+	}
+	return nil
+}
+
+func typographyRuleKnown(rule string) bool {
+	for _, r := range typographyRules {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+// typographer normalizes the punctuation of a book's chapters
+type typographer struct {
+	style typographyStyle
+	rules map[string]bool // the rules that are on
+	keep  *regexp.Regexp  // entities and terms to leave alone
+}
+
+var (
+	ellipsisPattern = regexp.MustCompile(`\.[ \t]?\.[ \t]?\.`)
+	dashPattern     = regexp.MustCompile(`[ \t]*(?:---?|—)[ \t]*|[ \t]+[-–][ \t]+`)
+	spacePunctPat   = regexp.MustCompile(`[ \t]+[,;:!?.]`)
+	entityPattern   = `&(?:#[0-9]+|#[xX][0-9a-fA-F]+|[a-zA-Z][a-zA-Z0-9]*);`
+)
+
+// nbspEntities are the spellings of a non-breaking space in markup
+var nbspEntities = map[string]bool{"&nbsp;": true, "&#160;": true, "&#xa0;": true, "&#xA0;": true}
+
+// newTypographer returns the normalization a book's typography.yaml asks
+// for. The book's glossary terms are kept as written, like its keep list.
+func newTypographer(bookSlug string, config *TypographyConfig) (*typographer, error) {
+	t := &typographer{style: typographyStyles[config.Style], rules: map[string]bool{}}
+	for _, rule := range typographyRules {
+		on, set := config.Rules[rule]
+		t.rules[rule] = on || !set
+	}
+
+	glossary, err := loadGlossary(bookSlug)
+	if err != nil {
+		return nil, fmt.Errorf("glossary.yaml: %w", err)
+	}
+	keep := []string{entityPattern}
+	for _, term := range glossary {
+		keep = append(keep, term.pattern.String())
+	}
+	// Longest first, so a term wins over a shorter one it starts with
+	terms := append([]string(nil), config.Keep...)
+	sort.Slice(terms, func(a, b int) bool { return len(terms[a]) > len(terms[b]) })
+	for _, term := range terms {
+		if strings.TrimSpace(term) != "" {
+			keep = append(keep, strings.Join(strings.Fields(regexp.QuoteMeta(term)), `\s+`))
+		}
+	}
+	t.keep, err = regexp.Compile("(?:" + strings.Join(keep, ")|(?:") + ")")
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// typographyChanges counts the changes made by each rule
+type typographyChanges map[string]int
+
+func (c typographyChanges) total() int {
+	n := 0
+	for _, count := range c {
+		n += count
+	}
+	return n
+}
+
+// String lists the counts in the order the rules are applied, e.g.
+// "3 quotes, 1 dashes"
+func (c typographyChanges) String() string {
+	var parts []string
+	for _, rule := range typographyRules {
+		if c[rule] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", c[rule], rule))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// normalize returns chapter markup with its punctuation normalized and
+// what was changed. Line breaks are never added or removed, so the lines
+// of the result correspond to those of the chapter.
+func (t *typographer) normalize(content string) (string, typographyChanges) {
+	changes := typographyChanges{}
+	var out strings.Builder
+
+	// Blocks start afresh
+	st := &typographyState{}
+	skipName, skipDepth := "", 0
+	for _, tok := range tokenizeHTML(content) {
+		switch {
+		case skipDepth > 0:
+			if tok.Name == skipName && tok.Kind == startTagToken {
+				skipDepth++
+			} else if tok.Name == skipName && tok.Kind == endTagToken {
+				skipDepth--
+			}
+		case tok.Kind == startTagToken && (typographySkipElements[tok.Name] || tok.Attrs["lang"] != "" || tok.Attrs["xml:lang"] != ""):
+			skipName, skipDepth = tok.Name, 1
+		case tok.Kind == textToken:
+			out.WriteString(t.normalizeText(tok.Raw, st, changes))
+			continue
+		case blockElements[tok.Name]:
+			*st = typographyState{}
+		}
+		out.WriteString(tok.Raw)
+	}
+	return out.String(), changes
+}
+
+// normalizeText normalizes a run of text between tags, leaving entities
+// and kept terms alone
+func (t *typographer) normalizeText(raw string, st *typographyState, changes typographyChanges) string {
+	var out strings.Builder
+	last := 0
+	for _, loc := range t.keep.FindAllStringIndex(raw, -1) {
+		out.WriteString(t.normalizeRun(raw[last:loc[0]], st, changes))
+		kept := raw[loc[0]:loc[1]]
+		if t.rules["nbsp"] && nbspEntities[kept] {
+			kept = " "
+			changes["nbsp"]++
+		}
+		out.WriteString(kept)
+		st.prev = lastRune(html.UnescapeString(kept), st.prev)
+		last = loc[1]
+	}
+	out.WriteString(t.normalizeRun(raw[last:], st, changes))
+	return out.String()
+}
+
+// normalizeRun applies the rules to text with no entities or kept terms
+func (t *typographer) normalizeRun(s string, st *typographyState, changes typographyChanges) string {
+	if s == "" {
+		return s
+	}
+	if t.rules["nbsp"] {
+		changes["nbsp"] += strings.Count(s, "\u00a0")
+		s = strings.ReplaceAll(s, "\u00a0", " ")
+	}
+	if t.rules["ellipses"] {
+		s = replaceTypography(s, st.prev, ellipsisPattern, changes, "ellipses", func(match string, lineStart bool) string {
+			return t.style.Ellipsis
+		})
+	}
+	if t.rules["dashes"] {
+		s = replaceTypography(s, st.prev, dashPattern, changes, "dashes", func(match string, lineStart bool) string {
+			// Keep the indentation of a line that starts with a dash
+			if lineStart {
+				return match[:len(match)-len(strings.TrimLeft(match, " \t"))] + strings.TrimLeft(t.style.Dash, " ")
+			}
+			return t.style.Dash
+		})
+	}
+	if t.rules["spaces"] {
+		s = replaceTypography(s, st.prev, spacePunctPat, changes, "spaces", func(match string, lineStart bool) string {
+			if lineStart {
+				return match
+			}
+			return match[len(match)-1:]
+		})
+	}
+	if t.rules["quotes"] || t.rules["quote_style"] {
+		s = t.setQuotes(s, st, changes)
+	}
+	st.prev = lastRune(s, st.prev)
+	return s
+}
+
+// replaceTypography replaces the matches of a rule's pattern in s, counting
+// those that change. lineStart tells the replacement whether the match
+// begins a line, given prev, the character before s.
+func replaceTypography(s string, prev rune, pattern *regexp.Regexp, changes typographyChanges, rule string, replace func(match string, lineStart bool) string) string {
+	var out strings.Builder
+	last := 0
+	for _, loc := range pattern.FindAllStringIndex(s, -1) {
+		match := s[loc[0]:loc[1]]
+		before := lastRune(s[:loc[0]], prev)
+		// A full stop before a digit is a decimal point, as in .303
+		if rule == "spaces" && strings.HasSuffix(match, ".") {
+			if next, _ := utf8.DecodeRuneInString(s[loc[1]:]); unicode.IsDigit(next) {
+				continue
+			}
+		}
+		replacement := replace(match, before == 0 || before == '\n')
+		if replacement != match {
+			changes[rule]++
+		}
+		out.WriteString(s[last:loc[0]])
+		out.WriteString(replacement)
+		last = loc[1]
+	}
+	out.WriteString(s[last:])
+	return out.String()
+}
+
+// typographyState is what normalizing a block has seen of it so far
+type typographyState struct {
+	prev   rune   // the last character, which decides which way a quote faces
+	quotes []rune // the quotations open, by the kind of mark, ' or "
+}
+
+// setQuotes curls the straight quotes of s and, with quote_style, sets
+// each quotation in the marks of the style for its depth. A closing single
+// quote that no single quotation is open for is an apostrophe.
+func (t *typographer) setQuotes(s string, st *typographyState, changes typographyChanges) string {
+	if !strings.ContainsAny(s, `"'“”‘’`) {
+		return s
+	}
+	var out strings.Builder
+	prev := st.prev
+	for i, r := range s {
+		kind, opening, closing := quoteMark(r)
+		if kind == 0 || (!t.rules["quotes"] && (r == '"' || r == '\'')) {
+			out.WriteRune(r)
+			prev = r
+			continue
+		}
+		next, _ := utf8.DecodeRuneInString(s[i+utf8.RuneLen(r):])
+		if !opening && !closing {
+			// Straight quotes face the way their context does
+			opening = opensQuote(prev) && !(kind == '\'' && unicode.IsDigit(next))
+			closing = !opening
+		}
+
+		var mark rune
+		switch {
+		case opening:
+			st.quotes = append(st.quotes, kind)
+			mark = t.quoteMarks(kind, len(st.quotes))[0]
+		case kind == '\'' && (isWordRune(prev) && isWordRune(next) || !slices.Contains(st.quotes, '\'')):
+			// Apostrophes and elisions such as it's, the boys' or '42
+			mark = '’'
+		default:
+			depth := len(st.quotes)
+			if k := lastIndexRune(st.quotes, kind); k >= 0 {
+				depth = k + 1
+				st.quotes = st.quotes[:k]
+			}
+			mark = t.quoteMarks(kind, max(depth, 1))[1]
+		}
+
+		if mark != r {
+			if r == '"' || r == '\'' {
+				changes["quotes"]++
+			} else {
+				changes["quote_style"]++
+			}
+		}
+		out.WriteRune(mark)
+		prev = mark
+	}
+	return out.String()
+}
+
+// quoteMark returns the kind of a quotation mark, ' or ", and whether it
+// can only open or only close a quotation. kind is 0 for other characters.
+func quoteMark(r rune) (kind rune, opening, closing bool) {
+	switch r {
+	case '"':
+		return '"', false, false
+	case '“':
+		return '"', true, false
+	case '”':
+		return '"', false, true
+	case '\'':
+		return '\'', false, false
+	case '‘':
+		return '\'', true, false
+	case '’':
+		return '\'', false, true
+	}
+	return 0, false, false
+}
+
+// quoteMarks returns the opening and closing marks of a quotation of a
+// kind at a depth, 1 being the outermost: the style's marks alternate with
+// quote_style, otherwise the kind is kept
+func (t *typographer) quoteMarks(kind rune, depth int) [2]rune {
+	switch {
+	case !t.rules["quote_style"] && kind == '"':
+		return [2]rune{'“', '”'}
+	case !t.rules["quote_style"]:
+		return [2]rune{'‘', '’'}
+	case depth%2 == 1:
+		return t.style.Quotes
+	}
+	return t.style.Inner
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func lastIndexRune(runes []rune, r rune) int {
+	for i := len(runes) - 1; i >= 0; i-- {
+		if runes[i] == r {
+			return i
+		}
+	}
+	return -1
+}
+
+// opensQuote reports whether a quote after the character prev opens a
+// quotation rather than closing one
+func opensQuote(prev rune) bool {
+	return prev == 0 || unicode.IsSpace(prev) || strings.ContainsRune("([{“‘—–-/", prev)
+}
+
+// lastRune returns the last character of s, or prev if s is empty
+func lastRune(s string, prev rune) rune {
+	if r, size := utf8.DecodeLastRuneInString(s); size > 0 {
+		return r
+	}
+	return prev
+}
+
+// runTypography shows how normalizing the punctuation of a book's chapters,
+// or of the chapters named, would change them, and with -write rewrites them
+func runTypography(cfg *config, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	bookSlug, only := args[0], args[1:]
+
+	book, err := readBookIndex(bookSlug)
+	if err != nil {
+		return fmt.Errorf("book %s: %w", bookSlug, err)
+	}
+	for _, slug := range only {
+		if !slices.ContainsFunc(book.Chapters, func(ch ChapterInfo) bool { return ch.Slug == slug }) {
+			return fmt.Errorf("book %s has no chapter %s", bookSlug, slug)
+		}
+	}
+
+	config, err := loadTypographyConfig(bookSlug)
+	if err != nil {
+		return fmt.Errorf("typography.yaml: %w", err)
+	}
+	if cfg.Style != "" {
+		config.Style = cfg.Style
+		if err := config.check(); err != nil {
+			return err
+		}
+	}
+	t, err := newTypographer(bookSlug, config)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join("books", bookSlug, "chapters")
+	total := typographyChanges{}
+	chapters := 0
+	for _, ch := range book.Chapters {
+		if len(only) > 0 && !slices.Contains(only, ch.Slug) {
+			continue
+		}
+		path := filepath.Join(dir, ch.Slug+".xhtml")
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// Markdown is only normalized once rendered
+			if _, err := os.Stat(filepath.Join(dir, ch.Slug+".md")); err == nil {
+				fmt.Printf("%s: skipped, Markdown chapters are normalized as they are loaded if on_load is set\n", filepath.Join(dir, ch.Slug+".md"))
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		normalized, changes := t.normalize(string(data))
+		if changes.total() == 0 {
+			continue
+		}
+		chapters++
+		for rule, n := range changes {
+			total[rule] += n
+		}
+		fmt.Printf("%s: %s\n", path, changes)
+		writeTypographyDiff(os.Stdout, string(data), normalized)
+
+		if cfg.Write {
+			if err := os.WriteFile(path, []byte(normalized), 0644); err != nil {
+				return err
+			}
+		}
+	}
+
+	switch {
+	case chapters == 0:
+		fmt.Println("No changes")
+	case cfg.Write:
+		fmt.Printf("Rewrote %d chapter(s): %s\n", chapters, total)
+	default:
+		fmt.Printf("%d chapter(s) would change: %s\nRun with -write to rewrite them\n", chapters, total)
+	}
+	return nil
+}
+
+// typographyDiffContext is how much of a changed line is shown around the
+// change
+const typographyDiffContext = 30
+
+// writeTypographyDiff writes the lines that differ between a chapter and
+// its normalized markup, which has the same lines, trimmed to the change
+func writeTypographyDiff(w io.Writer, old, new string) {
+	oldLines, newLines := strings.Split(old, "\n"), strings.Split(new, "\n")
+	for i := range oldLines {
+		if i >= len(newLines) || oldLines[i] == newLines[i] {
+			continue
+		}
+		a, b := trimToChange(oldLines[i], newLines[i])
+		fmt.Fprintf(w, "  %5d - %s\n  %5d + %s\n", i+1, a, i+1, b)
+	}
+}
+
+// trimToChange shortens two versions of a line to the part that differs
+// and some context on either side
+func trimToChange(a, b string) (string, string) {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	start := max(prefix-typographyDiffContext, 0)
+	for start > 0 && !utf8.RuneStart(a[start]) {
+		start--
+	}
+	trim := func(s string) string {
+		end := min(len(s)-suffix+typographyDiffContext, len(s))
+		for end < len(s) && !utf8.RuneStart(s[end]) {
+			end++
+		}
+		out := strings.TrimSpace(s[start:end])
+		if start > 0 {
+			out = "…" + out
+		}
+		if end < len(s) {
+			out += "…"
+		}
+		return out
+	}
+	return trim(a), trim(b)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// inContentRoot makes a temporary content root the working directory for
+// the rest of the test, writing the given files into it
+func inContentRoot(t *testing.T, files map[string]string) {
+	t.Helper()
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func TestTypographyNormalize(t *testing.T) {
+	inContentRoot(t, map[string]string{
+		"books/b/glossary.yaml": "terms:\n  - term: \"Ma'ahir\"\n    definition: \"A title.\"\n",
+	})
+
+	tests := []struct {
+		name   string
+		config TypographyConfig
+		in     string
+		want   string
+	}{
+		// Quotes
+		{"double quotes", TypographyConfig{}, `<p>He said "yes".</p>`, `<p>He said “yes”.</p>`},
+		{"apostrophes", TypographyConfig{}, `<p>It's six o'clock in the boys' camp.</p>`, `<p>It’s six o’clock in the boys’ camp.</p>`},
+		{"elision", TypographyConfig{}, `<p>The '90s.</p>`, `<p>The ’90s.</p>`},
+		{"american nesting", TypographyConfig{}, `<p>"He said 'go' now," she wrote.</p>`, `<p>“He said ‘go’ now,” she wrote.</p>`},
+		{"british nesting", TypographyConfig{Style: "british"}, `<p>"He said 'go' now," she wrote.</p>`, `<p>‘He said “go” now,’ she wrote.</p>`},
+		{"british from curly", TypographyConfig{Style: "british"}, `<p>“since defunct” and ‘The Translator’</p>`, `<p>‘since defunct’ and ‘The Translator’</p>`},
+		{"american from curly", TypographyConfig{}, `<p>‘Yes’, he said, ‘the “old days” were better.’</p>`, `<p>“Yes”, he said, “the ‘old days’ were better.”</p>`},
+		{"apostrophe in a single quotation", TypographyConfig{Style: "british"}, `<p>'It's late,' he said.</p>`, `<p>‘It’s late,’ he said.</p>`},
+		{"quotes around inline markup", TypographyConfig{}, `<p>"<em>Yes</em>," he said.</p>`, `<p>“<em>Yes</em>,” he said.</p>`},
+		{"blocks start afresh", TypographyConfig{}, `<p>"Unclosed</p><p>"Again"</p>`, `<p>“Unclosed</p><p>“Again”</p>`},
+		{"style without curling", TypographyConfig{Style: "british", Rules: map[string]bool{"quotes": false}}, `<p>"straight" and “curly”</p>`, `<p>"straight" and ‘curly’</p>`},
+		{"curling without style", TypographyConfig{Style: "british", Rules: map[string]bool{"quote_style": false}}, `<p>"straight" and “curly”</p>`, `<p>“straight” and “curly”</p>`},
+
+		// Dashes, ellipses, spaces and non-breaking spaces
+		{"american dashes", TypographyConfig{}, `<p>left -- then a – b --- c — d</p>`, `<p>left—then a—b—c—d</p>`},
+		{"british dashes", TypographyConfig{Style: "british"}, `<p>left -- then a—b</p>`, `<p>left – then a – b</p>`},
+		{"ranges and hyphens", TypographyConfig{}, `<p>1812–1814, well-known</p>`, `<p>1812–1814, well-known</p>`},
+		{"indented dash", TypographyConfig{}, "<p>one\n    -- two</p>", "<p>one\n    —two</p>"},
+		{"ellipses", TypographyConfig{}, `<p>Then... she waited . . . long</p>`, `<p>Then… she waited … long</p>`},
+		{"space before punctuation", TypographyConfig{}, `<p>left , then ; ok ? done .</p>`, `<p>left, then; ok? done.</p>`},
+		{"decimal point", TypographyConfig{}, `<p>a .303 rifle</p>`, `<p>a .303 rifle</p>`},
+		{"non-breaking spaces", TypographyConfig{}, "<p>a&nbsp;b c&#160;d</p>", `<p>a b c d</p>`},
+		{"rule off", TypographyConfig{Rules: map[string]bool{"dashes": false}}, `<p>"a" -- b</p>`, `<p>“a” -- b</p>`},
+
+		// Left alone
+		{"code", TypographyConfig{}, `<p>Run <code>echo "hi" -- x...</code> now</p>`, `<p>Run <code>echo "hi" -- x...</code> now</p>`},
+		{"preformatted", TypographyConfig{}, "<pre>a -- b\n\"c\"</pre>", "<pre>a -- b\n\"c\"</pre>"},
+		{"attributes", TypographyConfig{}, `<p><a href="a--b" title="it's 'x'">it's</a></p>`, `<p><a href="a--b" title="it's 'x'">it’s</a></p>`},
+		{"comments", TypographyConfig{}, `<!-- "draft" -- later --><p>"a"</p>`, `<!-- "draft" -- later --><p>“a”</p>`},
+		{"transliterated text", TypographyConfig{}, `<p>The <i lang="hi-Latn">Ba'at "x"</i> said "y"</p>`, `<p>The <i lang="hi-Latn">Ba'at "x"</i> said “y”</p>`},
+		{"nested transliterated text", TypographyConfig{}, `<p><span xml:lang="ur"><span>a'b</span> "c"</span> "d"</p>`, `<p><span xml:lang="ur"><span>a'b</span> "c"</span> “d”</p>`},
+		{"glossary term", TypographyConfig{}, `<p>The Ma'ahir's men</p>`, `<p>The Ma'ahir’s men</p>`},
+		{"keep list", TypographyConfig{Keep: []string{"Ba'at"}}, `<p>A Ba'at and a b'c</p>`, `<p>A Ba'at and a b’c</p>`},
+		{"entities", TypographyConfig{}, `<p>&amp; &#8220;x&#8221; "y"</p>`, `<p>&amp; &#8220;x&#8221; “y”</p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := tt.config
+			if config.Style == "" {
+				config.Style = defaultTypographyStyle
+			}
+			typo, err := newTypographer("b", &config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := typo.normalize(tt.in)
+			if got != tt.want {
+				t.Errorf("normalize(%q)\n got %q\nwant %q", tt.in, got, tt.want)
+			}
+			if strings.Count(got, "\n") != strings.Count(tt.in, "\n") {
+				t.Errorf("normalize changed the number of lines")
+			}
+		})
+	}
+}
+
+func TestTypographyChanges(t *testing.T) {
+	typo, err := newTypographer("none", &TypographyConfig{Style: "british"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, changes := typo.normalize(`<p>"a" -- “b”... c , d&nbsp;e</p>`)
+	if got, want := changes.String(), "1 nbsp, 1 ellipses, 1 dashes, 1 spaces, 2 quotes, 2 quote_style"; got != want {
+		t.Errorf("changes = %q, want %q", got, want)
+	}
+
+	_, changes = typo.normalize(`<p>‘Already’ – set…</p>`)
+	if changes.total() != 0 {
+		t.Errorf("changes to normalized text = %v, want none", changes)
+	}
+}
+
+func TestLoadTypographyConfig(t *testing.T) {
+	inContentRoot(t, map[string]string{
+		"books/good/typography.yaml":  "style: british\non_load: true\nrules:\n  dashes: false\n",
+		"books/style/typography.yaml": "style: german\n",
+		"books/rule/typography.yaml":  "rules:\n  kerning: false\n",
+	})
+
+	config, err := loadTypographyConfig("good")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Style != "british" || !config.OnLoad || config.Rules["dashes"] {
+		t.Errorf("config = %+v", config)
+	}
+	if config, err := loadTypographyConfig("missing"); err != nil || config.Style != defaultTypographyStyle || config.OnLoad {
+		t.Errorf("config of a book without typography.yaml = %+v, %v", config, err)
+	}
+	for _, slug := range []string{"style", "rule"} {
+		if _, err := loadTypographyConfig(slug); err == nil {
+			t.Errorf("%s: no error", slug)
+		}
+	}
+}
+
+func TestReadChapterNormalizesOnLoad(t *testing.T) {
+	chapter := `<p>"Come," he said -- and went...</p>`
+	inContentRoot(t, map[string]string{
+		"books/on/metadata.yaml":          "title: On\n",
+		"books/on/chapters.yaml":          "chapters:\n  - slug: one\n    title: One\n",
+		"books/on/typography.yaml":        "on_load: true\n",
+		"books/on/chapters/one.xhtml":     chapter,
+		"books/off/metadata.yaml":         "title: Off\n",
+		"books/off/chapters.yaml":         "chapters:\n  - slug: one\n    title: One\n",
+		"books/off/typography.yaml":       "style: british\n",
+		"books/off/chapters/one.xhtml":    chapter,
+		"books/broken/metadata.yaml":      "title: Broken\n",
+		"books/broken/chapters.yaml":      "chapters:\n  - slug: one\n    title: One\n",
+		"books/broken/typography.yaml":    "style: german\n",
+		"books/broken/chapters/one.xhtml": chapter,
+	})
+
+	tests := []struct {
+		slug string
+		want string
+	}{
+		{"on", `<p>“Come,” he said—and went…</p>`},
+		{"off", chapter},
+	}
+	for _, tt := range tests {
+		book, err := loadBook(tt.slug)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (book.Typographer != nil) != (tt.slug == "on") {
+			t.Errorf("%s: typographer = %v", tt.slug, book.Typographer)
+		}
+		got, err := readChapter(book, "one")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: chapter = %s, want %s", tt.slug, got, tt.want)
+		}
+	}
+
+	if _, err := loadBook("broken"); err == nil || !strings.Contains(err.Error(), "typography.yaml") {
+		t.Errorf("book with a broken typography.yaml: %v", err)
+	}
+}
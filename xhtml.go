@@ -0,0 +1,175 @@
+package main
+
+import (
+	"html"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// htmlTokenKind identifies the kind of an htmlToken
+type htmlTokenKind int
+
+const (
+	textToken htmlTokenKind = iota
+	startTagToken
+	endTagToken
+	selfClosingTagToken
+	commentToken
+)
+
+// htmlToken represents a tag, comment or run of text in chapter markup
+type htmlToken struct {
+	Kind  htmlTokenKind
+	Name  string
+	Attrs map[string]string
+	Raw   string
+}
+
+var (
+	tagPattern  = regexp.MustCompile(`(?s)<!--.*?-->|<[!?/]?[a-zA-Z][^>]*>`)
+	attrPattern = regexp.MustCompile(`([a-zA-Z_:][-a-zA-Z0-9_:.]*)\s*=\s*(?:"([^"]*)"|'([^']*)')`)
+)
+
+// voidElements are HTML elements that never have an end tag
+var voidElements = map[string]bool{
+	"br": true, "hr": true, "img": true, "input": true, "meta": true, "link": true, "col": true, "wbr": true,
+}
+
+// tokenizeHTML splits chapter markup into tags, comments and text. It is
+// deliberately lenient: the transcribed chapters are XHTML fragments that
+// aren't always well-formed, so no attempt is made to balance tags.
+func tokenizeHTML(content string) []htmlToken {
+	var tokens []htmlToken
+	last := 0
+	for _, loc := range tagPattern.FindAllStringIndex(content, -1) {
+		if loc[0] > last {
+			tokens = append(tokens, htmlToken{Kind: textToken, Raw: content[last:loc[0]]})
+		}
+		tokens = append(tokens, parseTag(content[loc[0]:loc[1]]))
+		last = loc[1]
+	}
+	if last < len(content) {
+		tokens = append(tokens, htmlToken{Kind: textToken, Raw: content[last:]})
+	}
+	return tokens
+}
+
+func parseTag(raw string) htmlToken {
+	if strings.HasPrefix(raw, "<!--") || strings.HasPrefix(raw, "<!") || strings.HasPrefix(raw, "<?") {
+		return htmlToken{Kind: commentToken, Raw: raw}
+	}
+
+	inner := strings.TrimSuffix(strings.TrimPrefix(raw, "<"), ">")
+	kind := startTagToken
+	if strings.HasPrefix(inner, "/") {
+		kind = endTagToken
+		inner = inner[1:]
+	} else if strings.HasSuffix(inner, "/") {
+		kind = selfClosingTagToken
+		inner = strings.TrimSuffix(inner, "/")
+	}
+
+	name := inner
+	if i := strings.IndexAny(inner, " \t\r\n"); i >= 0 {
+		name = inner[:i]
+	}
+	name = strings.ToLower(name)
+	if kind == startTagToken && voidElements[name] {
+		kind = selfClosingTagToken
+	}
+
+	attrs := map[string]string{}
+	for _, m := range attrPattern.FindAllStringSubmatch(inner[len(name):], -1) {
+		value := m[2]
+		if value == "" {
+			value = m[3]
+		}
+		attrs[strings.ToLower(m[1])] = html.UnescapeString(value)
+	}
+
+	return htmlToken{Kind: kind, Name: name, Attrs: attrs, Raw: raw}
+}
+
+// Text returns the unescaped text of a text token
+func (t htmlToken) Text() string {
+	return html.UnescapeString(t.Raw)
+}
+
+// isFootnote reports whether a tag opens an EPUB footnote
+func (t htmlToken) isFootnote() bool {
+	return t.Kind == startTagToken && t.Name == "aside" &&
+		(t.Attrs["epub:type"] == "footnote" || t.Attrs["epub:type"] == "endnote" || strings.HasPrefix(t.Attrs["id"], "footnote"))
+}
+
+// isNoteRef reports whether a tag opens a link to an EPUB footnote
+func (t htmlToken) isNoteRef() bool {
+	return t.Kind == startTagToken && t.Name == "a" &&
+		(t.Attrs["epub:type"] == "noteref" || strings.HasPrefix(t.Attrs["href"], "#footnote"))
+}
+
+// isHeading reports whether a tag is an h1-h6 element
+func (t htmlToken) isHeading() bool {
+	return len(t.Name) == 2 && t.Name[0] == 'h' && t.Name[1] >= '1' && t.Name[1] <= '6'
+}
+
+var whitespacePattern = regexp.MustCompile(`\s+`)
+
+// collapseWhitespace replaces runs of whitespace with a single space
+func collapseWhitespace(s string) string {
+	return whitespacePattern.ReplaceAllString(s, " ")
+}
+
+// plainText returns the running text of chapter or post markup, leaving
+// out footnotes and footnote reference numbers
+func plainText(content string) string {
+	var out strings.Builder
+	skipDepth := 0
+	for _, tok := range tokenizeHTML(content) {
+		switch {
+		case tok.isFootnote() || tok.isNoteRef() || (tok.Kind == startTagToken && tok.Attrs["class"] == "permalink"):
+			skipDepth++
+		case skipDepth > 0 && tok.Kind == startTagToken && (tok.Name == "aside" || tok.Name == "a"):
+			skipDepth++
+		case skipDepth > 0 && tok.Kind == endTagToken && (tok.Name == "aside" || tok.Name == "a"):
+			skipDepth--
+		case skipDepth == 0 && tok.Kind == textToken:
+			out.WriteString(tok.Text())
+		case skipDepth == 0 && tok.Kind != textToken && blockElements[tok.Name]:
+			out.WriteString(" ")
+		}
+	}
+	return strings.TrimSpace(collapseWhitespace(out.String()))
+}
+
+// blockElements are elements whose boundaries separate words
+var blockElements = map[string]bool{
+	"p": true, "br": true, "div": true, "section": true, "blockquote": true, "li": true, "hr": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "aside": true, "td": true, "th": true,
+}
+
+// firstWords shortens text to at most n words, marking any cut with an
+// ellipsis
+func firstWords(text string, n int) string {
+	words := strings.Fields(text)
+	if len(words) <= n {
+		return text
+	}
+	return strings.TrimRight(strings.Join(words[:n], " "), " ,;:.") + "…"
+}
+
+// truncateWords shortens text to at most max bytes, cutting at a word
+// boundary and marking the cut with an ellipsis
+func truncateWords(text string, max int) string {
+	if len(text) <= max {
+		return text
+	}
+	cut := strings.LastIndex(text[:max], " ")
+	if cut <= 0 {
+		cut = max
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+	}
+	return strings.TrimRight(text[:cut], " ,;:.") + "…"
+}